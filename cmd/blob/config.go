@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	ospkg "os"
+	"strings"
+	"time"
+
+	"go.adoublef/blob/internal/database/sql"
+	"go.adoublef/blob/internal/log"
+	"go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/os"
+)
+
+const (
+	DefaultAddr            = ":8080"
+	DefaultMetricsAddr     = ":9090"
+	DefaultRegion          = "auto"
+	DefaultShutdownTimeout = 30 * time.Second
+)
+
+// config of the server. Each option can be set by a flag, an environment
+// variable or a key in a JSON config file, in that order of precedence.
+type config struct {
+	Addr            string
+	MetricsAddr     string
+	Bucket          string
+	Endpoint        string
+	Region          string
+	PathStyle       bool
+	AccessKeyID     string
+	SecretAccessKey string
+	TLSCert         string
+	TLSKey          string
+	APIKeys         string
+	JWKS            string
+	JWTIssuer       string
+	JWTAudience     string
+	JWTScopeClaim   string
+	JWTScopeMap     string
+	JWTTenantClaim  string
+	URLSigningKeys  string
+	PublicURL       string
+	PresignExpires  time.Duration
+	CORSOrigins     string
+	CORSMethods     string
+	CORSHeaders     string
+	CORSExposed     string
+	CORSCredentials bool
+	CORSMaxAge      time.Duration
+	MetadataDB      string
+	Backfill        bool
+	ReapInterval    time.Duration
+	TrashRetention  time.Duration
+	MaxFileSize     int64
+	DiskPath        string
+	DiskMinFree     int64
+	Quota           http.Quota
+	UserQuota       http.Quota
+	Tenants         string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	ShutdownDelay   time.Duration
+	MaxHeaderBytes  int
+	RateLimit       float64
+	RateBurst       int
+	AddrRateLimit   float64
+	AddrRateBurst   int
+	MaxTransfers    int
+	Bandwidth       int64
+	TraceExporter   string
+	LogFormat       string
+	LogLevel        slog.Level
+}
+
+// env returns the name of the environment variable of an option.
+//
+//	"access-key-id" -> "BLOB_ACCESS_KEY_ID"
+func env(name string) string {
+	return "BLOB_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// parseConfig returns the config from the command line arguments, the
+// environment and the config file.
+func parseConfig(args []string, getenv func(string) string, stderr io.Writer) (*config, error) {
+	c := &config{
+		Addr:            DefaultAddr,
+		MetricsAddr:     DefaultMetricsAddr,
+		Region:          DefaultRegion,
+		ReadTimeout:     http.DefaultReadTimeout,
+		WriteTimeout:    http.DefaultWriteTimeout,
+		IdleTimeout:     http.DefaultIdleTimeout,
+		ShutdownTimeout: DefaultShutdownTimeout,
+		PresignExpires:  os.DefaultPresignExpires,
+		CORSMaxAge:      http.DefaultCORSMaxAge,
+		ReapInterval:    sql.DefaultReaperInterval,
+		TrashRetention:  sql.DefaultTrashRetention,
+		MaxHeaderBytes:  http.DefaultMaxHeaderBytes,
+		JWTScopeClaim:   http.DefaultJWTScopeClaim,
+		LogFormat:       log.FormatJSON,
+		LogLevel:        slog.LevelInfo,
+	}
+
+	fs := flag.NewFlagSet("blob", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	filename := fs.String("config", getenv(env("config")), "path to a JSON config file")
+	fs.StringVar(&c.Addr, "addr", c.Addr, "address to listen on")
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", c.MetricsAddr, "address to serve metrics on, apart from the files of every tenant, disabled if empty")
+	fs.StringVar(&c.Bucket, "bucket", c.Bucket, "name of the storage bucket")
+	fs.StringVar(&c.Endpoint, "endpoint", c.Endpoint, "url of an S3 compatible endpoint")
+	fs.StringVar(&c.Region, "region", c.Region, "region of the storage bucket")
+	fs.BoolVar(&c.PathStyle, "path-style", c.PathStyle, "address the bucket as part of the path")
+	fs.StringVar(&c.AccessKeyID, "access-key-id", c.AccessKeyID, "access key of the storage credentials")
+	fs.StringVar(&c.SecretAccessKey, "secret-access-key", c.SecretAccessKey, "secret key of the storage credentials")
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "path to a TLS certificate")
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "path to the TLS certificate key")
+	fs.StringVar(&c.APIKeys, "api-keys", c.APIKeys, "path to a JSON file of hashed API keys, requests are not authenticated if empty")
+	fs.StringVar(&c.JWKS, "jwks", c.JWKS, "path or url of the JSON Web Key Set that bearer tokens are verified with")
+	fs.StringVar(&c.JWTIssuer, "jwt-issuer", c.JWTIssuer, "required issuer of bearer tokens")
+	fs.StringVar(&c.JWTAudience, "jwt-audience", c.JWTAudience, "required audience of bearer tokens")
+	fs.StringVar(&c.JWTScopeClaim, "jwt-scope-claim", c.JWTScopeClaim, "claim of bearer tokens holding the scopes of the caller")
+	fs.StringVar(&c.JWTScopeMap, "jwt-scope-map", c.JWTScopeMap, `JSON object mapping values of the scope claim to scopes, such as {"admins": ["files:read"]}`)
+	fs.StringVar(&c.JWTTenantClaim, "jwt-tenant-claim", c.JWTTenantClaim, "claim of bearer tokens holding the tenant of the caller, callers belong to the default tenant if empty")
+	fs.StringVar(&c.URLSigningKeys, "url-signing-keys", c.URLSigningKeys, "comma separated kid=base64 keys that sign download urls, the first signs new urls")
+	fs.StringVar(&c.PublicURL, "public-url", c.PublicURL, "scheme and host of signed urls, required with url-signing-keys")
+	fs.StringVar(&c.MetadataDB, "metadata-db", c.MetadataDB, "path to the SQLite database of file metadata, metadata is not recorded if empty")
+	fs.BoolVar(&c.Backfill, "backfill-metadata", c.Backfill, "record the files in the bucket without metadata, such as those uploaded before metadata-db was set, before serving them")
+	fs.DurationVar(&c.ReapInterval, "reap-interval", c.ReapInterval, "time between deleting expired files and files past their trash retention, none are deleted if zero")
+	fs.DurationVar(&c.TrashRetention, "trash-retention", c.TrashRetention, "time a deleted file can be restored from the trash before it is purged")
+	fs.Int64Var(&c.MaxFileSize, "max-file-size", c.MaxFileSize, "size in bytes of the largest file that can be uploaded, unlimited if zero")
+	fs.StringVar(&c.DiskPath, "disk-path", c.DiskPath, "path whose file system must have disk-min-free bytes available for the server to be ready, such as that of the metadata database, unchecked if empty")
+	fs.Int64Var(&c.DiskMinFree, "disk-min-free", c.DiskMinFree, "bytes that must be available on disk-path")
+	fs.Int64Var(&c.Quota.Bytes, "quota-bytes", c.Quota.Bytes, "bytes that can be stored by all callers, unlimited if zero")
+	fs.Int64Var(&c.Quota.Objects, "quota-objects", c.Quota.Objects, "files that can be stored by all callers, unlimited if zero")
+	fs.Int64Var(&c.UserQuota.Bytes, "user-quota-bytes", c.UserQuota.Bytes, "bytes that can be stored by each caller, unlimited if zero")
+	fs.Int64Var(&c.UserQuota.Objects, "user-quota-objects", c.UserQuota.Objects, "files that can be stored by each caller, unlimited if zero")
+	fs.StringVar(&c.Tenants, "tenants", c.Tenants, "path to a JSON file of tenants served next to the default tenant, each isolated in its own prefix or bucket")
+	fs.StringVar(&c.CORSOrigins, "cors-origins", c.CORSOrigins, `comma separated origins allowed to make requests from scripts, such as https://app.example or "*", none if empty`)
+	fs.StringVar(&c.CORSMethods, "cors-methods", c.CORSMethods, "comma separated methods allowed from other origins, the methods of the API if empty")
+	fs.StringVar(&c.CORSHeaders, "cors-headers", c.CORSHeaders, `comma separated request headers allowed from other origins, where a trailing "*" matches a prefix, the headers of the API if empty`)
+	fs.StringVar(&c.CORSExposed, "cors-exposed-headers", c.CORSExposed, `comma separated response headers readable by other origins, where a trailing "*" matches a prefix, the headers of the API if empty`)
+	fs.BoolVar(&c.CORSCredentials, "cors-credentials", c.CORSCredentials, "allow other origins to make requests with credentials")
+	fs.DurationVar(&c.CORSMaxAge, "cors-max-age", c.CORSMaxAge, "time browsers can cache the result of a preflight request")
+	fs.DurationVar(&c.PresignExpires, "presign-expires", c.PresignExpires, "validity of requests presigned for the bucket, presigning is disabled if zero")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "maximum duration for reading a request")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "maximum duration for writing a response")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "maximum duration to wait for the next request")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "maximum duration to drain requests on shutdown")
+	fs.DurationVar(&c.ShutdownDelay, "shutdown-delay", c.ShutdownDelay, "duration to report not ready before draining requests")
+	fs.IntVar(&c.MaxHeaderBytes, "max-header-bytes", c.MaxHeaderBytes, "maximum size of request headers")
+	fs.Float64Var(&c.RateLimit, "rate-limit", c.RateLimit, "requests per second each client can make, unlimited if zero")
+	fs.IntVar(&c.RateBurst, "rate-burst", c.RateBurst, "requests each client can make at once, the rate limit if zero")
+	fs.Float64Var(&c.AddrRateLimit, "addr-rate-limit", c.AddrRateLimit, "requests per second each IP address can make before authentication, the rate limit if zero")
+	fs.IntVar(&c.AddrRateBurst, "addr-rate-burst", c.AddrRateBurst, "requests each IP address can make at once before authentication, the addr rate limit if zero")
+	fs.IntVar(&c.MaxTransfers, "max-transfers", c.MaxTransfers, "uploads and downloads each client can have in progress, unlimited if zero")
+	fs.Int64Var(&c.Bandwidth, "bandwidth", c.Bandwidth, "bytes per second shared by all uploads and downloads, unlimited if zero")
+	fs.StringVar(&c.TraceExporter, "trace-exporter", c.TraceExporter, `exporter of traces: "stdout", "otlp" or none if empty`)
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, `format of log records: "json" or "text"`)
+	fs.TextVar(&c.LogLevel, "log-level", c.LogLevel, `minimum level of log records: "debug", "info", "warn" or "error"`)
+	fs.VisitAll(func(f *flag.Flag) { f.Usage += fmt.Sprintf(" (env %s)", env(f.Name)) })
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %q", fs.Args())
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// the environment takes precedence over the file
+	var file map[string]json.RawMessage
+	if *filename != "" {
+		p, err := ospkg.ReadFile(*filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := json.Unmarshal(p, &file); err != nil {
+			return nil, fmt.Errorf("failed to decode config file: %w", err)
+		}
+	}
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || f.Name == "config" {
+			return
+		}
+		s := getenv(env(f.Name))
+		if s == "" {
+			v, ok := file[f.Name]
+			if !ok {
+				return
+			}
+			// other values, such as numbers, are set as written
+			s = string(v)
+			if err := json.Unmarshal(v, &s); err != nil && v[0] == '"' {
+				errs = append(errs, fmt.Errorf("invalid value %s for %s: %w", v, f.Name, err))
+				return
+			}
+		}
+		if err := f.Value.Set(s); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q for %s: %w", s, f.Name, err))
+		}
+	})
+	for k := range file {
+		if fs.Lookup(k) == nil || k == "config" {
+			errs = append(errs, fmt.Errorf("unknown option %q in config file", k))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return c, c.validate()
+}
+
+// validate reports any misconfiguration.
+func (c *config) validate() error {
+	var errs []error
+	if c.Addr == "" {
+		errs = append(errs, errors.New("addr is required"))
+	}
+	if c.MetricsAddr != "" && c.MetricsAddr == c.Addr {
+		errs = append(errs, errors.New("metrics-addr must differ from addr"))
+	}
+	if c.Bucket == "" {
+		errs = append(errs, errors.New("bucket is required"))
+	}
+	if (c.AccessKeyID == "") != (c.SecretAccessKey == "") {
+		errs = append(errs, errors.New("access-key-id and secret-access-key must be set together"))
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("tls-cert and tls-key must be set together"))
+	}
+	if c.JWKS == "" && (c.JWTIssuer != "" || c.JWTAudience != "" || c.JWTScopeMap != "" || c.JWTTenantClaim != "") {
+		errs = append(errs, errors.New("jwt options require jwks"))
+	}
+	if _, err := c.scopeMap(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid jwt-scope-map: %w", err))
+	}
+	if c.URLSigningKeys != "" {
+		if _, err := c.urlSigner(""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid url-signing-keys: %w", err))
+		}
+		// the host of a request is chosen by the client, and its scheme is
+		// lost behind a proxy terminating tls
+		if c.PublicURL == "" {
+			errs = append(errs, errors.New("url-signing-keys requires public-url"))
+		}
+	} else if c.PublicURL != "" {
+		errs = append(errs, errors.New("public-url requires url-signing-keys"))
+	}
+	// urls are verified by the path the server sees, which has no prefix
+	if u, err := url.Parse(c.PublicURL); c.PublicURL != "" && (err != nil || u.Scheme == "" || u.Host == "" || strings.Trim(u.Path, "/") != "" || u.RawQuery != "") {
+		errs = append(errs, fmt.Errorf("invalid public-url %q: want scheme and host only", c.PublicURL))
+	}
+	if c.Tenants != "" {
+		if _, err := c.tenants(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid tenants: %w", err))
+		}
+		// anonymous requests would pick their tenant by the host they send
+		if c.APIKeys == "" && c.JWKS == "" {
+			errs = append(errs, errors.New("tenants require api-keys or jwks"))
+		}
+	}
+	for _, n := range []struct {
+		name string
+		n    int64
+	}{
+		{"max-file-size", c.MaxFileSize},
+		{"disk-min-free", c.DiskMinFree},
+		{"quota-bytes", c.Quota.Bytes},
+		{"quota-objects", c.Quota.Objects},
+		{"user-quota-bytes", c.UserQuota.Bytes},
+		{"user-quota-objects", c.UserQuota.Objects},
+		{"rate-burst", int64(c.RateBurst)},
+		{"addr-rate-burst", int64(c.AddrRateBurst)},
+		{"max-transfers", int64(c.MaxTransfers)},
+		{"bandwidth", c.Bandwidth},
+	} {
+		if n.n < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", n.name))
+		}
+	}
+	if c.CORSOrigins == "" && (c.CORSMethods != "" || c.CORSHeaders != "" || c.CORSExposed != "" || c.CORSCredentials) {
+		errs = append(errs, errors.New("cors options require cors-origins"))
+	}
+	for _, o := range splitList(c.CORSOrigins) {
+		if u, err := url.Parse(o); o != "*" && (err != nil || u.Scheme == "" || u.Host == "" || u.Path != "") {
+			errs = append(errs, fmt.Errorf("invalid cors origin %q", o))
+		}
+		if o == "*" && c.CORSCredentials {
+			errs = append(errs, errors.New("cors-credentials cannot allow every origin"))
+		}
+	}
+	if c.DiskMinFree > 0 && c.DiskPath == "" {
+		errs = append(errs, errors.New("disk-min-free requires disk-path"))
+	}
+	if c.RateLimit < 0 {
+		errs = append(errs, errors.New("rate-limit must not be negative"))
+	}
+	if c.RateBurst > 0 && c.RateLimit == 0 {
+		errs = append(errs, errors.New("rate-burst requires rate-limit"))
+	}
+	if c.AddrRateLimit < 0 {
+		errs = append(errs, errors.New("addr-rate-limit must not be negative"))
+	}
+	if c.AddrRateBurst > 0 && c.AddrRateLimit == 0 {
+		errs = append(errs, errors.New("addr-rate-burst requires addr-rate-limit"))
+	}
+	if c.MetadataDB == "" && c.Backfill {
+		errs = append(errs, errors.New("backfill-metadata requires metadata-db"))
+	}
+	if c.MetadataDB == "" && (c.Quota != http.Quota{} || c.UserQuota != http.Quota{}) {
+		errs = append(errs, errors.New("quotas require metadata-db"))
+	}
+	jwksFile := c.JWKS
+	if strings.HasPrefix(jwksFile, "https://") || strings.HasPrefix(jwksFile, "http://") {
+		jwksFile = ""
+	}
+	for _, f := range []string{c.TLSCert, c.TLSKey, c.APIKeys, jwksFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := ospkg.Stat(f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, d := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"read-timeout", c.ReadTimeout},
+		{"write-timeout", c.WriteTimeout},
+		{"idle-timeout", c.IdleTimeout},
+		{"shutdown-timeout", c.ShutdownTimeout},
+		{"shutdown-delay", c.ShutdownDelay},
+		{"presign-expires", c.PresignExpires},
+		{"cors-max-age", c.CORSMaxAge},
+		{"reap-interval", c.ReapInterval},
+		{"trash-retention", c.TrashRetention},
+	} {
+		if d.d < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", d.name))
+		}
+	}
+	// the janitor would abort presigned uploads that can still be sent
+	if c.PresignExpires > os.DefaultJanitorMaxAge {
+		errs = append(errs, fmt.Errorf("presign-expires must not exceed %v", os.DefaultJanitorMaxAge))
+	}
+	if c.MaxHeaderBytes <= 0 {
+		errs = append(errs, errors.New("max-header-bytes must be positive"))
+	}
+	switch c.LogFormat {
+	case log.FormatJSON, log.FormatText:
+	default:
+		errs = append(errs, fmt.Errorf("unknown log-format %q", c.LogFormat))
+	}
+	switch c.TraceExporter {
+	case "", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("unknown trace-exporter %q", c.TraceExporter))
+	}
+	return errors.Join(errs...)
+}
+
+// scopeMap decodes the jwt-scope-map option.
+func (c *config) scopeMap() (map[string][]http.Scope, error) {
+	if c.JWTScopeMap == "" {
+		return nil, nil
+	}
+	var m map[string][]http.Scope
+	err := json.Unmarshal([]byte(c.JWTScopeMap), &m)
+	return m, err
+}
+
+// defaultTenant returns the tenant served when no other is resolved.
+func (c *config) defaultTenant() tenant {
+	return tenant{
+		Bucket:           c.Bucket,
+		MetadataDB:       c.MetadataDB,
+		MaxFileSize:      c.MaxFileSize,
+		QuotaBytes:       c.Quota.Bytes,
+		QuotaObjects:     c.Quota.Objects,
+		UserQuotaBytes:   c.UserQuota.Bytes,
+		UserQuotaObjects: c.UserQuota.Objects,
+	}
+}
+
+// tenants loads the tenants option, whose limits default to those of the
+// default tenant.
+func (c *config) tenants() ([]tenant, error) {
+	if c.Tenants == "" {
+		return nil, nil
+	}
+	ts, err := readTenants(c.Tenants, c.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	// ids and hosts are checked by adding them
+	check := http.NewTenants(nil, nil)
+	var errs []error
+	for i, t := range ts {
+		if err := check.Add(t.ID, t.Hosts, nil); err != nil {
+			errs = append(errs, err)
+		}
+		if t.MetadataDB != "" && t.MetadataDB == c.MetadataDB {
+			errs = append(errs, fmt.Errorf("tenant %q: metadata database belongs to the default tenant", t.ID))
+		}
+		t = t.inherit(c.defaultTenant())
+		if quota, user := t.quotas(); t.MetadataDB == "" && (quota != http.Quota{} || user != http.Quota{}) {
+			errs = append(errs, fmt.Errorf("tenant %q: quotas require metadataDb", t.ID))
+		}
+		ts[i] = t
+	}
+	return ts, errors.Join(errs...)
+}
+
+// cors returns the origins allowed to make requests from scripts, or nil if
+// there are none.
+func (c *config) cors() *http.CORS {
+	if c.CORSOrigins == "" {
+		return nil
+	}
+	return http.NewCORS(splitList(c.CORSOrigins), func(cors *http.CORS) {
+		if c.CORSMethods != "" {
+			cors.AllowedMethods = splitList(strings.ToUpper(c.CORSMethods))
+		}
+		if c.CORSHeaders != "" {
+			cors.AllowedHeaders = splitList(c.CORSHeaders)
+		}
+		if c.CORSExposed != "" {
+			cors.ExposedHeaders = splitList(c.CORSExposed)
+		}
+		cors.AllowCredentials, cors.MaxAge = c.CORSCredentials, c.CORSMaxAge
+	})
+}
+
+// splitList returns the non-empty elements of a comma separated list.
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// urlSigner returns the signer of download urls bound to a tenant, or nil if
+// there are no keys.
+func (c *config) urlSigner(tenant string) (*http.URLSigner, error) {
+	if c.URLSigningKeys == "" {
+		return nil, nil
+	}
+	kid, keys, err := http.ParseSigningKeys(c.URLSigningKeys)
+	if err != nil {
+		return nil, err
+	}
+	return http.NewURLSigner(kid, keys, func(s *http.URLSigner) {
+		if c.PublicURL != "" {
+			s.BaseURL, _ = url.Parse(c.PublicURL) // validated
+		}
+		s.Tenant = tenant
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"io"
+	ospkg "os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_parseConfig(t *testing.T) {
+	t.Run("Precedence", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "config.json")
+		err := ospkg.WriteFile(filename, []byte(`{
+	"bucket": "file",
+	"region": "file",
+	"endpoint": "http://file",
+	"read-timeout": "1s",
+	"max-header-bytes": 1048576,
+	"max-file-size": 5368709120
+}`), 0o600)
+		is.OK(t, err) // write config file
+
+		getenv := mapEnv(map[string]string{
+			"BLOB_CONFIG":   filename,
+			"BLOB_REGION":   "env",
+			"BLOB_ENDPOINT": "http://env",
+		})
+		c, err := parseConfig([]string{"-endpoint", "http://flag"}, getenv, io.Discard)
+		is.OK(t, err) // parse config
+		is.Equal(t, c.Bucket, "file")
+		is.Equal(t, c.Region, "env")
+		is.Equal(t, c.Endpoint, "http://flag")
+		is.Equal(t, c.ReadTimeout, time.Second)
+		is.Equal(t, c.MaxHeaderBytes, 1048576)
+		is.Equal(t, c.MaxFileSize, int64(5368709120)) // integers are not formatted as floats
+		is.Equal(t, c.Addr, DefaultAddr)
+		is.Equal(t, c.MetricsAddr, DefaultMetricsAddr)
+	})
+
+	t.Run("Tenants", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "tenants.json")
+		err := ospkg.WriteFile(filename, []byte(`[
+	{"id": "acme", "hosts": ["acme.blob.example"], "metadataDb": "acme.db"},
+	{"id": "initech", "bucket": "initech", "prefix": "files", "metadataDb": "initech.db", "maxFileSize": 1024, "quotaBytes": 1}
+]`), 0o600)
+		is.OK(t, err) // write tenants file
+
+		jwks := "https://issuer.example/jwks"
+		args := []string{"-bucket", "b", "-tenants", filename, "-jwks", jwks, "-metadata-db", "blob.db", "-quota-bytes", "2", "-max-file-size", "3"}
+		c, err := parseConfig(args, mapEnv(nil), io.Discard)
+		is.OK(t, err) // parse config
+		ts, err := c.tenants()
+		is.OK(t, err) // load tenants
+		is.Equal(t, len(ts), 2)
+		is.Equal(t, ts[0].Bucket, "b")
+		is.Equal(t, ts[0].Prefix, "acme")
+		is.Equal(t, ts[1].Prefix, "files")
+		is.Equal(t, ts[0].MaxFileSize, int64(3))
+		is.Equal(t, ts[0].QuotaBytes, int64(2))
+		is.Equal(t, ts[1].MaxFileSize, int64(1024))
+		is.Equal(t, ts[1].QuotaBytes, int64(1))
+
+		// anonymous requests would pick their tenant
+		_, err = parseConfig([]string{"-bucket", "b", "-tenants", filename}, mapEnv(nil), io.Discard)
+		is.True(t, err != nil) // tenants without authentication
+
+		for _, tenants := range []string{
+			`[{"id": "Acme"}]`,
+			`[{"id": "acme"}, {"id": "acme"}]`,
+			`[{"id": "acme"}, {"id": "initech", "prefix": "acme"}]`,
+			`[{"id": "acme", "prefix": "../b"}]`,
+			`[{"id": "acme", "maxFileSize": -1}]`,
+			`[{"id": "acme", "metadataDb": "blob.db"}]`,
+			`[{"id": "acme", "region": "eu"}]`,
+			`[{"id": "acme", "quotaObjects": 1}]`,
+			`[{"id": "acme", "metadataDb": "acme.db", "userQuotaBytes": -1}]`,
+		} {
+			is.OK(t, ospkg.WriteFile(filename, []byte(tenants), 0o600)) // write tenants file
+			_, err := parseConfig([]string{"-bucket", "b", "-metadata-db", "blob.db", "-jwks", jwks, "-tenants", filename}, mapEnv(nil), io.Discard)
+			is.True(t, err != nil) // misconfigured tenants
+		}
+	})
+
+	t.Run("Err", func(t *testing.T) {
+		for _, tc := range []struct {
+			args []string
+			env  map[string]string
+		}{
+			{args: nil},
+			{args: []string{"-bucket", "b", "-tls-cert", "cert.pem"}},
+			{args: []string{"-bucket", "b", "-access-key-id", "id"}},
+			{args: []string{"-bucket", "b", "-read-timeout", "-1s"}},
+			{args: []string{"-bucket", "b"}, env: map[string]string{"BLOB_IDLE_TIMEOUT": "soon"}},
+			{args: []string{"-bucket", "b"}, env: map[string]string{"BLOB_CONFIG": "missing.json"}},
+			{args: []string{"-bucket", "b", "extra"}},
+			{args: []string{"-bucket", "b", "-log-format", "xml"}},
+			{args: []string{"-bucket", "b", "-api-keys", "missing.json"}},
+			{args: []string{"-bucket", "b", "-jwt-issuer", "https://issuer.example"}},
+			{args: []string{"-bucket", "b", "-jwks", "https://issuer.example/jwks", "-jwt-scope-map", "[]"}},
+			{args: []string{"-bucket", "b", "-log-level", "loud"}},
+			{args: []string{"-bucket", "b", "-trace-exporter", "zipkin"}},
+			{args: []string{"-bucket", "b", "-url-signing-keys", "k1=c2hvcnQ="}},
+			{args: []string{"-bucket", "b", "-public-url", "https://blob.example"}},
+			{args: []string{"-bucket", "b", "-url-signing-keys", "k1=MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}},
+			{args: []string{"-bucket", "b", "-url-signing-keys", "k1=MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", "-public-url", "https://blob.example/prefix"}},
+			{args: []string{"-bucket", "b", "-reap-interval", "-1m"}},
+			{args: []string{"-bucket", "b", "-trash-retention", "-24h"}},
+			{args: []string{"-bucket", "b", "-max-file-size", "-1"}},
+			{args: []string{"-bucket", "b", "-quota-bytes", "1"}},
+			{args: []string{"-bucket", "b", "-rate-limit", "-1"}},
+			{args: []string{"-bucket", "b", "-presign-expires", "25h"}},
+			{args: []string{"-bucket", "b", "-metrics-addr", DefaultAddr}},
+			{args: []string{"-bucket", "b", "-backfill-metadata"}},
+			{args: []string{"-bucket", "b", "-disk-min-free", "1"}},
+			{args: []string{"-bucket", "b", "-disk-path", ".", "-disk-min-free", "-1"}},
+			{args: []string{"-bucket", "b", "-cors-credentials"}},
+			{args: []string{"-bucket", "b", "-cors-origins", "app.example"}},
+			{args: []string{"-bucket", "b", "-cors-origins", "*", "-cors-credentials"}},
+			{args: []string{"-bucket", "b", "-cors-origins", "https://app.example", "-cors-max-age", "-1s"}},
+			{args: []string{"-bucket", "b", "-rate-burst", "10"}},
+			{args: []string{"-bucket", "b", "-addr-rate-limit", "-1"}},
+			{args: []string{"-bucket", "b", "-addr-rate-burst", "10"}},
+			{args: []string{"-bucket", "b", "-max-transfers", "-1"}},
+			{args: []string{"-bucket", "b", "-bandwidth", "-1"}},
+			{args: []string{"-bucket", "b", "-metadata-db", "blob.db", "-user-quota-objects", "-1"}},
+			{args: []string{"-bucket", "b", "-tenants", "missing.json"}},
+			{args: []string{"-bucket", "b", "-jwt-tenant-claim", "org"}},
+		} {
+			_, err := parseConfig(tc.args, mapEnv(tc.env), io.Discard)
+			is.True(t, err != nil) // misconfiguration
+		}
+	})
+}
+
+func Test_run(t *testing.T) {
+	t.Run("Shutdown", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		errc := make(chan error, 1)
+		go func() {
+			args := []string{"-addr", "127.0.0.1:0", "-bucket", "b", "-endpoint", "http://127.0.0.1:1", "-access-key-id", "id", "-secret-access-key", "secret"}
+			errc <- run(ctx, args, mapEnv(nil), io.Discard)
+		}()
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		is.OK(t, <-errc) // drained without error
+	})
+}
+
+func mapEnv(m map[string]string) func(string) string {
+	return func(k string) string { return m[k] }
+}
@@ -0,0 +1,341 @@
+// Command blob serves the blob storage API backed by an S3 compatible bucket.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	nethttp "net/http"
+	ospkg "os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.adoublef/blob/internal/database/sql"
+	"go.adoublef/blob/internal/log"
+	"go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/os"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"golang.org/x/time/rate"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), ospkg.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, ospkg.Args[1:], ospkg.Getenv, ospkg.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(ospkg.Stderr, "blob: %v\n", err)
+		stop()
+		ospkg.Exit(1)
+	}
+}
+
+// run starts the server and blocks until the context is done and in-flight
+// requests have been drained.
+func run(ctx context.Context, args []string, getenv func(string) string, stderr io.Writer) error {
+	c, err := parseConfig(args, getenv, stderr)
+	if err != nil {
+		return err
+	}
+
+	logger, err := log.New(stderr, c.LogFormat, c.LogLevel)
+	if err != nil {
+		return err
+	}
+	ctx = log.NewContext(ctx, logger)
+
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(c.Region)}
+	if c.AccessKeyID != "" {
+		cred := credentials.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, "")
+		opts = append(opts, awsconfig.WithCredentialsProvider(cred))
+	}
+	conf, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to load storage configuration: %w", err)
+	}
+	tp, err := newTracerProvider(ctx, c.TraceExporter, ospkg.Stdout)
+	if err != nil {
+		return fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+	defer func() {
+		// flush the spans of drained requests
+		sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.ShutdownTimeout)
+		defer cancel()
+		tp.Shutdown(sctx)
+	}()
+	ot := os.NewTracer(tp)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	om := os.NewMetrics(reg)
+	// shared by the tenants so the bandwidth is capped as a whole
+	var limiter *http.Limiter
+	if c.RateLimit > 0 || c.AddrRateLimit > 0 || c.MaxTransfers > 0 || c.Bandwidth > 0 {
+		limiter = http.NewLimiter(reg, func(l *http.Limiter) {
+			l.Rate, l.Burst = rate.Limit(c.RateLimit), c.RateBurst
+			l.AddrRate, l.AddrBurst = rate.Limit(c.AddrRateLimit), c.AddrRateBurst
+			l.MaxTransfers, l.Bandwidth = c.MaxTransfers, c.Bandwidth
+		})
+	}
+
+	client := s3.NewFromConfig(conf, func(o *s3.Options) {
+		if c.Endpoint != "" {
+			o.BaseEndpoint = aws.String(c.Endpoint)
+		}
+		o.UsePathStyle = c.PathStyle
+		o.APIOptions = append(o.APIOptions, om.Middleware, ot.Middleware, os.RequestIDMiddleware(http.RequestID))
+	})
+
+	var auth http.Authenticators
+	if c.JWKS != "" {
+		sm, _ := c.scopeMap() // validated
+		auth = append(auth, http.NewJWT(newJWKS(c.JWKS), func(j *http.JWT) {
+			j.Issuer, j.Audience = c.JWTIssuer, c.JWTAudience
+			j.ScopeClaim, j.ScopeMap, j.TenantClaim = c.JWTScopeClaim, sm, c.JWTTenantClaim
+		}))
+	}
+	if c.APIKeys != "" {
+		ks, err := readKeyStore(c.APIKeys)
+		if err != nil {
+			return fmt.Errorf("failed to load api keys: %w", err)
+		}
+		auth = append(auth, http.NewAPIKeys(ks))
+	}
+
+	ts, err := c.tenants()
+	if err != nil {
+		return fmt.Errorf("failed to load tenants: %w", err)
+	}
+
+	jctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	var dbs []*sql.DB
+	defer func() {
+		for _, md := range dbs {
+			md.Close()
+		}
+	}()
+	// presigning sends no request so is not instrumented
+	pc := s3.NewPresignClient(client, func(o *s3.PresignOptions) {
+		o.ClientOptions = append(o.ClientOptions, func(o *s3.Options) { o.APIOptions = nil })
+	})
+	health := http.NewHealth()
+	if c.DiskPath != "" {
+		health.Add("disk", os.DiskCheck{Path: c.DiskPath, MinFree: uint64(c.DiskMinFree)})
+	}
+	hm := http.NewMetrics(reg)
+	cors := c.cors()
+	// newHandler serves the files of a tenant from its own prefix of a bucket
+	// and its own metadata database.
+	newHandler := func(t tenant) (nethttp.Handler, error) {
+		name := func(check string) string {
+			if t.ID == "" {
+				return check
+			}
+			return check + "/" + t.ID
+		}
+		signer, err := c.urlSigner(t.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load url signing keys: %w", err)
+		}
+		oc := os.New(t.Bucket, client, func(c *os.Client) { c.Namespace, c.Report = t.Prefix, om.Report })
+		presigner := os.NewPresigner(t.Bucket, client, pc, func(p *os.Presigner) {
+			p.Namespace, p.Expires, p.Report = t.Prefix, c.PresignExpires, om.Report
+		})
+		health.Add(name("storage"), oc)
+		var md *sql.DB
+		if t.MetadataDB != "" {
+			md, err = sql.Open(ctx, t.MetadataDB)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open metadata database: %w", err)
+			}
+			dbs = append(dbs, md)
+			health.Add(name("metadata"), md)
+			if c.Backfill {
+				n, err := md.Backfill(ctx, oc, sql.DefaultBackfillMinAge)
+				if err != nil {
+					return nil, fmt.Errorf("failed to backfill metadata: %w", err)
+				}
+				logger.InfoContext(ctx, "backfilled metadata", slog.String("tenant", t.ID), slog.Int("blobs", n))
+			}
+			if c.ReapInterval > 0 {
+				go sql.NewReaper(md, oc, func(r *sql.Reaper) {
+					r.Interval, r.Retention, r.Report = c.ReapInterval, c.TrashRetention, om.Reclaim
+				}).Run(jctx)
+			}
+		}
+		return http.Handler(oc, func(o *http.Options) {
+			o.Health, o.Metrics, o.TracerProvider, o.Logger = health, hm, tp, logger
+			o.URLSigner, o.MaxFileSize = signer, t.MaxFileSize
+			o.Quota, o.UserQuota = t.quotas()
+			o.Limiter, o.CORS = limiter, cors
+			if md != nil {
+				o.Metadata = md
+			}
+			if c.PresignExpires > 0 {
+				o.Presigner = presigner
+			}
+			if len(auth) > 0 {
+				o.Authenticator = auth
+			}
+		}), nil
+	}
+
+	var h nethttp.Handler
+	h, err = newHandler(c.defaultTenant())
+	if err != nil {
+		return err
+	}
+	if len(ts) > 0 {
+		var a http.Authenticator
+		if len(auth) > 0 {
+			a = auth
+		}
+		tenants := http.NewTenants(a, h)
+		for _, t := range ts {
+			th, err := newHandler(t)
+			if err == nil {
+				err = tenants.Add(t.ID, t.Hosts, th)
+			}
+			if err != nil {
+				return fmt.Errorf("tenant %q: %w", t.ID, err)
+			}
+		}
+		// tenants authenticate the request before routing it
+		h = http.LimitHandler(limiter, tenants)
+	}
+
+	// requests must outlive the signal so they can be drained
+	srv := &nethttp.Server{
+		Addr:           c.Addr,
+		Handler:        h,
+		ReadTimeout:    c.ReadTimeout,
+		WriteTimeout:   c.WriteTimeout,
+		IdleTimeout:    c.IdleTimeout,
+		MaxHeaderBytes: c.MaxHeaderBytes,
+		ErrorLog:       slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+	ln, err := net.Listen("tcp", c.Addr)
+	if err != nil {
+		return err
+	}
+
+	// each tenant keeps its uploads under its own prefix of a bucket
+	type namespace struct{ bucket, prefix string }
+	nss := map[namespace]bool{{c.Bucket, ""}: true}
+	for _, t := range ts {
+		nss[namespace{t.Bucket, t.Prefix}] = true
+	}
+	for ns := range nss {
+		go os.NewJanitor(ns.bucket, client, func(j *os.Janitor) { j.Namespace = ns.prefix }).Run(jctx)
+	}
+
+	errc := make(chan error, 2)
+	// metrics cover every tenant so are kept off the hosts of each
+	if c.MetricsAddr != "" {
+		msrv := &nethttp.Server{
+			Addr:              c.MetricsAddr,
+			Handler:           hm,
+			ReadHeaderTimeout: c.ReadTimeout,
+			ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		}
+		mln, err := net.Listen("tcp", c.MetricsAddr)
+		if err != nil {
+			return err
+		}
+		defer msrv.Close()
+		go func() {
+			if err := msrv.Serve(mln); !errors.Is(err, nethttp.ErrServerClosed) {
+				errc <- err
+			}
+		}()
+		logger.InfoContext(ctx, "serving metrics", slog.String("addr", mln.Addr().String()))
+	}
+	go func() {
+		if c.TLSCert != "" {
+			errc <- srv.ServeTLS(ln, c.TLSCert, c.TLSKey)
+			return
+		}
+		errc <- srv.Serve(ln)
+	}()
+	logger.InfoContext(ctx, "listening", slog.String("addr", ln.Addr().String()))
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	// give load balancers time to notice before refusing connections
+	health.Shutdown()
+	time.Sleep(c.ShutdownDelay)
+	logger.InfoContext(ctx, "draining requests", slog.Duration("timeout", c.ShutdownTimeout))
+
+	sctx, scancel := context.WithTimeout(context.WithoutCancel(ctx), c.ShutdownTimeout)
+	defer scancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		// abandon whatever is left
+		return errors.Join(fmt.Errorf("failed to drain requests: %w", err), srv.Close())
+	}
+	if err := <-errc; !errors.Is(err, nethttp.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// readKeyStore loads the API keys from the file.
+func readKeyStore(filename string) (http.MapKeyStore, error) {
+	f, err := ospkg.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return http.ReadKeyStore(f)
+}
+
+// newJWKS returns the key set at a url or in a file.
+func newJWKS(src string) *http.JWKS {
+	if strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://") {
+		return http.NewURLJWKS(src, &nethttp.Client{Timeout: 10 * time.Second})
+	}
+	return http.NewFileJWKS(src)
+}
+
+// newTracerProvider returns a provider that batches spans to the exporter.
+// Without an exporter spans are sampled but dropped.
+func newTracerProvider(ctx context.Context, exporter string, w io.Writer) (*sdktrace.TracerProvider, error) {
+	res := resource.NewSchemaless(semconv.ServiceName("blob"))
+	var exp sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case "otlp":
+		// configured by the OTEL_EXPORTER_OTLP_* environment variables
+		exp, err = otlptracehttp.New(ctx)
+	default:
+		return sdktrace.NewTracerProvider(sdktrace.WithResource(res)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(sdktrace.WithResource(res), sdktrace.WithBatcher(exp)), nil
+}
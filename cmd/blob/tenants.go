@@ -0,0 +1,101 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	ospkg "os"
+	"path"
+	"strings"
+
+	"go.adoublef/blob/internal/net/http"
+)
+
+// tenant is the configuration of a tenant served next to the default one.
+type tenant struct {
+	ID    string   `json:"id"`
+	Hosts []string `json:"hosts"`
+	// Bucket defaults to the bucket of the default tenant.
+	Bucket string `json:"bucket"`
+	// Prefix of the keys of the tenant in the bucket, which defaults to its
+	// id.
+	Prefix     string `json:"prefix"`
+	MetadataDB string `json:"metadataDb"`
+	// MaxFileSize and the quotas default to those of the default tenant.
+	MaxFileSize      int64 `json:"maxFileSize"`
+	QuotaBytes       int64 `json:"quotaBytes"`
+	QuotaObjects     int64 `json:"quotaObjects"`
+	UserQuotaBytes   int64 `json:"userQuotaBytes"`
+	UserQuotaObjects int64 `json:"userQuotaObjects"`
+}
+
+// quotas returns the quotas of the tenant and of each of its callers.
+func (t tenant) quotas() (quota, user http.Quota) {
+	return http.Quota{Bytes: t.QuotaBytes, Objects: t.QuotaObjects}, http.Quota{Bytes: t.UserQuotaBytes, Objects: t.UserQuotaObjects}
+}
+
+// inherit returns the tenant with the limits it leaves unset taken from
+// those of def.
+func (t tenant) inherit(def tenant) tenant {
+	t.MaxFileSize = cmp.Or(t.MaxFileSize, def.MaxFileSize)
+	t.QuotaBytes = cmp.Or(t.QuotaBytes, def.QuotaBytes)
+	t.QuotaObjects = cmp.Or(t.QuotaObjects, def.QuotaObjects)
+	t.UserQuotaBytes = cmp.Or(t.UserQuotaBytes, def.UserQuotaBytes)
+	t.UserQuotaObjects = cmp.Or(t.UserQuotaObjects, def.UserQuotaObjects)
+	return t
+}
+
+// readTenants loads the tenants from the file.
+func readTenants(filename, bucket string) ([]tenant, error) {
+	f, err := ospkg.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeTenants(f, bucket)
+}
+
+// decodeTenants decodes a JSON array of tenants such as
+//
+//	[{"id": "acme", "hosts": ["acme.blob.example"], "bucket": "acme", "metadataDb": "acme.db"}]
+//
+// filling in the defaults. Tenants must not share a prefix of a bucket or a
+// metadata database with one another or the default tenant, whose prefix is
+// empty in bucket.
+func decodeTenants(r io.Reader, bucket string) ([]tenant, error) {
+	var ts []tenant
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ts); err != nil {
+		return nil, err
+	}
+	prefixes := map[string]string{bucket + "/": ""}
+	dbs := make(map[string]string)
+	var errs []error
+	for i := range ts {
+		t := &ts[i]
+		if t.Bucket == "" {
+			t.Bucket = bucket
+		}
+		if t.Prefix == "" {
+			t.Prefix = t.ID
+		}
+		if t.Prefix != path.Clean(t.Prefix) || strings.HasPrefix(t.Prefix, "/") || strings.HasPrefix(t.Prefix, ".") {
+			errs = append(errs, fmt.Errorf("tenant %q: invalid prefix %q", t.ID, t.Prefix))
+		}
+		if other, ok := prefixes[t.Bucket+"/"+t.Prefix]; ok {
+			errs = append(errs, fmt.Errorf("tenant %q: prefix %q of bucket %q belongs to tenant %q", t.ID, t.Prefix, t.Bucket, other))
+		}
+		prefixes[t.Bucket+"/"+t.Prefix] = t.ID
+		if other, ok := dbs[t.MetadataDB]; ok && t.MetadataDB != "" {
+			errs = append(errs, fmt.Errorf("tenant %q: metadata database belongs to tenant %q", t.ID, other))
+		}
+		dbs[t.MetadataDB] = t.ID
+		if min(t.MaxFileSize, t.QuotaBytes, t.QuotaObjects, t.UserQuotaBytes, t.UserQuotaObjects) < 0 {
+			errs = append(errs, fmt.Errorf("tenant %q: sizes and quotas must not be negative", t.ID))
+		}
+	}
+	return ts, errors.Join(errs...)
+}
@@ -0,0 +1,47 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultBackfillMinAge is how old a blob must be to be backfilled, as a more
+// recent one may still be recorded by its upload.
+const DefaultBackfillMinAge = time.Hour
+
+// Lister lists the first version of each blob in the bucket.
+type Lister interface {
+	Blobs(ctx context.Context, fn func(id uuid.UUID, size int64, modified time.Time) error) error
+}
+
+// Backfill records the blobs in the bucket that have no metadata, such as
+// those uploaded before metadata was recorded, returning how many were
+// recorded. Blobs modified within minAge are skipped. The recorded blobs have
+// no filename, owner or checksum.
+func (db *DB) Backfill(ctx context.Context, l Lister, minAge time.Duration) (int, error) {
+	before := time.Now().Add(-minAge)
+	var n int
+	err := l.Blobs(ctx, func(id uuid.UUID, size int64, modified time.Time) error {
+		if !modified.Before(before) {
+			return nil
+		}
+		// the blob is already stored so is recorded whatever the quotas
+		err := db.Create(ctx, Blob{
+			ID:          id,
+			ContentType: "application/octet-stream",
+			Size:        size,
+			CreatedAt:   modified,
+		}, Quotas{})
+		if errors.Is(err, ErrExist) {
+			return nil
+		}
+		if err == nil {
+			n++
+		}
+		return err
+	})
+	return n, err
+}
@@ -0,0 +1,519 @@
+package sql
+
+import (
+	"context"
+	sqlpkg "database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const DefaultListLimit = 100
+
+// Blob is the metadata of a blob.
+type Blob struct {
+	ID          uuid.UUID
+	Filename    string
+	ContentType string
+	Size        int64
+	// SHA256 is the hex encoded checksum of the content, if known.
+	SHA256    string
+	Owner     string
+	CreatedAt time.Time
+	// ExpiresAt is when the blob is deleted, or zero if it is kept.
+	ExpiresAt time.Time
+	// DeletedAt is when the blob was moved to the trash, or zero if it was
+	// not.
+	DeletedAt time.Time
+	// Tags are the user-defined key/value pairs of the blob.
+	Tags map[string]string
+	// Version numbers the content of the blob, starting at 1, and
+	// VersionID identifies the object holding it, or is nil for the first
+	// version which is held by the id of the blob.
+	Version   int
+	VersionID uuid.UUID
+}
+
+// Expired reports whether the blob has expired at the given time.
+func (b Blob) Expired(now time.Time) bool {
+	return !b.ExpiresAt.IsZero() && !now.Before(b.ExpiresAt)
+}
+
+// Trashed reports whether the blob is in the trash.
+func (b Blob) Trashed() bool {
+	return !b.DeletedAt.IsZero()
+}
+
+// ListOptions filters and pages the blobs returned by [DB.List].
+type ListOptions struct {
+	// After is the last blob of the previous page, or nil for the first.
+	After uuid.UUID
+	Limit int
+	// Tags are the key/value pairs that each blob must have.
+	Tags map[string]string
+	// Trashed lists the blobs in the trash instead.
+	Trashed bool
+}
+
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sqlpkg.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sqlpkg.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sqlpkg.Row
+}
+
+// Create records the metadata of a new blob along with its tags, as its
+// first version, unless it would exceed the quotas.
+func (db *DB) Create(ctx context.Context, b Blob, qs Quotas) error {
+	return inTx(ctx, db.db, func(tx *sqlpkg.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+INSERT INTO blobs (id, filename, content_type, size, sha256, owner, created_at, expires_at, version, version_id)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1, ?)`,
+			b.ID.String(), b.Filename, b.ContentType, b.Size, b.SHA256, b.Owner, b.CreatedAt.UnixMilli(), nullTime(b.ExpiresAt), nullUUID(b.VersionID))
+		if isConstraint(err, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY) {
+			return fmt.Errorf("blob %s: %w", b.ID, ErrExist)
+		}
+		if err != nil {
+			return err
+		}
+		b.Version = 1
+		if err := insertVersion(ctx, tx, b); err != nil {
+			return err
+		}
+		if err := addUsage(ctx, tx, b.Owner, Usage{Bytes: b.Size, Objects: 1}); err != nil {
+			return err
+		}
+		if err := checkQuotas(ctx, tx, b.Owner, qs); err != nil {
+			return err
+		}
+		return setTags(ctx, tx, b.ID, b.Tags)
+	})
+}
+
+// AddVersion records new content of the blob as its current version,
+// returning its metadata. The filename, content type, size, checksum,
+// creation time and version id are taken from b. The version is not recorded
+// if it would exceed the quotas of the owner of the blob.
+func (db *DB) AddVersion(ctx context.Context, b Blob, qs Quotas) (cur Blob, err error) {
+	err = inTx(ctx, db.db, func(tx *sqlpkg.Tx) error {
+		prev, err := liveBlob(ctx, tx, b.ID)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRowContext(ctx, `
+SELECT MAX(version) + 1 FROM versions WHERE blob_id = ?`, b.ID.String()).Scan(&b.Version)
+		if err != nil {
+			return err
+		}
+		if err := insertVersion(ctx, tx, b); err != nil {
+			return err
+		}
+		if err := addUsage(ctx, tx, prev.Owner, Usage{Bytes: b.Size}); err != nil {
+			return err
+		}
+		if err := checkQuotas(ctx, tx, prev.Owner, qs); err != nil {
+			return err
+		}
+		if err := setVersion(ctx, tx, b.ID, b.Version); err != nil {
+			return err
+		}
+		cur, err = blob(ctx, tx, b.ID)
+		return err
+	})
+	return cur, err
+}
+
+// SetVersion makes a previous version of the blob current, returning its
+// metadata, or [ErrNotExist] if there is no such version.
+func (db *DB) SetVersion(ctx context.Context, id uuid.UUID, version int) (b Blob, err error) {
+	err = inTx(ctx, db.db, func(tx *sqlpkg.Tx) error {
+		if _, err := liveBlob(ctx, tx, id); err != nil {
+			return err
+		}
+		if err := setVersion(ctx, tx, id, version); err != nil {
+			return err
+		}
+		b, err = blob(ctx, tx, id)
+		return err
+	})
+	return b, err
+}
+
+// BlobVersion returns the metadata of the blob as it was at the given
+// version, or [ErrNotExist].
+func (db *DB) BlobVersion(ctx context.Context, id uuid.UUID, version int) (Blob, error) {
+	bs, err := db.versions(ctx, id, `AND v.version = ?`, version)
+	if err != nil {
+		return Blob{}, err
+	}
+	if len(bs) == 0 {
+		return Blob{}, ErrNotExist
+	}
+	return bs[0], loadTags(ctx, db.db, bs)
+}
+
+// Versions returns the metadata of the blob at each of its versions, oldest
+// first, without their tags.
+func (db *DB) Versions(ctx context.Context, id uuid.UUID) ([]Blob, error) {
+	bs, err := db.versions(ctx, id, `ORDER BY v.version`)
+	if err == nil && len(bs) == 0 {
+		return nil, ErrNotExist
+	}
+	return bs, err
+}
+
+func (db *DB) versions(ctx context.Context, id uuid.UUID, cond string, args ...any) ([]Blob, error) {
+	rows, err := db.db.QueryContext(ctx, `
+SELECT b.id, v.filename, v.content_type, v.size, v.sha256, b.owner, v.created_at, b.expires_at, b.deleted_at, v.version, v.version_id
+FROM versions v JOIN blobs b ON b.id = v.blob_id
+WHERE v.blob_id = ? `+cond, append([]any{id.String()}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var bs []Blob
+	for rows.Next() {
+		b, err := scanBlob(rows)
+		if err != nil {
+			return nil, err
+		}
+		bs = append(bs, b)
+	}
+	return bs, rows.Err()
+}
+
+// Blob returns the metadata of the blob, or [ErrNotExist]. Blobs in the
+// trash are returned, see [Blob.Trashed].
+func (db *DB) Blob(ctx context.Context, id uuid.UUID) (Blob, error) {
+	return blob(ctx, db.db, id)
+}
+
+func blob(ctx context.Context, q queryer, id uuid.UUID) (Blob, error) {
+	row := q.QueryRowContext(ctx, `
+SELECT id, filename, content_type, size, sha256, owner, created_at, expires_at, deleted_at, version, version_id
+FROM blobs WHERE id = ?`, id.String())
+	b, err := scanBlob(row)
+	if errors.Is(err, sqlpkg.ErrNoRows) {
+		return Blob{}, ErrNotExist
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+	bs := []Blob{b}
+	if err := loadTags(ctx, q, bs); err != nil {
+		return Blob{}, err
+	}
+	return bs[0], nil
+}
+
+// List returns the blobs matching the options, oldest first. Expired blobs
+// are not listed, nor are those in the trash unless asked for.
+func (db *DB) List(ctx context.Context, o ListOptions) ([]Blob, error) {
+	if o.Limit <= 0 {
+		o.Limit = DefaultListLimit
+	}
+	// ids are UUIDv7 so are ordered by creation
+	var cursor string
+	if o.After != uuid.Nil {
+		cursor = o.After.String()
+	}
+	var (
+		where = []string{"id > ?", "(expires_at IS NULL OR expires_at > ?)"}
+		args  = []any{cursor, time.Now().UnixMilli()}
+	)
+	if o.Trashed {
+		where = append(where, "deleted_at IS NOT NULL")
+	} else {
+		where = append(where, "deleted_at IS NULL")
+	}
+	for _, k := range slices.Sorted(maps.Keys(o.Tags)) {
+		where = append(where, "id IN (SELECT blob_id FROM tags WHERE key = ? AND value = ?)")
+		args = append(args, k, o.Tags[k])
+	}
+	args = append(args, o.Limit)
+	rows, err := db.db.QueryContext(ctx, `
+SELECT id, filename, content_type, size, sha256, owner, created_at, expires_at, deleted_at, version, version_id
+FROM blobs WHERE `+strings.Join(where, " AND ")+` ORDER BY id LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var bs []Blob
+	for rows.Next() {
+		b, err := scanBlob(rows)
+		if err != nil {
+			return nil, err
+		}
+		bs = append(bs, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return bs, loadTags(ctx, db.db, bs)
+}
+
+// SetTags replaces the tags of the blob, returning its metadata.
+func (db *DB) SetTags(ctx context.Context, id uuid.UUID, tags map[string]string) (b Blob, err error) {
+	err = inTx(ctx, db.db, func(tx *sqlpkg.Tx) error {
+		if _, err := liveBlob(ctx, tx, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM tags WHERE blob_id = ?`, id.String()); err != nil {
+			return err
+		}
+		if err := setTags(ctx, tx, id, tags); err != nil {
+			return err
+		}
+		b, err = blob(ctx, tx, id)
+		return err
+	})
+	return b, err
+}
+
+// UpdateTags sets the tags of the blob with a value and removes those
+// without, returning its metadata.
+func (db *DB) UpdateTags(ctx context.Context, id uuid.UUID, patch map[string]*string) (b Blob, err error) {
+	err = inTx(ctx, db.db, func(tx *sqlpkg.Tx) error {
+		if _, err := liveBlob(ctx, tx, id); err != nil {
+			return err
+		}
+		set := make(map[string]string)
+		for k, v := range patch {
+			if v != nil {
+				set[k] = *v
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM tags WHERE blob_id = ? AND key = ?`, id.String(), k); err != nil {
+				return err
+			}
+		}
+		if err := setTags(ctx, tx, id, set); err != nil {
+			return err
+		}
+		b, err = blob(ctx, tx, id)
+		return err
+	})
+	return b, err
+}
+
+// Trash moves the blob to the trash, or returns [ErrNotExist] if it is
+// already there.
+func (db *DB) Trash(ctx context.Context, id uuid.UUID) error {
+	res, err := db.db.ExecContext(ctx, `
+UPDATE blobs SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`, time.Now().UnixMilli(), id.String())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotExist
+	}
+	return err
+}
+
+// Restore takes the blob out of the trash, returning its metadata, or
+// [ErrNotExist] if it is not there.
+func (db *DB) Restore(ctx context.Context, id uuid.UUID) (b Blob, err error) {
+	err = inTx(ctx, db.db, func(tx *sqlpkg.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+UPDATE blobs SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`, id.String())
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotExist
+		}
+		b, err = blob(ctx, tx, id)
+		return err
+	})
+	return b, err
+}
+
+// Expired returns up to limit blobs that expired before the given time,
+// earliest first.
+func (db *DB) Expired(ctx context.Context, before time.Time, limit int) ([]Blob, error) {
+	return db.blobs(ctx, `expires_at <= ? ORDER BY expires_at LIMIT ?`, before.UnixMilli(), limit)
+}
+
+// Purgeable returns up to limit blobs that were moved to the trash before
+// the given time, earliest first.
+func (db *DB) Purgeable(ctx context.Context, before time.Time, limit int) ([]Blob, error) {
+	return db.blobs(ctx, `deleted_at <= ? ORDER BY deleted_at LIMIT ?`, before.UnixMilli(), limit)
+}
+
+// blobs returns the blobs matching the condition, without their tags.
+func (db *DB) blobs(ctx context.Context, cond string, args ...any) ([]Blob, error) {
+	rows, err := db.db.QueryContext(ctx, `
+SELECT id, filename, content_type, size, sha256, owner, created_at, expires_at, deleted_at, version, version_id
+FROM blobs WHERE `+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var bs []Blob
+	for rows.Next() {
+		b, err := scanBlob(rows)
+		if err != nil {
+			return nil, err
+		}
+		bs = append(bs, b)
+	}
+	return bs, rows.Err()
+}
+
+// Delete removes the metadata of the blob along with its tags and versions,
+// freeing the storage they used.
+func (db *DB) Delete(ctx context.Context, id uuid.UUID) error {
+	return inTx(ctx, db.db, func(tx *sqlpkg.Tx) error {
+		var (
+			owner string
+			freed Usage
+		)
+		err := tx.QueryRowContext(ctx, `
+SELECT b.owner, COALESCE(SUM(v.size), 0) FROM blobs b LEFT JOIN versions v ON v.blob_id = b.id
+WHERE b.id = ? GROUP BY b.id`, id.String()).Scan(&owner, &freed.Bytes)
+		if errors.Is(err, sqlpkg.ErrNoRows) {
+			return ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM blobs WHERE id = ?`, id.String()); err != nil {
+			return err
+		}
+		return addUsage(ctx, tx, owner, Usage{Bytes: -freed.Bytes, Objects: -1})
+	})
+}
+
+// liveBlob returns the blob unless it is in the trash.
+func liveBlob(ctx context.Context, q queryer, id uuid.UUID) (Blob, error) {
+	b, err := blob(ctx, q, id)
+	if err == nil && b.Trashed() {
+		return Blob{}, ErrNotExist
+	}
+	return b, err
+}
+
+// insertVersion records the version of the blob.
+func insertVersion(ctx context.Context, q queryer, b Blob) error {
+	_, err := q.ExecContext(ctx, `
+INSERT INTO versions (blob_id, version, version_id, filename, content_type, size, sha256, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		b.ID.String(), b.Version, nullUUID(b.VersionID), b.Filename, b.ContentType, b.Size, b.SHA256, b.CreatedAt.UnixMilli())
+	return err
+}
+
+// setVersion copies the version into the blob, making it current.
+func setVersion(ctx context.Context, q queryer, id uuid.UUID, version int) error {
+	res, err := q.ExecContext(ctx, `
+UPDATE blobs SET (filename, content_type, size, sha256, version, version_id) = (
+    SELECT filename, content_type, size, sha256, version, version_id
+    FROM versions WHERE blob_id = blobs.id AND version = ?
+)
+WHERE id = ? AND EXISTS (SELECT 1 FROM versions WHERE blob_id = blobs.id AND version = ?)`, version, id.String(), version)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotExist
+	}
+	return err
+}
+
+// setTags inserts or replaces the tags of the blob.
+func setTags(ctx context.Context, q queryer, id uuid.UUID, tags map[string]string) error {
+	for k, v := range tags {
+		_, err := q.ExecContext(ctx, `
+INSERT INTO tags (blob_id, key, value) VALUES (?, ?, ?)
+ON CONFLICT (blob_id, key) DO UPDATE SET value = excluded.value`, id.String(), k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTags sets the tags of each blob.
+func loadTags(ctx context.Context, q queryer, bs []Blob) error {
+	if len(bs) == 0 {
+		return nil
+	}
+	byID := make(map[string]*Blob, len(bs))
+	args := make([]any, len(bs))
+	for i := range bs {
+		id := bs[i].ID.String()
+		byID[id], args[i] = &bs[i], id
+	}
+	rows, err := q.QueryContext(ctx, `
+SELECT blob_id, key, value FROM tags
+WHERE blob_id IN (`+strings.Repeat("?, ", len(bs)-1)+`?)`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, k, v string
+		if err := rows.Scan(&id, &k, &v); err != nil {
+			return err
+		}
+		b := byID[id]
+		if b.Tags == nil {
+			b.Tags = make(map[string]string)
+		}
+		b.Tags[k] = v
+	}
+	return rows.Err()
+}
+
+func scanBlob(s interface{ Scan(...any) error }) (Blob, error) {
+	var (
+		b       Blob
+		id      string
+		created int64
+		expires sqlpkg.NullInt64
+		deleted sqlpkg.NullInt64
+		vid     string
+	)
+	err := s.Scan(&id, &b.Filename, &b.ContentType, &b.Size, &b.SHA256, &b.Owner, &created, &expires, &deleted, &b.Version, &vid)
+	if err != nil {
+		return Blob{}, err
+	}
+	if b.ID, err = uuid.Parse(id); err != nil {
+		return Blob{}, err
+	}
+	if vid != "" {
+		if b.VersionID, err = uuid.Parse(vid); err != nil {
+			return Blob{}, err
+		}
+	}
+	b.CreatedAt = time.UnixMilli(created)
+	if expires.Valid {
+		b.ExpiresAt = time.UnixMilli(expires.Int64)
+	}
+	if deleted.Valid {
+		b.DeletedAt = time.UnixMilli(deleted.Int64)
+	}
+	return b, nil
+}
+
+// nullUUID stores the nil uuid as an empty string.
+func nullUUID(id uuid.UUID) string {
+	if id == uuid.Nil {
+		return ""
+	}
+	return id.String()
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sqlpkg.NullInt64 {
+	if t.IsZero() {
+		return sqlpkg.NullInt64{}
+	}
+	return sqlpkg.NullInt64{Int64: t.UnixMilli(), Valid: true}
+}
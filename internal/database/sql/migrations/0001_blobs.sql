@@ -0,0 +1,11 @@
+CREATE TABLE blobs (
+    id           TEXT    PRIMARY KEY,
+    filename     TEXT    NOT NULL DEFAULT '',
+    content_type TEXT    NOT NULL DEFAULT '',
+    size         INTEGER NOT NULL,
+    sha256       TEXT    NOT NULL DEFAULT '',
+    owner        TEXT    NOT NULL DEFAULT '',
+    created_at   INTEGER NOT NULL -- unix milliseconds
+) STRICT;
+
+CREATE INDEX blobs_owner ON blobs (owner, id);
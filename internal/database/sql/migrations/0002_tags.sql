@@ -0,0 +1,8 @@
+CREATE TABLE tags (
+    blob_id TEXT NOT NULL REFERENCES blobs (id) ON DELETE CASCADE,
+    key     TEXT NOT NULL,
+    value   TEXT NOT NULL,
+    PRIMARY KEY (blob_id, key)
+) STRICT;
+
+CREATE INDEX tags_key_value ON tags (key, value, blob_id);
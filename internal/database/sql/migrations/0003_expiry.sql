@@ -0,0 +1,3 @@
+ALTER TABLE blobs ADD COLUMN expires_at INTEGER; -- unix milliseconds
+
+CREATE INDEX blobs_expires_at ON blobs (expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE blobs ADD COLUMN deleted_at INTEGER; -- unix milliseconds
+
+CREATE INDEX blobs_deleted_at ON blobs (deleted_at) WHERE deleted_at IS NOT NULL;
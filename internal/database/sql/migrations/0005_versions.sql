@@ -0,0 +1,18 @@
+-- the columns of a blob are those of its current version
+ALTER TABLE blobs ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE blobs ADD COLUMN version_id TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE versions (
+    blob_id      TEXT    NOT NULL REFERENCES blobs (id) ON DELETE CASCADE,
+    version      INTEGER NOT NULL,
+    version_id   TEXT    NOT NULL DEFAULT '', -- empty for content held by the blob id
+    filename     TEXT    NOT NULL DEFAULT '',
+    content_type TEXT    NOT NULL DEFAULT '',
+    size         INTEGER NOT NULL,
+    sha256       TEXT    NOT NULL DEFAULT '',
+    created_at   INTEGER NOT NULL, -- unix milliseconds
+    PRIMARY KEY (blob_id, version)
+) STRICT;
+
+INSERT INTO versions (blob_id, version, filename, content_type, size, sha256, created_at)
+SELECT id, 1, filename, content_type, size, sha256, created_at FROM blobs;
@@ -0,0 +1,11 @@
+-- the storage used by each owner, counting every version and the trash
+CREATE TABLE usage (
+    owner   TEXT    PRIMARY KEY,
+    bytes   INTEGER NOT NULL DEFAULT 0,
+    objects INTEGER NOT NULL DEFAULT 0
+) STRICT;
+
+INSERT INTO usage (owner, bytes, objects)
+SELECT b.owner, SUM(v.size), COUNT(DISTINCT b.id)
+FROM blobs b JOIN versions v ON v.blob_id = b.id
+GROUP BY b.owner;
@@ -0,0 +1,123 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"go.adoublef/blob/internal/log"
+)
+
+const (
+	DefaultReaperInterval  = time.Minute
+	DefaultReaperBatchSize = 100
+	DefaultTrashRetention  = 7 * 24 * time.Hour
+)
+
+// Deleter removes the content of a blob.
+type Deleter interface {
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// Reaper deletes the blobs that have expired or have been in the trash for
+// longer than the retention, first from the bucket and then their metadata,
+// so a blob that failed to be deleted is retried by the next sweep.
+type Reaper struct {
+	db *DB
+	d  Deleter
+	// Interval is the time between sweeps.
+	Interval time.Duration
+	// BatchSize is the number of blobs deleted at a time.
+	BatchSize int
+	// Retention is how long a blob is kept in the trash.
+	Retention time.Duration
+	// Report, if set, is called after each sweep that deleted blobs with
+	// how many were deleted and their total size.
+	Report func(n int, size int64)
+}
+
+// Run sweeps every [Reaper.Interval] until the context is done.
+func (r *Reaper) Run(ctx context.Context) error {
+	t := time.NewTicker(r.Interval)
+	defer t.Stop()
+	for {
+		n, size, err := r.Sweep(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.FromContext(ctx).ErrorContext(ctx, "failed to reap blobs", slog.Int("deleted", n), slog.Any("err", err))
+		case n > 0:
+			log.FromContext(ctx).InfoContext(ctx, "reaped blobs", slog.Int("deleted", n), slog.Int64("size", size))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Sweep deletes the blobs that have expired or outlived their retention in
+// the trash, returning how many were deleted and their total size.
+func (r *Reaper) Sweep(ctx context.Context) (n int, size int64, err error) {
+	if r.Report != nil {
+		defer func() {
+			if n > 0 {
+				r.Report(n, size)
+			}
+		}()
+	}
+	now := time.Now()
+	n, size, err = r.sweep(ctx, func() ([]Blob, error) { return r.db.Expired(ctx, now, r.BatchSize) })
+	if err != nil {
+		return n, size, err
+	}
+	tn, tsize, err := r.sweep(ctx, func() ([]Blob, error) { return r.db.Purgeable(ctx, now.Add(-r.Retention), r.BatchSize) })
+	return n + tn, size + tsize, err
+}
+
+// sweep deletes the blobs returned by list until it returns a partial batch.
+func (r *Reaper) sweep(ctx context.Context, list func() ([]Blob, error)) (n int, size int64, err error) {
+	for {
+		bs, err := list()
+		if err != nil {
+			return n, size, err
+		}
+		var errs []error
+		for _, b := range bs {
+			if err := r.d.Delete(ctx, b.ID); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if err := r.db.Delete(ctx, b.ID); err != nil && !errors.Is(err, ErrNotExist) {
+				errs = append(errs, err)
+				continue
+			}
+			n, size = n+1, size+b.Size
+		}
+		if err := errors.Join(errs...); err != nil {
+			// the failed blobs would be listed again
+			return n, size, err
+		}
+		if len(bs) < r.BatchSize {
+			return n, size, nil
+		}
+	}
+}
+
+// NewReaper returns a [Reaper] of the blobs in db, whose content is removed
+// by d.
+func NewReaper(db *DB, d Deleter, opts ...func(*Reaper)) *Reaper {
+	r := &Reaper{
+		db:        db,
+		d:         d,
+		Interval:  DefaultReaperInterval,
+		BatchSize: DefaultReaperBatchSize,
+		Retention: DefaultTrashRetention,
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
@@ -0,0 +1,100 @@
+package sql_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	. "go.adoublef/blob/internal/database/sql"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Reaper(t *testing.T) {
+	t.Run("Sweep", func(t *testing.T) {
+		db, ctx := newTestDB(t), context.Background()
+
+		now := time.Now()
+		var expired []uuid.UUID
+		for _, exp := range []time.Time{now.Add(-time.Hour), now.Add(-time.Second), now.Add(time.Hour), {}} {
+			b := Blob{ID: uuid.Must(uuid.NewV7()), Size: 10, CreatedAt: now, ExpiresAt: exp}
+			is.OK(t, db.Create(ctx, b, Quotas{})) // create blob
+			if b.Expired(now) {
+				expired = append(expired, b.ID)
+			}
+		}
+
+		var d deleter
+		var reported int
+		r := NewReaper(db, &d, func(r *Reaper) {
+			r.BatchSize = 1
+			r.Report = func(n int, size int64) { reported = n }
+		})
+		n, size, err := r.Sweep(ctx)
+		is.OK(t, err) // sweep expired blobs
+		is.Equal(t, n, 2)
+		is.Equal(t, size, int64(20))
+		is.Equal(t, reported, 2)
+		is.Equal(t, d.deleted, expired)
+		for _, id := range expired {
+			_, err := db.Blob(ctx, id)
+			is.NotOK(t, err, ErrNotExist)
+		}
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		db, ctx := newTestDB(t), context.Background()
+
+		var ids []uuid.UUID
+		for range 2 {
+			id := uuid.Must(uuid.NewV7())
+			is.OK(t, db.Create(ctx, Blob{ID: id, Size: 10, CreatedAt: time.Now()}, Quotas{})) // create blob
+			ids = append(ids, id)
+		}
+		is.OK(t, db.Trash(ctx, ids[0])) // trash blob
+
+		var d deleter
+		r := NewReaper(db, &d, func(r *Reaper) { r.Retention = time.Hour })
+		n, _, err := r.Sweep(ctx)
+		is.OK(t, err) // sweep within retention
+		is.Equal(t, n, 0)
+
+		r.Retention = 0
+		n, _, err = r.Sweep(ctx)
+		is.OK(t, err) // sweep past retention
+		is.Equal(t, n, 1)
+		is.Equal(t, d.deleted, ids[:1])
+		_, err = db.Blob(ctx, ids[1])
+		is.OK(t, err) // keep blob not in trash
+	})
+
+	t.Run("ErrDelete", func(t *testing.T) {
+		db, ctx := newTestDB(t), context.Background()
+
+		b := Blob{ID: uuid.Must(uuid.NewV7()), CreatedAt: time.Now(), ExpiresAt: time.Now().Add(-time.Minute)}
+		is.OK(t, db.Create(ctx, b, Quotas{})) // create blob
+
+		r := NewReaper(db, &deleter{err: errors.New("unavailable")})
+		n, _, err := r.Sweep(ctx)
+		is.True(t, err != nil) // fail to delete content
+		is.Equal(t, n, 0)
+
+		// kept for the next sweep
+		_, err = db.Blob(ctx, b.ID)
+		is.OK(t, err) // return blob
+	})
+}
+
+type deleter struct {
+	deleted []uuid.UUID
+	err     error
+}
+
+func (d *deleter) Delete(ctx context.Context, id uuid.UUID) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.deleted = append(d.deleted, id)
+	return nil
+}
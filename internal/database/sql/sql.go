@@ -0,0 +1,123 @@
+// Package sql stores the metadata of blobs in SQLite.
+package sql
+
+import (
+	"context"
+	sqlpkg "database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+var (
+	ErrNotExist = fs.ErrNotExist // "file does not exist"
+	ErrExist    = fs.ErrExist    // "file already exists"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// DB is a SQLite database of blob metadata.
+type DB struct {
+	db *sqlpkg.DB
+}
+
+// Open opens the database at filename, creating it if needed, and migrates
+// it to the latest schema. The filename ":memory:" opens a database that is
+// discarded when closed.
+func Open(ctx context.Context, filename string) (*DB, error) {
+	q := url.Values{}
+	for _, p := range []string{"journal_mode(WAL)", "busy_timeout(5000)", "foreign_keys(1)", "synchronous(NORMAL)"} {
+		q.Add("_pragma", p)
+	}
+	// writes are serialised by SQLite so a single connection avoids busy
+	// errors, and keeps a :memory: database alive
+	db, err := sqlpkg.Open("sqlite", "file:"+filename+"?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return &DB{db: db}, nil
+}
+
+// Close closes the database.
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+// Check reports whether the database can be reached.
+func (db *DB) Check(ctx context.Context) error {
+	return db.db.PingContext(ctx)
+}
+
+// migrate applies the migrations newer than the user_version of the
+// database, each in its own transaction. Migrations are named by the version
+// they migrate to, such as 0001_blobs.sql.
+func migrate(ctx context.Context, db *sqlpkg.DB) error {
+	var current int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&current); err != nil {
+		return err
+	}
+	entries, err := fs.ReadDir(migrations, "migrations")
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	for _, e := range entries {
+		prefix, _, _ := strings.Cut(e.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", e.Name(), err)
+		}
+		if version <= current {
+			continue
+		}
+		p, err := fs.ReadFile(migrations, path.Join("migrations", e.Name()))
+		if err != nil {
+			return err
+		}
+		if err := inTx(ctx, db, func(tx *sqlpkg.Tx) error {
+			if _, err := tx.ExecContext(ctx, string(p)); err != nil {
+				return err
+			}
+			// pragmas cannot be bound
+			_, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version))
+			return err
+		}); err != nil {
+			return fmt.Errorf("migration %s: %w", e.Name(), err)
+		}
+	}
+	return nil
+}
+
+// isConstraint reports whether err is the violation of a constraint of the
+// given extended result code.
+func isConstraint(err error, code int) bool {
+	var se *sqlite.Error
+	return errors.As(err, &se) && se.Code() == code
+}
+
+// inTx runs f in a transaction that is committed if f returns nil.
+func inTx(ctx context.Context, db *sqlpkg.DB, f func(*sqlpkg.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,292 @@
+package sql_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	. "go.adoublef/blob/internal/database/sql"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Open(t *testing.T) {
+	t.Run("Reopen", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "blob.db")
+		ctx := context.Background()
+
+		db, err := Open(ctx, filename)
+		is.OK(t, err) // open new database
+		b := Blob{ID: uuid.Must(uuid.NewV7()), Size: 1, CreatedAt: time.Now()}
+		is.OK(t, db.Create(ctx, b, Quotas{})) // create blob
+		is.OK(t, db.Close())
+
+		// migrations are not applied twice
+		db, err = Open(ctx, filename)
+		is.OK(t, err) // reopen database
+		_, err = db.Blob(ctx, b.ID)
+		is.OK(t, err) // return blob
+		is.OK(t, db.Close())
+	})
+}
+
+func Test_DB(t *testing.T) {
+	t.Run("Blob", func(t *testing.T) {
+		db, ctx := newTestDB(t), context.Background()
+
+		want := Blob{
+			ID:          uuid.Must(uuid.NewV7()),
+			Filename:    "hello.txt",
+			ContentType: "text/plain",
+			Size:        14,
+			SHA256:      "4dca0fd5f424a31b03ab807cbae77eb32bf2d089eed1cee154b3afed458de0dc",
+			Owner:       "ci",
+			CreatedAt:   time.UnixMilli(time.Now().UnixMilli()),
+			Tags:        map[string]string{"project": "blob"},
+			Version:     1,
+		}
+		is.OK(t, db.Create(ctx, want, Quotas{})) // create blob
+
+		got, err := db.Blob(ctx, want.ID)
+		is.OK(t, err) // return blob
+		is.True(t, got.CreatedAt.Equal(want.CreatedAt))
+		got.CreatedAt = want.CreatedAt
+		is.Equal(t, got, want)
+
+		is.NotOK(t, db.Create(ctx, want, Quotas{}), ErrExist)
+		_, err = db.Blob(ctx, uuid.New())
+		is.NotOK(t, err, ErrNotExist)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		db, ctx := newTestDB(t), context.Background()
+
+		var ids []uuid.UUID
+		for range 5 {
+			id := uuid.Must(uuid.NewV7())
+			is.OK(t, db.Create(ctx, Blob{ID: id, CreatedAt: time.Now()}, Quotas{})) // create blob
+			ids = append(ids, id)
+		}
+
+		page, err := db.List(ctx, ListOptions{Limit: 2})
+		is.OK(t, err) // list first page
+		is.Equal(t, len(page), 2)
+		is.Equal(t, page[0].ID, ids[0])
+
+		page, err = db.List(ctx, ListOptions{After: page[1].ID, Limit: 10})
+		is.OK(t, err) // list next page
+		is.Equal(t, len(page), 3)
+		is.Equal(t, page[2].ID, ids[4])
+	})
+	t.Run("ListTags", func(t *testing.T) {
+		db, ctx := newTestDB(t), context.Background()
+
+		for _, tags := range []map[string]string{
+			{"project": "a", "customer": "acme"},
+			{"project": "a", "customer": "initech"},
+			{"project": "b", "customer": "acme"},
+		} {
+			is.OK(t, db.Create(ctx, Blob{ID: uuid.Must(uuid.NewV7()), CreatedAt: time.Now(), Tags: tags}, Quotas{})) // create blob
+		}
+
+		page, err := db.List(ctx, ListOptions{Tags: map[string]string{"project": "a"}})
+		is.OK(t, err) // list by project
+		is.Equal(t, len(page), 2)
+
+		page, err = db.List(ctx, ListOptions{Tags: map[string]string{"project": "a", "customer": "acme"}})
+		is.OK(t, err) // list by project and customer
+		is.Equal(t, len(page), 1)
+		is.Equal(t, page[0].Tags, map[string]string{"project": "a", "customer": "acme"})
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		db, ctx := newTestDB(t), context.Background()
+
+		id := uuid.Must(uuid.NewV7())
+		is.OK(t, db.Create(ctx, Blob{ID: id, CreatedAt: time.Now(), Tags: map[string]string{"a": "1", "b": "2"}}, Quotas{})) // create blob
+
+		b, err := db.UpdateTags(ctx, id, map[string]*string{"a": nil, "c": ptr("3")})
+		is.OK(t, err) // update tags
+		is.Equal(t, b.Tags, map[string]string{"b": "2", "c": "3"})
+
+		b, err = db.SetTags(ctx, id, map[string]string{"d": "4"})
+		is.OK(t, err) // set tags
+		is.Equal(t, b.Tags, map[string]string{"d": "4"})
+
+		b, err = db.SetTags(ctx, id, nil)
+		is.OK(t, err) // clear tags
+		is.Equal(t, len(b.Tags), 0)
+
+		_, err = db.SetTags(ctx, uuid.New(), nil)
+		is.NotOK(t, err, ErrNotExist)
+		_, err = db.UpdateTags(ctx, uuid.New(), nil)
+		is.NotOK(t, err, ErrNotExist)
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		db, ctx := newTestDB(t), context.Background()
+
+		id := uuid.Must(uuid.NewV7())
+		is.OK(t, db.Create(ctx, Blob{ID: id, CreatedAt: time.Now()}, Quotas{})) // create blob
+		is.OK(t, db.Trash(ctx, id))                                             // trash blob
+		is.NotOK(t, db.Trash(ctx, id), ErrNotExist)
+
+		b, err := db.Blob(ctx, id)
+		is.OK(t, err) // return trashed blob
+		is.True(t, b.Trashed())
+		_, err = db.SetTags(ctx, id, nil)
+		is.NotOK(t, err, ErrNotExist)
+
+		for trashed, n := range map[bool]int{false: 0, true: 1} {
+			page, err := db.List(ctx, ListOptions{Trashed: trashed})
+			is.OK(t, err) // list blobs
+			is.Equal(t, len(page), n)
+		}
+
+		b, err = db.Restore(ctx, id)
+		is.OK(t, err) // restore blob
+		is.True(t, !b.Trashed())
+		_, err = db.Restore(ctx, id)
+		is.NotOK(t, err, ErrNotExist)
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		db, ctx := newTestDB(t), context.Background()
+
+		id := uuid.Must(uuid.NewV7())
+		is.OK(t, db.Create(ctx, Blob{ID: id, Filename: "v1.txt", Size: 1, CreatedAt: time.Now(), Tags: map[string]string{"a": "1"}}, Quotas{})) // create blob
+
+		vid := uuid.Must(uuid.NewV7())
+		b, err := db.AddVersion(ctx, Blob{ID: id, VersionID: vid, Filename: "v2.txt", Size: 2, CreatedAt: time.Now()}, Quotas{})
+		is.OK(t, err) // add version
+		is.Equal(t, b.Version, 2)
+		is.Equal(t, b.VersionID, vid)
+		is.Equal(t, b.Filename, "v2.txt")
+		is.Equal(t, b.Tags, map[string]string{"a": "1"})
+
+		vs, err := db.Versions(ctx, id)
+		is.OK(t, err) // list versions
+		is.Equal(t, len(vs), 2)
+		is.Equal(t, vs[0].Filename, "v1.txt")
+
+		b, err = db.BlobVersion(ctx, id, 1)
+		is.OK(t, err) // return first version
+		is.Equal(t, b.Size, int64(1))
+		is.Equal(t, b.VersionID, uuid.Nil)
+
+		b, err = db.SetVersion(ctx, id, 1)
+		is.OK(t, err) // restore first version
+		is.Equal(t, b.Filename, "v1.txt")
+		is.Equal(t, b.Version, 1)
+
+		b, err = db.AddVersion(ctx, Blob{ID: id, Size: 3, CreatedAt: time.Now()}, Quotas{})
+		is.OK(t, err) // add version after restore
+		is.Equal(t, b.Version, 3)
+
+		_, err = db.SetVersion(ctx, id, 4)
+		is.NotOK(t, err, ErrNotExist)
+		_, err = db.BlobVersion(ctx, id, 4)
+		is.NotOK(t, err, ErrNotExist)
+		_, err = db.AddVersion(ctx, Blob{ID: uuid.New()}, Quotas{})
+		is.NotOK(t, err, ErrNotExist)
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		db, ctx := newTestDB(t), context.Background()
+
+		a, b := uuid.Must(uuid.NewV7()), uuid.Must(uuid.NewV7())
+		is.OK(t, db.Create(ctx, Blob{ID: a, Size: 1, Owner: "ci", CreatedAt: time.Now()}, Quotas{}))     // create blob
+		is.OK(t, db.Create(ctx, Blob{ID: b, Size: 10, Owner: "admin", CreatedAt: time.Now()}, Quotas{})) // create blob
+		_, err := db.AddVersion(ctx, Blob{ID: a, VersionID: uuid.Must(uuid.NewV7()), Size: 2, CreatedAt: time.Now()}, Quotas{})
+		is.OK(t, err)              // add version
+		is.OK(t, db.Trash(ctx, b)) // trash blob, which is still counted
+		u, err := db.Usage(ctx, "ci")
+		is.OK(t, err) // return usage of owner
+		is.Equal(t, u, Usage{Bytes: 3, Objects: 1})
+		u, err = db.TotalUsage(ctx)
+		is.OK(t, err) // return total usage
+		is.Equal(t, u, Usage{Bytes: 13, Objects: 2})
+
+		is.OK(t, db.Delete(ctx, a)) // delete blob
+		u, err = db.Usage(ctx, "ci")
+		is.OK(t, err) // return usage of owner
+		is.Equal(t, u, Usage{})
+		u, err = db.Usage(ctx, "nobody")
+		is.OK(t, err) // return usage of unknown owner
+		is.Equal(t, u, Usage{})
+		is.NotOK(t, db.Delete(ctx, a), ErrNotExist)
+	})
+
+	t.Run("Quotas", func(t *testing.T) {
+		db, ctx := newTestDB(t), context.Background()
+		qs := Quotas{Total: Usage{Bytes: 20}, Owner: Usage{Objects: 1}}
+
+		// concurrent blobs are checked one at a time
+		errs := make(chan error, 2)
+		for range 2 {
+			go func() { errs <- db.Create(ctx, Blob{ID: uuid.Must(uuid.NewV7()), Size: 14, CreatedAt: time.Now()}, qs) }()
+		}
+		err1, err2 := <-errs, <-errs
+		is.True(t, (err1 == nil) != (err2 == nil)) // one blob fits
+		is.NotOK(t, errors.Join(err1, err2), ErrQuotaExceeded)
+
+		id := uuid.Must(uuid.NewV7())
+		is.OK(t, db.Create(ctx, Blob{ID: id, Size: 1, Owner: "ci", CreatedAt: time.Now()}, qs))                                  // create blob
+		is.NotOK(t, db.Create(ctx, Blob{ID: uuid.Must(uuid.NewV7()), Owner: "ci", CreatedAt: time.Now()}, qs), ErrQuotaExceeded) // owner is limited
+		_, err := db.AddVersion(ctx, Blob{ID: id, Size: 10, CreatedAt: time.Now()}, qs)
+		is.NotOK(t, err, ErrQuotaExceeded)
+
+		u, err := db.TotalUsage(ctx)
+		is.OK(t, err) // return total usage
+		is.Equal(t, u, Usage{Bytes: 15, Objects: 2})
+	})
+
+	t.Run("Backfill", func(t *testing.T) {
+		db, ctx := newTestDB(t), context.Background()
+
+		recorded, legacy, recent := uuid.Must(uuid.NewV7()), uuid.Must(uuid.NewV7()), uuid.Must(uuid.NewV7())
+		is.OK(t, db.Create(ctx, Blob{ID: recorded, Filename: "a.txt", Size: 10, CreatedAt: time.Now()}, Quotas{})) // create blob
+		old := time.Now().Add(-2 * DefaultBackfillMinAge)
+		l := lister{recorded: old, legacy: old, recent: time.Now()}
+		for _, want := range []int{1, 0} {
+			n, err := db.Backfill(ctx, l, DefaultBackfillMinAge)
+			is.OK(t, err) // backfill blobs
+			is.Equal(t, n, want)
+		}
+
+		b, err := db.Blob(ctx, recorded)
+		is.OK(t, err) // return recorded blob
+		is.Equal(t, b.Filename, "a.txt")
+		b, err = db.Blob(ctx, legacy)
+		is.OK(t, err) // return backfilled blob
+		is.Equal(t, b.Size, int64(10))
+		_, err = db.Blob(ctx, recent)
+		is.NotOK(t, err, ErrNotExist)
+		u, err := db.TotalUsage(ctx)
+		is.OK(t, err) // return total usage
+		is.Equal(t, u, Usage{Bytes: 20, Objects: 2})
+	})
+}
+
+type lister map[uuid.UUID]time.Time
+
+func (l lister) Blobs(ctx context.Context, fn func(id uuid.UUID, size int64, modified time.Time) error) error {
+	for id, modified := range l {
+		if err := fn(id, 10, modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func newTestDB(tb testing.TB) *DB {
+	tb.Helper()
+	db, err := Open(context.Background(), filepath.Join(tb.TempDir(), "blob.db"))
+	is.OK(tb, err) // open database
+	tb.Cleanup(func() { db.Close() })
+	return db
+}
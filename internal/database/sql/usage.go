@@ -0,0 +1,79 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrQuotaExceeded is returned when recording a blob would exceed its quotas.
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Usage is the storage used by blobs, counting each of their versions. Blobs
+// in the trash are counted until they are purged.
+type Usage struct {
+	Bytes   int64
+	Objects int64
+}
+
+// Quotas caps the storage used by all blobs and by the blobs of their owner.
+// Zero values are unlimited.
+type Quotas struct {
+	Total Usage
+	Owner Usage
+}
+
+// Usage returns the storage used by the blobs of the owner.
+func (db *DB) Usage(ctx context.Context, owner string) (Usage, error) {
+	return usage(ctx, db.db, " WHERE owner = ?", owner)
+}
+
+// TotalUsage returns the storage used by all blobs.
+func (db *DB) TotalUsage(ctx context.Context) (Usage, error) {
+	return usage(ctx, db.db, "")
+}
+
+func usage(ctx context.Context, q queryer, where string, args ...any) (Usage, error) {
+	var u Usage
+	err := q.QueryRowContext(ctx, `
+SELECT COALESCE(SUM(bytes), 0), COALESCE(SUM(objects), 0) FROM usage`+where, args...).Scan(&u.Bytes, &u.Objects)
+	return u, err
+}
+
+// checkQuotas fails with [ErrQuotaExceeded] if the storage used by all blobs
+// or by those of the owner exceeds the quotas. It is called after the usage
+// is added in the same transaction, so concurrent blobs cannot both fit.
+func checkQuotas(ctx context.Context, q queryer, owner string, qs Quotas) error {
+	check := func(name string, quota Usage, where string, args ...any) error {
+		if quota == (Usage{}) {
+			return nil
+		}
+		u, err := usage(ctx, q, where, args...)
+		if err != nil {
+			return err
+		}
+		if quota.Objects > 0 && u.Objects > quota.Objects {
+			return fmt.Errorf("%w: %s is limited to %d files", ErrQuotaExceeded, name, quota.Objects)
+		}
+		if quota.Bytes > 0 && u.Bytes > quota.Bytes {
+			return fmt.Errorf("%w: %s is limited to %d bytes", ErrQuotaExceeded, name, quota.Bytes)
+		}
+		return nil
+	}
+	if err := check("storage", qs.Total, ""); err != nil {
+		return err
+	}
+	if owner == "" {
+		return nil
+	}
+	return check("owner", qs.Owner, " WHERE owner = ?", owner)
+}
+
+// addUsage adds to the storage used by the owner, which is negative for
+// storage that was freed.
+func addUsage(ctx context.Context, q queryer, owner string, u Usage) error {
+	_, err := q.ExecContext(ctx, `
+INSERT INTO usage (owner, bytes, objects) VALUES (?, ?, ?)
+ON CONFLICT (owner) DO UPDATE SET bytes = bytes + excluded.bytes, objects = objects + excluded.objects`, owner, u.Bytes, u.Objects)
+	return err
+}
@@ -0,0 +1,47 @@
+// Package log carries a structured [slog.Logger] in a context.
+package log
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+type contextKey struct{ s string }
+
+func (k contextKey) String() string { return "go.adoublef/blob/internal/log: " + k.s }
+
+var loggerKey = &contextKey{"logger"}
+
+// New returns a logger writing records at or above level to w in the given
+// format, either [FormatJSON] or [FormatText].
+func New(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// NewContext returns a copy of ctx carrying l.
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the logger carried by ctx or [slog.Default].
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
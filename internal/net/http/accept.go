@@ -1,14 +1,13 @@
 package http
 
 import (
-	"compress/gzip"
 	"context"
 	"fmt"
-	"io"
+	"log/slog"
 	"net/http"
 
 	"github.com/golang/gddo/httputil"
-	"go.adoublef/blob/internal/runtime/debug"
+	"go.adoublef/blob/internal/log"
 )
 
 const (
@@ -23,14 +22,11 @@ var notAcceptableHandler = &statusHandler{
 `[1:], ContentTypHTML, ContentTypJSON),
 }
 
-// AcceptHandler
+// AcceptHandler negotiates the content type of the response and compresses it
+// using [CompressHandler].
 func AcceptHandler(h http.Handler) http.Handler {
-	var (
-		ct = []string{ContentTypJSON, ContentTypHTML}
-		ce = []string{"identity", "gzip" /* "deflate", "zstd", "zlib" */}
-	)
-	// note: if we allow compression option
-	// panic if invalid
+	ct := []string{ContentTypJSON, ContentTypHTML}
+	h = CompressHandler(h)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
@@ -39,31 +35,9 @@ func AcceptHandler(h http.Handler) http.Handler {
 			notAcceptableHandler.ServeHTTP(w, r)
 			return
 		}
-		debug.Printf("AcceptHandler: %q = httputil.NegotiateContentType(r, ct, _)", accept)
+		log.FromContext(ctx).DebugContext(ctx, "negotiated content type", slog.String("accept", accept))
 
 		ctx = context.WithValue(ctx, ContentTypOfferKey, accept)
-		encoding := httputil.NegotiateContentEncoding(r, ce)
-		// note: should we always encode text/html?
-		if encoding == "" || encoding == ce[0] {
-			h.ServeHTTP(w, r.WithContext(ctx))
-			return
-		}
-		debug.Printf("AcceptHandler: %q = negotiate.ContentEncoding(r, ce)", encoding)
-
-		w.Header().Set("Content-Encoding", encoding)
-		//
-		gw, _ := gzip.NewWriterLevel(w, gzip.DefaultCompression)
-		defer gw.Close() // should I defer?
-		h.ServeHTTP(&gzipWriter{w, gw}, r.WithContext(ctx))
+		h.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
-
-type gzipWriter struct {
-	http.ResponseWriter
-	io.Writer
-}
-
-// Write implements http.ResponseWriter.
-func (w *gzipWriter) Write(p []byte) (int, error) {
-	return w.Writer.Write(p)
-}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"testing"
 
 	. "go.adoublef/blob/internal/net/http"
@@ -75,7 +76,7 @@ func Test_AcceptHandler(t *testing.T) {
 		is.OK(t, err)
 		is.OK(t, res.Body.Close())
 
-		is.Equal(t, string(p), "<p>text/html</p>") // got;want body
+		is.Equal(t, string(p), "<p>text/html</p>"+lorem) // got;want body
 	})
 
 	t.Run("ErrNotSet", func(t *testing.T) {
@@ -95,12 +96,16 @@ func Test_AcceptHandler(t *testing.T) {
 	})
 }
 
+// lorem pads bodies beyond [DefaultCompressMinSize].
+var lorem = strings.Repeat("<p>Lorem ipsum dolor sit amet.</p>", 64)
+
 func newAcceptClient(tb testing.TB) *TestClient {
 	tb.Helper()
 	// encode json data as a response
 	handleTest := func() http.HandlerFunc {
 		type body struct {
-			Typ string `json:"contentType"`
+			Typ  string `json:"contentType"`
+			Text string `json:"text"`
 		}
 
 		return func(w http.ResponseWriter, r *http.Request) {
@@ -115,12 +120,12 @@ func newAcceptClient(tb testing.TB) *TestClient {
 
 			w.Header().Set("Content-Type", accept)
 			if accept == ContentTypJSON {
-				err := json.NewEncoder(w).Encode(&body{accept})
+				err := json.NewEncoder(w).Encode(&body{accept, lorem})
 				tb.Logf("%v := json.NewEncoder(w).Encode(_)", err)
 				return
 			}
 			// return html
-			_, err := fmt.Fprintf(w, "<p>%s</p>", accept)
+			_, err := fmt.Fprintf(w, "<p>%s</p>%s", accept, lorem)
 			tb.Logf("%v := fmt.Fprintf(w, _, accept)", err)
 		}
 	}
@@ -0,0 +1,209 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// A Scope is a permission granted to a caller.
+type Scope string
+
+const (
+	ScopeFilesRead   Scope = "files:read"
+	ScopeFilesWrite  Scope = "files:write"
+	ScopeFilesDelete Scope = "files:delete"
+)
+
+// ErrUnauthenticated is returned by an [Authenticator] for credentials it
+// does not recognise.
+var ErrUnauthenticated = errors.New("invalid credentials")
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// ID is the name of an API key or the subject of a token, which is
+	// recorded as the owner of the blobs it uploads.
+	ID     string
+	Scopes []Scope
+	// Tenant is the id of the tenant the caller belongs to, or empty for
+	// the default tenant.
+	Tenant string
+}
+
+// Can reports whether the principal was granted the scope.
+func (p *Principal) Can(s Scope) bool {
+	return slices.Contains(p.Scopes, s)
+}
+
+// An Authenticator returns the caller presenting a bearer token.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+// AuthenticatorFunc is an adapter to allow the use of ordinary functions as
+// an [Authenticator].
+type AuthenticatorFunc func(ctx context.Context, token string) (*Principal, error)
+
+// Authenticate calls f(ctx, token).
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	return f(ctx, token)
+}
+
+// Authenticators tries each [Authenticator] in turn, such that callers may
+// present either kind of credential.
+type Authenticators []Authenticator
+
+// Authenticate implements [Authenticator].
+func (as Authenticators) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	for _, a := range as {
+		p, err := a.Authenticate(ctx, token)
+		if errors.Is(err, ErrUnauthenticated) {
+			continue
+		}
+		return p, err
+	}
+	return nil, ErrUnauthenticated
+}
+
+// A KeyStore returns the caller an API key was issued to by the hex encoded
+// SHA-256 hash of the key, so that keys are never stored in the clear.
+type KeyStore interface {
+	LookupKey(ctx context.Context, hash string) (*Principal, error)
+}
+
+// APIKeys authenticates callers by API keys held in a [KeyStore].
+type APIKeys struct {
+	store KeyStore
+}
+
+// NewAPIKeys returns [APIKeys] backed by s.
+func NewAPIKeys(s KeyStore) *APIKeys {
+	return &APIKeys{store: s}
+}
+
+// Authenticate implements [Authenticator].
+func (a *APIKeys) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	return a.store.LookupKey(ctx, HashKey(token))
+}
+
+// HashKey returns the hash an API key is stored by.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// MapKeyStore is a [KeyStore] of principals by the hash of their key.
+type MapKeyStore map[string]*Principal
+
+// LookupKey implements [KeyStore].
+func (m MapKeyStore) LookupKey(ctx context.Context, hash string) (*Principal, error) {
+	p, ok := m[hash]
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	return p, nil
+}
+
+// ReadKeyStore decodes a JSON array of keys such as
+//
+//	[{"id": "ci", "sha256": "<hex>", "scopes": ["files:read", "files:write"], "tenant": "acme"}]
+//
+// where the tenant is optional.
+func ReadKeyStore(r io.Reader) (MapKeyStore, error) {
+	var keys []struct {
+		ID     string  `json:"id"`
+		SHA256 string  `json:"sha256"`
+		Scopes []Scope `json:"scopes"`
+		Tenant string  `json:"tenant"`
+	}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&keys); err != nil {
+		return nil, err
+	}
+	m := make(MapKeyStore, len(keys))
+	for i, k := range keys {
+		hash := strings.ToLower(k.SHA256)
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("key %d: invalid sha256 hash", i)
+		}
+		if k.ID == "" {
+			return nil, fmt.Errorf("key %d: missing id", i)
+		}
+		if _, ok := m[hash]; ok {
+			return nil, fmt.Errorf("key %q: duplicate hash", k.ID)
+		}
+		m[hash] = &Principal{ID: k.ID, Scopes: k.Scopes, Tenant: k.Tenant}
+	}
+	return m, nil
+}
+
+// PrincipalOf returns the authenticated caller carried by ctx.
+func PrincipalOf(ctx context.Context) (*Principal, bool) {
+	return value[*Principal](ctx, PrincipalKey)
+}
+
+// AuthHandler authenticates the bearer token of each request, storing the
+// caller in the context under [PrincipalKey]. Requests without valid
+// credentials are rejected with 401 Unauthorized. A caller already in the
+// context, such as one authenticated to resolve its tenant, is trusted.
+func AuthHandler(a Authenticator, h http.Handler) http.Handler {
+	var unauthorized = statusHandler{
+		code: http.StatusUnauthorized,
+		s:    `missing or invalid bearer token`,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if p, ok := PrincipalOf(ctx); ok {
+			logAttrs(ctx, slog.String("principal", p.ID))
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			unauthorized.ServeHTTP(w, r)
+			return
+		}
+		p, err := a.Authenticate(ctx, strings.TrimSpace(token))
+		if errors.Is(err, ErrUnauthenticated) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			unauthorized.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		logAttrs(ctx, slog.String("principal", p.ID))
+		ctx = context.WithValue(ctx, PrincipalKey, p)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireScope rejects requests whose caller was not granted the scope with
+// 403 Forbidden.
+func requireScope(s Scope, h http.Handler) http.Handler {
+	var forbidden = statusHandler{
+		code: http.StatusForbidden,
+		s:    fmt.Sprintf("missing scope %q", s),
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalOf(r.Context())
+		if !ok || !p.Can(s) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, s))
+			forbidden.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,87 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_AuthHandler(t *testing.T) {
+	keys := MapKeyStore{
+		HashKey("reader"): {ID: "reader", Scopes: []Scope{ScopeFilesRead}},
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	acceptJSON := func(r *http.Request) { r.Header.Set("Accept", ContentTypJSON) }
+
+	for _, tc := range []struct {
+		name    string
+		pattern string
+		opts    []func(*http.Request)
+		code    int
+	}{
+		{"Missing", "GET /cloud-storage/files/invalid", nil, http.StatusUnauthorized},
+		{"Invalid", "GET /cloud-storage/files/invalid", []func(*http.Request){bearer("writer")}, http.StatusUnauthorized},
+		{"Scope", "POST /cloud-storage/files", []func(*http.Request){bearer("reader")}, http.StatusForbidden},
+		{"OK", "GET /cloud-storage/files/invalid", []func(*http.Request){bearer("reader")}, http.StatusBadRequest},
+		{"Public", "GET /live", nil, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := Handler(nil, func(o *Options) { o.Authenticator = NewAPIKeys(keys) })
+			c, ctx := newTestClient(t, h), context.Background()
+
+			res, err := c.Do(ctx, tc.pattern, nil, append(tc.opts, acceptJSON)...)
+			is.OK(t, err) // return response
+			is.Equal(t, res.StatusCode, tc.code)
+			if tc.code == http.StatusUnauthorized || tc.code == http.StatusForbidden {
+				is.True(t, strings.HasPrefix(res.Header.Get("WWW-Authenticate"), "Bearer"))
+
+				var body struct {
+					Status    int    `json:"status"`
+					RequestID string `json:"requestId"`
+				}
+				is.OK(t, json.NewDecoder(res.Body).Decode(&body)) // decode error
+				is.Equal(t, body.Status, tc.code)
+				is.Equal(t, body.RequestID, res.Header.Get(HeaderRequestID))
+			}
+			is.OK(t, res.Body.Close())
+		})
+	}
+}
+
+func Test_ReadKeyStore(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		hash := HashKey("secret")
+		ks, err := ReadKeyStore(strings.NewReader(`[{"id": "ci", "sha256": "` + hash + `", "scopes": ["files:read"]}]`))
+		is.OK(t, err) // read key store
+
+		p, err := NewAPIKeys(ks).Authenticate(context.Background(), "secret")
+		is.OK(t, err) // authenticate key
+		is.Equal(t, p.ID, "ci")
+		is.True(t, p.Can(ScopeFilesRead))
+		is.True(t, !p.Can(ScopeFilesWrite))
+
+		_, err = NewAPIKeys(ks).Authenticate(context.Background(), "guess")
+		is.NotOK(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("Err", func(t *testing.T) {
+		hash := HashKey("secret")
+		for _, s := range []string{
+			`{}`,
+			`[{"id": "ci", "sha256": "plain"}]`,
+			`[{"sha256": "` + hash + `"}]`,
+			`[{"id": "a", "sha256": "` + hash + `"}, {"id": "b", "sha256": "` + hash + `"}]`,
+			`[{"id": "ci", "key": "secret"}]`,
+		} {
+			_, err := ReadKeyStore(strings.NewReader(s))
+			is.True(t, err != nil) // invalid key store
+		}
+	})
+}
@@ -0,0 +1,253 @@
+package http
+
+import (
+	"bufio"
+	"compress/gzip"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/gddo/httputil"
+	"go.adoublef/blob/internal/log"
+)
+
+const (
+	DefaultCompressMinSize = 1 << 10 // responses smaller than a single packet are not worth compressing
+)
+
+// noCompress lists media types that are already compressed.
+// An entry ending in "/" matches every subtype.
+var noCompress = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"font/woff2",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-bzip2",
+	"application/x-rar-compressed",
+	"application/x-xz",
+	"application/octet-stream",
+}
+
+// compressible reports whether the media type is worth compressing.
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// let the content be sniffed
+		return contentType == ""
+	}
+	if mt == "image/svg+xml" {
+		return true
+	}
+	for _, s := range noCompress {
+		if strings.HasSuffix(s, "/") && strings.HasPrefix(mt, s) || mt == s {
+			return false
+		}
+	}
+	return true
+}
+
+var gzipPool = sync.Pool{
+	New: func() any {
+		gw, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return gw
+	},
+}
+
+// CompressHandler compresses response bodies using the encoding negotiated
+// from the Accept-Encoding header.
+//
+// A response is sent as-is if it is smaller than [DefaultCompressMinSize],
+// has a media type that is already compressed, is a partial response or
+// already carries a Content-Encoding.
+func CompressHandler(h http.Handler) http.Handler {
+	ce := []string{"identity", "gzip" /* "deflate", "zstd", "zlib" */}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response varies whether or not it ends up compressed
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := httputil.NegotiateContentEncoding(r, ce)
+		if encoding == "" || encoding == ce[0] || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		log.FromContext(r.Context()).DebugContext(r.Context(), "negotiated content encoding", slog.String("encoding", encoding))
+
+		gw := &gzipWriter{ResponseWriter: w, min: DefaultCompressMinSize}
+		defer gw.Close()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+// gzipWriter buffers the start of a response until it is known whether it is
+// worth compressing.
+type gzipWriter struct {
+	http.ResponseWriter
+	gw   *gzip.Writer
+	min  int
+	buf  []byte
+	code int
+	// done is set once the compression decision has been made and the
+	// header has been written to the underlying [http.ResponseWriter].
+	done bool
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *gzipWriter) WriteHeader(code int) {
+	if code >= 100 && code <= 199 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.done || w.code != 0 {
+		return
+	}
+	w.code = code
+
+	h := w.Header()
+	if !w.allowed() {
+		w.start(false)
+		return
+	}
+	// known length can be decided right away
+	if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil {
+		w.start(cl >= w.min)
+	}
+}
+
+// Write implements http.ResponseWriter.
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.done {
+		if w.gw != nil {
+			return w.gw.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < w.min {
+		return len(p), nil
+	}
+	if err := w.decide(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush implements http.Flusher.
+func (w *gzipWriter) Flush() {
+	_ = w.FlushError()
+}
+
+// FlushError is called by [http.ResponseController].
+func (w *gzipWriter) FlushError() error {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.done {
+		// a flush signals a streaming response, so do not wait for min bytes
+		if err := w.decide(); err != nil {
+			return err
+		}
+	}
+	if w.gw != nil {
+		if err := w.gw.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker.
+func (w *gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap is called by [http.ResponseController].
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close writes any buffered data and releases the [gzip.Writer].
+func (w *gzipWriter) Close() error {
+	if w.code == 0 {
+		// nothing was written by the handler
+		return nil
+	}
+	if !w.done {
+		// the body never reached the minimum size
+		if err := w.start(false); err != nil {
+			return err
+		}
+	}
+	if w.gw == nil {
+		return nil
+	}
+	err := w.gw.Close()
+	w.gw.Reset(nil)
+	gzipPool.Put(w.gw)
+	w.gw = nil
+	return err
+}
+
+// allowed reports whether the headers written so far permit compression.
+func (w *gzipWriter) allowed() bool {
+	h := w.Header()
+	switch {
+	case w.code < 200,
+		w.code == http.StatusNoContent,
+		w.code == http.StatusPartialContent,
+		w.code == http.StatusNotModified:
+		return false
+	case h.Get("Content-Encoding") != "",
+		h.Get("Content-Range") != "":
+		return false
+	}
+	return compressible(h.Get("Content-Type"))
+}
+
+// decide starts the response once enough of the body is known.
+func (w *gzipWriter) decide() error {
+	if h := w.Header(); h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		// sniff before the body becomes unrecognisable
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	return w.start(w.allowed())
+}
+
+// start writes the header and any buffered data to the underlying
+// [http.ResponseWriter], compressing from now on if compress is true.
+func (w *gzipWriter) start(compress bool) error {
+	w.done = true
+	h := w.Header()
+	if compress {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gw = gzipPool.Get().(*gzip.Writer)
+		w.gw.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gw != nil {
+		_, err = w.gw.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
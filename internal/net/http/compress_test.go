@@ -0,0 +1,141 @@
+package http_test
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_CompressHandler(t *testing.T) {
+	acceptEnc := func(s string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Accept-Encoding", s) }
+	}
+
+	t.Run("Vary", func(t *testing.T) {
+		c, ctx := newCompressClient(t), context.Background()
+
+		for _, enc := range []string{"gzip", "identity", ""} {
+			res, err := c.Do(ctx, "GET /text", nil, acceptEnc(enc))
+			is.OK(t, err)
+			is.Equal(t, res.Header.Get("Vary"), "Accept-Encoding") // got;want vary
+			is.OK(t, res.Body.Close())
+		}
+	})
+
+	t.Run("Gzip", func(t *testing.T) {
+		c, ctx := newCompressClient(t), context.Background()
+
+		type testcase struct {
+			pattern string
+			encode  string
+		}
+
+		for _, tc := range []testcase{
+			{pattern: "GET /text", encode: "gzip"},
+			{pattern: "GET /svg", encode: "gzip"},
+			{pattern: "GET /sniff", encode: "gzip"},
+			{pattern: "GET /small", encode: ""},
+			{pattern: "GET /png", encode: ""},
+			{pattern: "GET /zip", encode: ""},
+			{pattern: "GET /range", encode: ""},
+			{pattern: "GET /encoded", encode: "br"},
+			{pattern: "HEAD /text", encode: ""},
+		} {
+			res, err := c.Do(ctx, tc.pattern, nil, acceptEnc("gzip"))
+			is.OK(t, err)
+			is.Equal(t, res.Header.Get("Content-Encoding"), tc.encode) // got;want contentEncoding
+			is.OK(t, res.Body.Close())
+		}
+	})
+
+	t.Run("ContentLength", func(t *testing.T) {
+		c, ctx := newCompressClient(t), context.Background()
+
+		res, err := c.Do(ctx, "GET /length", nil, acceptEnc("gzip"))
+		is.OK(t, err)
+		is.Equal(t, res.Header.Get("Content-Encoding"), "gzip") // got;want contentEncoding
+		is.True(t, res.ContentLength != int64(len(lorem)))      // uncompressed length dropped
+
+		gr, err := gzip.NewReader(res.Body)
+		is.OK(t, err)
+		p, err := io.ReadAll(gr)
+		is.OK(t, err)
+		is.OK(t, res.Body.Close())
+
+		is.Equal(t, string(p), lorem) // got;want body
+	})
+
+	t.Run("Flush", func(t *testing.T) {
+		c, ctx := newCompressClient(t), context.Background()
+
+		res, err := c.Do(ctx, "GET /stream", nil, acceptEnc("gzip"))
+		is.OK(t, err)
+		is.Equal(t, res.StatusCode, http.StatusOK)              // got;want statusCode
+		is.Equal(t, res.Header.Get("Content-Encoding"), "gzip") // got;want contentEncoding
+
+		gr, err := gzip.NewReader(res.Body)
+		is.OK(t, err)
+		p, err := io.ReadAll(gr)
+		is.OK(t, err)
+		is.OK(t, res.Body.Close())
+
+		is.Equal(t, string(p), "data: 0\n\ndata: 1\n\ndata: 2\n\n") // got;want body
+	})
+}
+
+func newCompressClient(tb testing.TB) *TestClient {
+	tb.Helper()
+
+	write := func(typ, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if typ != "" {
+				w.Header().Set("Content-Type", typ)
+			}
+			io.WriteString(w, body)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /text", write("text/html", lorem))
+	mux.HandleFunc("GET /svg", write("image/svg+xml", lorem))
+	mux.HandleFunc("GET /sniff", write("", lorem))
+	mux.HandleFunc("GET /small", write("text/html", "<p>small</p>"))
+	mux.HandleFunc("GET /png", write("image/png", lorem))
+	mux.HandleFunc("GET /zip", write("application/zip", lorem))
+	mux.HandleFunc("GET /range", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Range", "bytes 0-"+strconv.Itoa(len(lorem)-1)+"/*")
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, lorem)
+	})
+	mux.HandleFunc("GET /encoded", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "br")
+		io.WriteString(w, lorem)
+	})
+	mux.HandleFunc("GET /length", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Length", strconv.Itoa(len(lorem)))
+		io.WriteString(w, lorem)
+	})
+	mux.HandleFunc("GET /stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		rc := http.NewResponseController(w)
+		for i := range 3 {
+			io.WriteString(w, "data: "+strconv.Itoa(i)+"\n\n")
+			err := rc.Flush()
+			tb.Logf("%v := rc.Flush()", err)
+			if err != nil {
+				io.WriteString(w, err.Error())
+				return
+			}
+		}
+	})
+	return newTestClient(tb, CompressHandler(mux))
+}
@@ -8,6 +8,9 @@ func (k contextKey) String() string { return "go.adoublef/blob/internal/net/http
 
 var (
 	ContentTypOfferKey = &contextKey{"accept-offer"}
+	RequestIDKey       = &contextKey{"request-id"}
+	PrincipalKey       = &contextKey{"principal"}
+	TenantKey          = &contextKey{"tenant"}
 )
 
 // mustValue returns the context value else panics.
@@ -0,0 +1,245 @@
+package http
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultCORSMaxAge = 5 * time.Minute
+
+var (
+	DefaultCORSMethods = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodDelete,
+	}
+	// DefaultCORSHeaders are the request headers understood by the
+	// [Handler]. An entry ending in "*" matches every header with its prefix.
+	DefaultCORSHeaders = []string{
+		"Accept",
+		"Authorization",
+		"Content-Type",
+		"Range",
+		"If-Match",
+		"If-None-Match",
+		"Traceparent",
+		"Tracestate",
+		HeaderRequestID,
+		HeaderExpiresIn,
+		HeaderExpiresAt,
+		HeaderUploadSession,
+		HeaderMetaPrefix + "*",
+	}
+	// DefaultCORSExposedHeaders are the response headers set by the
+	// [Handler] that scripts cannot read otherwise.
+	DefaultCORSExposedHeaders = []string{
+		"Content-Disposition",
+		"Content-Range",
+		"ETag",
+		"Repr-Digest",
+		"Retry-After",
+		"WWW-Authenticate",
+		HeaderRequestID,
+		HeaderVersion,
+		HeaderMetaPrefix + "*",
+	}
+)
+
+// CORS allows scripts running on other origins to make requests, as
+// described by the Fetch standard.
+type CORS struct {
+	// AllowedOrigins are the origins, such as https://app.example, allowed
+	// to make requests. "*" allows every origin.
+	AllowedOrigins []string
+	AllowedMethods []string
+	// AllowedHeaders are the request headers scripts can send. An entry
+	// ending in "*" matches every header with its prefix.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts can read. An entry
+	// ending in "*" matches every header of the response with its prefix.
+	ExposedHeaders []string
+	// AllowCredentials lets scripts send cookies and the Authorization
+	// header, and read the response.
+	AllowCredentials bool
+	// MaxAge is how long browsers can cache the result of a preflight
+	// request.
+	MaxAge time.Duration
+}
+
+// NewCORS returns [CORS] allowing the origins to make requests with the
+// default methods and headers.
+func NewCORS(origins []string, opts ...func(*CORS)) *CORS {
+	c := &CORS{
+		AllowedOrigins: origins,
+		AllowedMethods: DefaultCORSMethods,
+		AllowedHeaders: DefaultCORSHeaders,
+		ExposedHeaders: DefaultCORSExposedHeaders,
+		MaxAge:         DefaultCORSMaxAge,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// CORSHandler answers preflight requests from the allowed origins of c and
+// marks the responses of h to them as readable. Preflight requests for
+// methods or headers that are not allowed are rejected with 403 Forbidden.
+// If c is nil, h is returned.
+func CORSHandler(c *CORS, h http.Handler) http.Handler {
+	if c == nil {
+		return h
+	}
+	var forbidden = func(s string) statusHandler {
+		return statusHandler{
+			code: http.StatusForbidden,
+			s:    s,
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		// caches must not serve one origin the response of another
+		if preflight {
+			w.Header().Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+		} else {
+			w.Header().Add("Vary", "Origin")
+		}
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !c.allowOrigin(origin) {
+			if preflight {
+				forbidden("origin is not allowed").ServeHTTP(w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if c.AllowCredentials || !slices.Contains(c.AllowedOrigins, "*") {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+		if c.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			h.ServeHTTP(&corsWriter{ResponseWriter: w, c: c}, r)
+			return
+		}
+
+		if method := r.Header.Get("Access-Control-Request-Method"); !slices.Contains(c.AllowedMethods, method) {
+			forbidden("method is not allowed").ServeHTTP(w, r)
+			return
+		}
+		var headers []string
+		for _, v := range r.Header.Values("Access-Control-Request-Headers") {
+			for _, k := range strings.Split(v, ",") {
+				if k = strings.TrimSpace(k); k == "" {
+					continue
+				}
+				if !matchHeader(c.AllowedHeaders, k) {
+					forbidden("header is not allowed").ServeHTTP(w, r)
+					return
+				}
+				headers = append(headers, k)
+			}
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+		if len(headers) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		}
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// allowOrigin reports whether the origin can make requests.
+func (c *CORS) allowOrigin(origin string) bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHeader reports whether the header matches one of the patterns.
+func matchHeader(patterns []string, k string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok && len(k) >= len(prefix) && strings.EqualFold(k[:len(prefix)], prefix) {
+			return true
+		}
+		if strings.EqualFold(p, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsWriter exposes the headers of the response once they are written, so
+// that patterns can match the headers set by the handler.
+type corsWriter struct {
+	http.ResponseWriter
+	c           *CORS
+	wroteHeader bool
+}
+
+func (w *corsWriter) expose() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	var exposed []string
+	for _, p := range w.c.ExposedHeaders {
+		if !strings.HasSuffix(p, "*") {
+			exposed = append(exposed, p)
+		}
+	}
+	for k := range w.Header() {
+		if matchHeader(w.c.ExposedHeaders, k) && !slices.ContainsFunc(exposed, func(e string) bool { return strings.EqualFold(e, k) }) {
+			exposed = append(exposed, k)
+		}
+	}
+	if len(exposed) > 0 {
+		slices.Sort(exposed)
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+	}
+}
+
+func (w *corsWriter) WriteHeader(code int) {
+	w.expose()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *corsWriter) Write(p []byte) (int, error) {
+	w.expose()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *corsWriter) Flush() {
+	_ = w.FlushError()
+}
+
+// FlushError is called by [http.ResponseController].
+func (w *corsWriter) FlushError() error {
+	w.expose()
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap is called by [http.ResponseController].
+func (w *corsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,79 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_CORSHandler(t *testing.T) {
+	header := func(kv ...string) func(*http.Request) {
+		return func(r *http.Request) {
+			for i := 0; i < len(kv); i += 2 {
+				r.Header.Set(kv[i], kv[i+1])
+			}
+		}
+	}
+
+	t.Run("Preflight", func(t *testing.T) {
+		cors := NewCORS([]string{"https://app.example"}, func(c *CORS) { c.AllowCredentials = true })
+		c, ctx := newTestClient(t, Handler(nil, func(o *Options) { o.CORS = cors })), context.Background()
+
+		for _, tc := range []struct {
+			name string
+			opt  func(*http.Request)
+			code int
+		}{
+			{"OK", header("Origin", "https://app.example", "Access-Control-Request-Method", "PUT", "Access-Control-Request-Headers", "authorization, x-blob-meta-project"), http.StatusNoContent},
+			{"ErrOrigin", header("Origin", "https://evil.example", "Access-Control-Request-Method", "PUT"), http.StatusForbidden},
+			{"ErrMethod", header("Origin", "https://app.example", "Access-Control-Request-Method", "TRACE"), http.StatusForbidden},
+			{"ErrHeader", header("Origin", "https://app.example", "Access-Control-Request-Method", "PUT", "Access-Control-Request-Headers", "x-other"), http.StatusForbidden},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				res, err := c.Do(ctx, "OPTIONS /cloud-storage/files/invalid", nil, tc.opt, acceptAll)
+				is.OK(t, err) // return preflight response
+				is.Equal(t, res.StatusCode, tc.code)
+				is.True(t, strings.Contains(res.Header.Get("Vary"), "Access-Control-Request-Headers"))
+				if tc.code == http.StatusNoContent {
+					is.Equal(t, res.Header.Get("Access-Control-Allow-Origin"), "https://app.example")
+					is.Equal(t, res.Header.Get("Access-Control-Allow-Credentials"), "true")
+					is.Equal(t, res.Header.Get("Access-Control-Allow-Headers"), "authorization, x-blob-meta-project")
+					is.Equal(t, res.Header.Get("Access-Control-Max-Age"), "300")
+				}
+				is.OK(t, res.Body.Close())
+			})
+		}
+	})
+
+	t.Run("Expose", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		h := Handler(up, func(o *Options) { o.Metadata, o.CORS = md, NewCORS([]string{"*"}) })
+		c, ctx := newTestClient(t, h), context.Background()
+
+		res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt", header(HeaderMetaPrefix+"Project", "apollo"))
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		is.Equal(t, res.Header.Get("Access-Control-Allow-Origin"), "")
+		var completed struct {
+			ID string `json:"resourceId"`
+		}
+		is.OK(t, json.NewDecoder(res.Body).Decode(&completed)) // decode upload
+		is.OK(t, res.Body.Close())
+
+		res, err = c.Do(ctx, "GET /cloud-storage/files/"+completed.ID, nil, header("Origin", "https://app.example"), acceptAll)
+		is.OK(t, err) // return download response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		is.Equal(t, res.Header.Get("Access-Control-Allow-Origin"), "*")
+		is.True(t, strings.Contains(res.Header.Get("Vary"), "Origin"))
+		exposed := res.Header.Get("Access-Control-Expose-Headers")
+		for _, k := range []string{"Content-Disposition", "ETag", HeaderVersion, HeaderMetaPrefix + "Project"} {
+			is.True(t, strings.Contains(exposed, k)) // exposed header
+		}
+		is.OK(t, res.Body.Close())
+	})
+}
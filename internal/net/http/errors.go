@@ -1,9 +1,14 @@
 package http
 
 import (
+	"encoding/json"
 	"fmt"
+	"html"
 	"io"
+	"log/slog"
 	"net/http"
+
+	"go.adoublef/blob/internal/log"
 )
 
 type statusHandler struct {
@@ -15,7 +20,11 @@ func (sh statusHandler) Err() error {
 	if sh.code < 400 {
 		return nil
 	}
-	return fmt.Errorf("%d %s: %s", sh.code, sh.StatusText(), sh.s[:20])
+	s := sh.s
+	if len(s) > 20 {
+		s = s[:20]
+	}
+	return fmt.Errorf("%d %s: %s", sh.code, sh.StatusText(), s)
 }
 
 func (sh statusHandler) StatusText() string {
@@ -26,7 +35,7 @@ func (sh statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// carry [context.Context] throughout the lifetime of this handler?
 	if err := sh.Err(); err != nil {
 		// log the error if it is
-		http.Error(w, sh.StatusText(), sh.code)
+		writeError(w, r, sh.code, sh.StatusText())
 		return
 	}
 	// also handle redirects if applicable
@@ -41,6 +50,42 @@ func (sh statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func Error(w http.ResponseWriter, r *http.Request, err error) {
+	ctx := r.Context()
+	log.FromContext(ctx).ErrorContext(ctx, "failed to complete request", slog.Any("err", err))
 	s := "The server was unable to complete your request. Please try again later."
-	http.Error(w, s, http.StatusInternalServerError)
+	writeError(w, r, http.StatusInternalServerError, s)
+}
+
+// writeError replies with the message in the negotiated content type, or as
+// plain text if none has been negotiated. The request id is included so it
+// can be quoted when reporting the failure.
+func writeError(w http.ResponseWriter, r *http.Request, code int, s string) {
+	ctx := r.Context()
+	id, _ := RequestID(ctx)
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("X-Content-Type-Options", "nosniff")
+	switch accept, _ := value[string](ctx, ContentTypOfferKey); accept {
+	case ContentTypJSON:
+		type errorBody struct {
+			Status    int    `json:"status"`
+			Error     string `json:"error"`
+			RequestID string `json:"requestId,omitempty"`
+		}
+		h.Set("Content-Type", ContentTypJSON)
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(errorBody{code, s, id})
+	case ContentTypHTML:
+		h.Set("Content-Type", ContentTypHTML+"; charset=utf-8")
+		w.WriteHeader(code)
+		fmt.Fprintf(w, "<!doctype html>\n<title>%d %s</title>\n<p>%s</p>\n", code, http.StatusText(code), html.EscapeString(s))
+		if id != "" {
+			fmt.Fprintf(w, "<p>request id: %s</p>\n", html.EscapeString(id))
+		}
+	default:
+		if id != "" {
+			s += "\nrequest id: " + id
+		}
+		http.Error(w, s, code)
+	}
 }
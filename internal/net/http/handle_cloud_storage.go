@@ -1,26 +1,42 @@
 package http
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
-	"go.adoublef/blob/internal/runtime/debug"
+	"go.adoublef/blob/internal/database/sql"
+	"go.adoublef/blob/internal/log"
 )
 
 type Uploader[K fmt.Stringer] interface {
-	Upload(ctx context.Context, r io.Reader) (id K, sz int64, err error)
+	Upload(ctx context.Context, r io.Reader, size int64) (id K, sz int64, err error)
 }
 
-func handleUploadCloudStorage[V fmt.Stringer](up Uploader[V]) http.HandlerFunc {
+// handleUploadCloudStorage stores the first file of a form as a new blob,
+// rejecting files larger than maxSize if it is positive.
+func handleUploadCloudStorage(up UpDownloader[uuid.UUID], md Metadata, m *Metrics, maxSize int64, q quotas) http.HandlerFunc {
 	var unsupportedMediaType = statusHandler{
 		code: http.StatusUnsupportedMediaType,
 		s:    `request is not a mulitpart/form`,
 	}
+	var tooLarge = statusHandler{
+		code: http.StatusRequestEntityTooLarge,
+		s:    fmt.Sprintf("file is larger than %d bytes", maxSize),
+	}
 
 	var unprocessableEntity = func(format string, v ...any) statusHandler {
 		return statusHandler{
@@ -30,29 +46,101 @@ func handleUploadCloudStorage[V fmt.Stringer](up Uploader[V]) http.HandlerFunc {
 	}
 
 	type completed struct {
-		ID      string `json:"resourceId"`
-		Size    int64  `json:"bytesWritten"`
-		Elapsed string `json:"timeElapsed"`
+		ID        string     `json:"resourceId"`
+		Size      int64      `json:"bytesWritten"`
+		Elapsed   string     `json:"timeElapsed"`
+		ExpiresAt *time.Time `json:"expiresAt,omitempty"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		start := time.Now()
 
+		hint, ok := sizeHint(r, maxSize)
+		if !ok {
+			tooLarge.ServeHTTP(w, r)
+			return
+		}
+		_, span := startSpan(ctx, "multipart.NextPart")
 		mr, err := r.MultipartReader()
 		if err != nil {
+			endSpan(span, err)
 			unsupportedMediaType.ServeHTTP(w, r)
 			return
 		}
-		part, err := mr.NextPart()
+		part, fields, err := nextFilePart(mr)
+		endSpan(span, err)
 		if err != nil {
 			unprocessableEntity("failed to decode part: %v", err).ServeHTTP(w, r)
 			return
 		}
 		defer part.Close()
+		tags := formTags(fields, headerTags(r.Header))
+		if err := validateTags(tags); err != nil {
+			unprocessableEntity("%v", err).ServeHTTP(w, r)
+			return
+		}
+		expires, err := parseExpiry(
+			cmp.Or(r.Header.Get(HeaderExpiresIn), fields["expires-in"]),
+			cmp.Or(r.Header.Get(HeaderExpiresAt), fields["expires-at"]),
+			start,
+		)
+		if err != nil {
+			unprocessableEntity("%v", err).ServeHTTP(w, r)
+			return
+		}
+		if (len(tags) > 0 || !expires.IsZero()) && md == nil {
+			unprocessableEntity("metadata is not recorded").ServeHTTP(w, r)
+			return
+		}
 		// validate filename/formname
 		filename := part.FileName()
-		debug.Printf("%q := part.FileName()", filename)
-		id, sz, err := up.Upload(ctx, part)
+		log.FromContext(ctx).DebugContext(ctx, "decoded part", slog.String("filename", filename))
+		contentType := part.Header.Get("Content-Type")
+		// the request body is larger than the part so this errs on rejecting
+		left, err := q.admit(ctx, ownerOf(ctx), 1, r.ContentLength)
+		if errors.Is(err, errQuotaExceeded) {
+			insufficientStorage(err).ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		uctx, span := startSpan(ctx, "Upload", blobTypeKey.String(contentType))
+		done := m.transfer(directionUp)
+		sum := sha256.New()
+		id, sz, err := up.Upload(uctx, io.TeeReader(limitQuota(limitFile(w, part, maxSize), left), sum), hint)
+		done(sz)
+		if err == nil && md != nil {
+			err = createBlob(uctx, up, md, q, sql.Blob{
+				ID:          id,
+				Filename:    filename,
+				ContentType: contentType,
+				Size:        sz,
+				SHA256:      hex.EncodeToString(sum.Sum(nil)),
+				CreatedAt:   time.Now(),
+				ExpiresAt:   expires,
+				Tags:        tags,
+			})
+		}
+		if err == nil {
+			span.SetAttributes(blobIDKey.String(id.String()), blobSizeKey.Int64(sz))
+			logAttrs(ctx, slog.String("blobId", id.String()), slog.Int64("blobSize", sz))
+		}
+		if p, ok := PrincipalOf(ctx); ok {
+			span.SetAttributes(blobOwnerKey.String(p.ID))
+		}
+		if mbe := (*http.MaxBytesError)(nil); errors.As(err, &mbe) {
+			endSpan(span, nil)
+			tooLarge.ServeHTTP(w, r)
+			return
+		}
+		if errors.Is(err, errQuotaExceeded) {
+			endSpan(span, nil)
+			insufficientStorage(err).ServeHTTP(w, r)
+			return
+		}
+		endSpan(span, err)
 		if err != nil {
 			// "failed to upload file: %v", err
 			Error(w, r, err)
@@ -64,21 +152,97 @@ func handleUploadCloudStorage[V fmt.Stringer](up Uploader[V]) http.HandlerFunc {
 			ID:      id.String(),
 			Size:    sz,
 			Elapsed: time.Since(start).String(),
+			// the file is deleted at this time
+			ExpiresAt: optionalTime(expires),
+		}
+		if err := json.NewEncoder(w).Encode(c); err != nil {
+			log.FromContext(ctx).WarnContext(ctx, "failed to write response", slog.Any("err", err))
 		}
-		err = json.NewEncoder(w).Encode(c)
-		debug.Printf(`%v = json.NewEncoder(w).Encode(%#v)`, err, c)
 	}
 }
 
+// nextFilePart returns the first file of the form along with the fields
+// before it.
+func nextFilePart(mr *multipart.Reader) (*multipart.Part, map[string]string, error) {
+	fields := make(map[string]string)
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, nil, err
+		}
+		if part.FileName() != "" {
+			return part, fields, nil
+		}
+		name := part.FormName()
+		if len(fields) == maxFormFields {
+			part.Close()
+			return nil, nil, fmt.Errorf("form has more than %d fields", maxFormFields)
+		}
+		// one more byte tells if the value is too long
+		p, err := io.ReadAll(io.LimitReader(part, MaxTagValue+1))
+		part.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(p) > MaxTagValue {
+			return nil, nil, fmt.Errorf("field %q is longer than %d bytes", name, MaxTagValue)
+		}
+		fields[name] = string(p)
+	}
+}
+
+// limitFile returns the file limited to maxSize bytes, unless maxSize is not
+// positive. Reading beyond the limit fails with [http.MaxBytesError].
+func limitFile(w http.ResponseWriter, part io.ReadCloser, maxSize int64) io.Reader {
+	if maxSize <= 0 {
+		return part
+	}
+	return http.MaxBytesReader(w, part, maxSize)
+}
+
+// sizeHint returns the length of the request as a hint of the size of its
+// file, at most maxSize if it is positive. It reports false for requests too
+// long to hold a file of maxSize bytes, which are rejected before any part is
+// allocated.
+func sizeHint(r *http.Request, maxSize int64) (int64, bool) {
+	if maxSize <= 0 {
+		return r.ContentLength, true
+	}
+	// the fields and boundaries of the form are allowed for
+	if r.ContentLength > maxSize+DefaultMaxBytes {
+		return 0, false
+	}
+	return min(r.ContentLength, maxSize), true
+}
+
+// maxFormFields bounds the fields sent before the file of an upload.
+const maxFormFields = 2 * MaxTags
+
 type Downloader interface {
-	Download(ctx context.Context, id uuid.UUID) (rc io.ReadCloser, err error)
+	Download(ctx context.Context, id uuid.UUID) (rc io.ReadCloser, sz int64, etag string, err error)
 }
 
-func handleDownloadCloudStorage(d Downloader) http.HandlerFunc {
+// handleDownloadCloudStorage serves the current version of a blob, or the
+// one given by ?version=.
+func handleDownloadCloudStorage(d Versioner, md Metadata, m *Metrics) http.HandlerFunc {
 	var badPathValue = statusHandler{
 		code: http.StatusBadRequest,
 		s:    `path parameter has invalid format`,
 	}
+	var badQuery = func(err error) statusHandler {
+		return statusHandler{
+			code: http.StatusBadRequest,
+			s:    err.Error(),
+		}
+	}
+	var notFound = statusHandler{
+		code: http.StatusNotFound,
+		s:    `file does not exist`,
+	}
+	var gone = statusHandler{
+		code: http.StatusGone,
+		s:    `file has expired`,
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
@@ -90,12 +254,57 @@ func handleDownloadCloudStorage(d Downloader) http.HandlerFunc {
 			badPathValue.ServeHTTP(w, r)
 			return
 		}
-		rc, err := d.Download(ctx, id)
+		version, err := parseVersion(r)
 		if err != nil {
+			badQuery(err).ServeHTTP(w, r)
+			return
+		}
+		logAttrs(ctx, slog.String("blobId", id.String()))
+		ctx, span := startSpan(ctx, "Download", blobIDKey.String(id.String()))
+		// a blob without metadata is treated as missing until backfilled
+		b := sql.Blob{ContentType: "application/octet-stream"}
+		if md == nil && version > 0 {
+			// only the first version is known
+			endSpan(span, nil)
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		if md != nil {
+			if version > 0 {
+				b, err = md.BlobVersion(ctx, id, version)
+			} else {
+				b, err = md.Blob(ctx, id)
+			}
+			if errors.Is(err, fs.ErrNotExist) || (err == nil && b.Trashed()) {
+				endSpan(span, nil)
+				notFound.ServeHTTP(w, r)
+				return
+			}
+			if err != nil {
+				endSpan(span, err)
+				Error(w, r, err)
+				return
+			}
+			// until the reaper deletes it
+			if b.Expired(time.Now()) {
+				endSpan(span, nil)
+				gone.ServeHTTP(w, r)
+				return
+			}
+		}
+		rc, sz, etag, err := d.DownloadVersion(ctx, id, b.VersionID)
+		if errors.Is(err, fs.ErrNotExist) {
+			endSpan(span, nil)
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			endSpan(span, err)
 			Error(w, r, err)
 			return
 		}
 		defer rc.Close()
+		span.SetAttributes(blobSizeKey.Int64(sz), blobTypeKey.String(b.ContentType))
 
 		// return this to the user as attatchment or inline?
 		// serveContent Headers
@@ -105,7 +314,6 @@ func handleDownloadCloudStorage(d Downloader) http.HandlerFunc {
 		// 1. content-range (ordered?)
 		// 1. accept-ranges
 		// 1. content-encoding
-		// 1. content-length - w.Header().Set("Content-Length", strconv.FormatInt(sendSize, 10))
 		// check "HEAD"
 		// io.CopyN(w, sendContent, sendSize)
 		// if I serve a range should omit 'disposition'
@@ -114,8 +322,38 @@ func handleDownloadCloudStorage(d Downloader) http.HandlerFunc {
 		// norma encoding: Content-Disposition: attachment; filename="filename.jpg"
 		// special encoding (RFC 5987): Content-Disposition: attachment; filename*="filename.jpg"
 
-		if r.Method != http.MethodHead {
-			io.Copy(w, rc)
+		// the type is chosen by the uploader so must not be sniffed into one
+		// that renders, whatever the disposition
+		w.Header().Set("Content-Type", b.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Length", strconv.FormatInt(sz, 10))
+		if b.Filename != "" && w.Header().Get("Content-Disposition") == "" {
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": b.Filename}))
+		}
+		if d := reprDigest(b.SHA256); d != "" {
+			w.Header().Set("Repr-Digest", d)
+		}
+		for k, v := range b.Tags {
+			w.Header().Set(HeaderMetaPrefix+k, v)
+		}
+		if b.Version > 0 {
+			w.Header().Set(HeaderVersion, strconv.Itoa(b.Version))
+		}
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		if r.Method == http.MethodHead {
+			// closing rc stops the download
+			endSpan(span, nil)
+			return
+		}
+		done := m.transfer(directionDown)
+		n, err := io.CopyN(w, rc, sz)
+		done(n)
+		endSpan(span, err)
+		if err != nil {
+			// the status has been sent so the client sees a short body
+			log.FromContext(ctx).WarnContext(ctx, "download interrupted", slog.Int64("written", n), slog.Any("err", err))
 		}
 	}
 }
@@ -2,10 +2,14 @@ package http
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 const (
@@ -19,19 +23,117 @@ const (
 type UpDownloader[K fmt.Stringer] interface {
 	Uploader[K]
 	Downloader
+	Deleter
+	Versioner
 }
 
-func Handler(up UpDownloader[uuid.UUID]) http.Handler {
+// Options configures the [Handler].
+type Options struct {
+	// Health serves the readiness probe.
+	Health *Health
+	// Metrics instruments each route. It is not served by the handler as it
+	// covers every tenant.
+	Metrics *Metrics
+	// TracerProvider starts a span for each request.
+	TracerProvider trace.TracerProvider
+	// Logger is carried in the context of each request and writes the
+	// access log.
+	Logger *slog.Logger
+	// Authenticator, if set, is required of requests for files, which are
+	// then checked for the scope of the route.
+	Authenticator Authenticator
+	// URLSigner, if set, mints signed urls that download a file without
+	// other credentials.
+	URLSigner *URLSigner
+	// Presigner, if set, hands out requests that transfer files directly to
+	// and from the bucket.
+	Presigner Presigner
+	// MaxFileSize, if positive, is the size of the largest file that can be
+	// uploaded.
+	MaxFileSize int64
+	// Metadata, if set, records each uploaded file and is consulted before
+	// downloading and when listing files. It is required to keep versions
+	// of a file and to enforce quotas.
+	Metadata Metadata
+	// CORS, if set, allows scripts on other origins to make requests.
+	CORS *CORS
+	// Progress reports the progress of uploads to the callers subscribed
+	// to their session.
+	Progress *Progress
+	// Limiter, if set, throttles the requests and transfers of each client.
+	Limiter *Limiter
+	// Quota caps the storage of all files and UserQuota that of the files
+	// of each caller. Uploads that would exceed either are rejected with
+	// 507 Insufficient Storage.
+	Quota, UserQuota Quota
+}
+
+func Handler(up UpDownloader[uuid.UUID], opts ...func(*Options)) http.Handler {
+	o := Options{Health: NewHealth(), Progress: NewProgress(), TracerProvider: noop.NewTracerProvider(), Logger: slog.Default()}
+	for _, f := range opts {
+		f(&o)
+	}
+	if o.Metrics == nil {
+		o.Metrics = NewMetrics(prometheus.NewRegistry())
+	}
+
 	mux := http.NewServeMux()
+	instrument := func(pattern string, h http.Handler) http.Handler {
+		h = logHandler(pattern, h)
+		h = traceHandler(o.TracerProvider, pattern, h)
+		return o.Metrics.instrument(pattern, h)
+	}
 	handleFunc := func(pattern string, h http.Handler) {
-		mux.Handle(pattern, h)
+		mux.Handle(pattern, instrument(pattern, h))
+	}
+	// limited by address before authentication so credentials cannot be
+	// guessed freely, and after so callers are told apart by principal
+	protect := func(s Scope, h http.Handler) http.Handler {
+		h = o.Limiter.limit(h)
+		if o.Authenticator == nil {
+			return h
+		}
+		return LimitHandler(o.Limiter, AuthHandler(o.Authenticator, requireScope(s, h)))
 	}
-	handleFunc("GET /ready", statusHandler{code: 200})
+	q := quotas{md: o.Metadata, tenant: o.Quota, user: o.UserQuota}
+	handleFunc("GET /live", handleLive())
+	handleFunc("GET /ready", o.Health)
 
 	// use versioning in headers rather than paths?
-	handleFunc("POST /cloud-storage/files", handleUploadCloudStorage(up))
-	handleFunc("GET /cloud-storage/files/{file}", handleDownloadCloudStorage(up))
+	handleFunc("POST /cloud-storage/files", protect(ScopeFilesWrite, o.Limiter.transfer(o.Progress.track(handleUploadCloudStorage(up, o.Metadata, o.Metrics, o.MaxFileSize, q)))))
+	download := o.Limiter.transfer(handleDownloadCloudStorage(up, o.Metadata, o.Metrics))
+	if o.URLSigner == nil {
+		handleFunc("GET /cloud-storage/files/{file}", protect(ScopeFilesRead, download))
+	} else {
+		handleFunc("GET /cloud-storage/files/{file}", signedHandler(o.URLSigner, o.Limiter.limit(download), protect(ScopeFilesRead, download)))
+		handleFunc("POST /cloud-storage/files/{file}/signed-urls", protect(ScopeFilesRead, handleSignURL(o.URLSigner)))
+	}
+	if o.Metadata != nil {
+		handleFunc("GET /cloud-storage/files", protect(ScopeFilesRead, handleListCloudStorage(o.Metadata, false)))
+		handleFunc("PUT /cloud-storage/files/{file}", protect(ScopeFilesWrite, o.Limiter.transfer(o.Progress.track(handleOverwriteCloudStorage(up, o.Metadata, o.Metrics, o.MaxFileSize, q)))))
+		handleFunc("DELETE /cloud-storage/files/{file}", protect(ScopeFilesDelete, handleDeleteCloudStorage(o.Metadata)))
+		handleFunc("GET /cloud-storage/files/{file}/versions", protect(ScopeFilesRead, handleListVersions(o.Metadata)))
+		handleFunc("POST /cloud-storage/files/{file}/versions/{version}/restore", protect(ScopeFilesWrite, handleRestoreVersion(o.Metadata)))
+		handleFunc("GET /cloud-storage/files/{file}/metadata", protect(ScopeFilesRead, handleMetadata(o.Metadata)))
+		handleFunc("PUT /cloud-storage/files/{file}/metadata", protect(ScopeFilesWrite, handleMetadata(o.Metadata)))
+		handleFunc("PATCH /cloud-storage/files/{file}/metadata", protect(ScopeFilesWrite, handleMetadata(o.Metadata)))
+		handleFunc("GET /cloud-storage/usage", protect(ScopeFilesRead, handleUsage(q)))
+		handleFunc("GET /cloud-storage/trash", protect(ScopeFilesRead, handleListCloudStorage(o.Metadata, true)))
+		handleFunc("POST /cloud-storage/trash/{file}/restore", protect(ScopeFilesWrite, handleRestore(o.Metadata)))
+		handleFunc("DELETE /cloud-storage/trash/{file}", protect(ScopeFilesDelete, handlePurge(up, o.Metadata)))
+	}
+	if o.Presigner != nil {
+		handleFunc("POST /cloud-storage/uploads", protect(ScopeFilesWrite, handlePresignUpload(o.Presigner, o.MaxFileSize, q)))
+		handleFunc("POST /cloud-storage/uploads/{file}/parts", protect(ScopeFilesWrite, handlePresignParts(o.Presigner, o.MaxFileSize, q)))
+		handleFunc("POST /cloud-storage/uploads/{file}/complete", protect(ScopeFilesWrite, handleCompleteUpload(o.Presigner, up, o.Metadata, q)))
+		handleFunc("POST /cloud-storage/files/{file}/presigned-urls", protect(ScopeFilesRead, handlePresignDownload(o.Presigner, o.Metadata)))
+	}
 
-	h := AcceptHandler(mux)
-	return h
+	root := http.NewServeMux()
+	// event streams are neither json nor html, nor worth compressing
+	const events = "GET /cloud-storage/uploads/{session}/events"
+	root.Handle(events, instrument(events, protect(ScopeFilesWrite, handleUploadEvents(o.Progress))))
+	root.Handle("/", AcceptHandler(mux))
+	// preflight requests carry no credentials so are answered first
+	return loggerHandler(o.Logger, RequestIDHandler(CORSHandler(o.CORS, root)))
 }
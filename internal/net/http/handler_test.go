@@ -41,6 +41,17 @@ func Test_handleDownloadCloudStorage(t *testing.T) {
 		res, err = c.Do(ctx, "GET /cloud-storage/files/"+completed.ID.String(), nil, acceptAll)
 		is.OK(t, err) // return download response
 		is.Equal(t, res.StatusCode, http.StatusOK)
+		is.Equal(t, res.ContentLength, int64(len("hello, world!\n")))
+		is.True(t, res.Header.Get("ETag") != "")
+		is.OK(t, res.Body.Close())
+	})
+
+	t.Run("ErrNotFound", func(t *testing.T) {
+		c, ctx := newClient(t), context.Background()
+
+		res, err := c.Do(ctx, "GET /cloud-storage/files/"+uuid.NewString(), nil, acceptAll)
+		is.OK(t, err) // return download response
+		is.Equal(t, res.StatusCode, http.StatusNotFound)
 		is.OK(t, res.Body.Close())
 	})
 }
@@ -55,6 +66,16 @@ func Test_handleReady(t *testing.T) {
 	})
 }
 
+func Test_handleLive(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		c, ctx := newClient(t), context.Background()
+
+		res, err := c.Do(ctx, "GET /live", nil, acceptAll)
+		is.OK(t, err) // return echo response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+	})
+}
+
 func newClient(tb testing.TB) *TestClient {
 	tb.Helper()
 
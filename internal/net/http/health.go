@@ -0,0 +1,151 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.adoublef/blob/internal/log"
+)
+
+const (
+	DefaultCheckTTL     = 5 * time.Second
+	DefaultCheckTimeout = 2 * time.Second
+)
+
+// A Checker reports whether a dependency of the service is usable.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc is an adapter to allow the use of ordinary functions as a [Checker].
+type CheckerFunc func(ctx context.Context) error
+
+// Check calls f(ctx).
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Health serves the readiness of the service from the result of its checks.
+type Health struct {
+	// TTL is how long the result of a check is reused for.
+	TTL time.Duration
+	// Timeout is how long a check may run before it fails.
+	Timeout time.Duration
+
+	checks   []*check
+	shutdown atomic.Bool
+}
+
+// NewHealth returns a [Health] with no checks.
+func NewHealth() *Health {
+	return &Health{TTL: DefaultCheckTTL, Timeout: DefaultCheckTimeout}
+}
+
+// Add registers a named check.
+func (h *Health) Add(name string, c Checker) {
+	h.checks = append(h.checks, &check{name: name, c: c})
+}
+
+// Shutdown marks the service as not ready so traffic is routed elsewhere
+// while in-flight requests are drained.
+func (h *Health) Shutdown() {
+	h.shutdown.Store(true)
+}
+
+type checkResult struct {
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checkedAt"`
+	Elapsed string    `json:"timeElapsed"`
+}
+
+type check struct {
+	name string
+	c    Checker
+
+	mu  sync.Mutex
+	res checkResult
+	err error
+}
+
+// run returns the cached result or runs the check if it has expired.
+func (c *check) run(ctx context.Context, ttl, timeout time.Duration) (checkResult, error) {
+	// callers wait for a running check rather than starting another
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.res.Checked.IsZero() && time.Since(c.res.Checked) < ttl {
+		return c.res, c.err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	err := c.c.Check(ctx)
+	if err != nil {
+		log.FromContext(ctx).WarnContext(ctx, "check failed", slog.String("check", c.name), slog.Any("err", err))
+	}
+	c.res = checkResult{Status: "ok", Checked: start, Elapsed: time.Since(start).String()}
+	if c.err = err; err != nil {
+		c.res.Status, c.res.Error = "unavailable", err.Error()
+	}
+	return c.res, c.err
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	type ready struct {
+		Status string                 `json:"status"`
+		Checks map[string]checkResult `json:"checks,omitempty"`
+	}
+	w.Header().Set("Content-Type", ContentTypJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	if h.shutdown.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(ready{Status: "shutting down"})
+		return
+	}
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		res = ready{Status: "ok", Checks: make(map[string]checkResult, len(h.checks))}
+	)
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cr, err := c.run(context.WithoutCancel(ctx), h.TTL, h.Timeout)
+			mu.Lock()
+			defer mu.Unlock()
+			res.Checks[c.name] = cr
+			if err != nil {
+				res.Status = "unavailable"
+			}
+		}()
+	}
+	wg.Wait()
+
+	if res.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.FromContext(ctx).WarnContext(ctx, "failed to write response", slog.Any("err", err))
+	}
+}
+
+// handleLive reports that the process is able to serve requests.
+func handleLive() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentTypJSON)
+		w.Header().Set("Cache-Control", "no-store")
+		io.WriteString(w, `{"status":"ok"}`+"\n")
+	}
+}
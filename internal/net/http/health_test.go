@@ -0,0 +1,114 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Health(t *testing.T) {
+	type ready struct {
+		Status string `json:"status"`
+		Checks map[string]struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"checks"`
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		h := NewHealth()
+		h.Add("storage", CheckerFunc(func(ctx context.Context) error { return nil }))
+		c, ctx := newHealthClient(t, h), context.Background()
+
+		res, err := c.Do(ctx, "GET /ready", nil, acceptAll)
+		is.OK(t, err)
+		is.Equal(t, res.StatusCode, http.StatusOK)
+
+		var body ready
+		is.OK(t, json.NewDecoder(res.Body).Decode(&body))
+		is.OK(t, res.Body.Close())
+		is.Equal(t, body.Status, "ok")
+		is.Equal(t, body.Checks["storage"].Status, "ok")
+	})
+
+	t.Run("ErrCheck", func(t *testing.T) {
+		h := NewHealth()
+		h.Add("storage", CheckerFunc(func(ctx context.Context) error { return nil }))
+		h.Add("disk", CheckerFunc(func(ctx context.Context) error { return errors.New("disk full") }))
+		c, ctx := newHealthClient(t, h), context.Background()
+
+		res, err := c.Do(ctx, "GET /ready", nil, acceptAll)
+		is.OK(t, err)
+		is.Equal(t, res.StatusCode, http.StatusServiceUnavailable)
+
+		var body ready
+		is.OK(t, json.NewDecoder(res.Body).Decode(&body))
+		is.OK(t, res.Body.Close())
+		is.Equal(t, body.Status, "unavailable")
+		is.Equal(t, body.Checks["storage"].Status, "ok")
+		is.Equal(t, body.Checks["disk"].Error, "disk full")
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		h := NewHealth()
+		h.Timeout = 10 * time.Millisecond
+		h.Add("storage", CheckerFunc(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}))
+		c, ctx := newHealthClient(t, h), context.Background()
+
+		res, err := c.Do(ctx, "GET /ready", nil, acceptAll)
+		is.OK(t, err)
+		is.Equal(t, res.StatusCode, http.StatusServiceUnavailable)
+		is.OK(t, res.Body.Close())
+	})
+
+	t.Run("Cache", func(t *testing.T) {
+		var n atomic.Int64
+		h := NewHealth()
+		h.Add("storage", CheckerFunc(func(ctx context.Context) error {
+			n.Add(1)
+			return nil
+		}))
+		c, ctx := newHealthClient(t, h), context.Background()
+
+		for range 3 {
+			res, err := c.Do(ctx, "GET /ready", nil, acceptAll)
+			is.OK(t, err)
+			is.Equal(t, res.StatusCode, http.StatusOK)
+			is.OK(t, res.Body.Close())
+		}
+		is.Equal(t, n.Load(), int64(1)) // checked once within ttl
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		h := NewHealth()
+		c, ctx := newHealthClient(t, h), context.Background()
+
+		res, err := c.Do(ctx, "GET /ready", nil, acceptAll)
+		is.OK(t, err)
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		is.OK(t, res.Body.Close())
+
+		h.Shutdown()
+		res, err = c.Do(ctx, "GET /ready", nil, acceptAll)
+		is.OK(t, err)
+		is.Equal(t, res.StatusCode, http.StatusServiceUnavailable)
+		is.OK(t, res.Body.Close())
+	})
+}
+
+func newHealthClient(tb testing.TB, h *Health) *TestClient {
+	tb.Helper()
+	mux := http.NewServeMux()
+	mux.Handle("GET /ready", h)
+	return newTestClient(tb, AcceptHandler(mux))
+}
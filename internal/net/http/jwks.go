@@ -0,0 +1,204 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	ospkg "os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"go.adoublef/blob/internal/log"
+)
+
+const (
+	DefaultJWKSTTL        = time.Hour
+	DefaultJWKSMinRefresh = time.Minute
+	DefaultJWKSBackoff    = 10 * time.Second
+)
+
+// errUnknownKey is returned for a key id that is not in the set.
+var errUnknownKey = errors.New("unknown key")
+
+// JWKS is a JSON Web Key Set that is fetched when first used and refetched
+// once it expires, or when a token is signed by an unknown key so that keys
+// can be rotated by the issuer. Concurrent requests share a single fetch.
+type JWKS struct {
+	fetch func(ctx context.Context) (jose.JSONWebKeySet, time.Duration, error)
+	// TTL is how long the keys are used for if the source does not say.
+	TTL time.Duration
+	// MinRefresh is the minimum time between fetches caused by an unknown
+	// key, which bounds the fetches caused by forged tokens.
+	MinRefresh time.Duration
+	// Backoff is how long no fetch is made after one fails, during which
+	// the keys already fetched are used.
+	Backoff time.Duration
+
+	mu        sync.Mutex
+	set       jose.JSONWebKeySet
+	fetched   time.Time
+	expires   time.Time
+	attempted time.Time
+	backoff   time.Time
+	err       error
+	inflight  *jwksFetch
+}
+
+// jwksFetch is a fetch in progress, shared by the requests waiting on it.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewFileJWKS returns a [JWKS] read from a file.
+func NewFileJWKS(filename string, opts ...func(*JWKS)) *JWKS {
+	return newJWKS(func(ctx context.Context) (jose.JSONWebKeySet, time.Duration, error) {
+		f, err := ospkg.Open(filename)
+		if err != nil {
+			return jose.JSONWebKeySet{}, 0, err
+		}
+		defer f.Close()
+		set, err := readJWKS(f)
+		return set, 0, err
+	}, opts...)
+}
+
+// NewURLJWKS returns a [JWKS] fetched from a url, such as the jwks_uri of an
+// OpenID Connect provider. The keys are cached for the max-age of the
+// response if it has one.
+func NewURLJWKS(url string, c *http.Client, opts ...func(*JWKS)) *JWKS {
+	return newJWKS(func(ctx context.Context) (jose.JSONWebKeySet, time.Duration, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return jose.JSONWebKeySet{}, 0, err
+		}
+		req.Header.Set("Accept", ContentTypJSON)
+		res, err := c.Do(req)
+		if err != nil {
+			return jose.JSONWebKeySet{}, 0, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return jose.JSONWebKeySet{}, 0, fmt.Errorf("fetch %s: %s", url, res.Status)
+		}
+		set, err := readJWKS(io.LimitReader(res.Body, 1<<20))
+		return set, maxAge(res.Header.Get("Cache-Control")), err
+	}, opts...)
+}
+
+func newJWKS(fetch func(ctx context.Context) (jose.JSONWebKeySet, time.Duration, error), opts ...func(*JWKS)) *JWKS {
+	s := &JWKS{
+		fetch:      fetch,
+		TTL:        DefaultJWKSTTL,
+		MinRefresh: DefaultJWKSMinRefresh,
+		Backoff:    DefaultJWKSBackoff,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// Key returns the public key with the id.
+func (s *JWKS) Key(ctx context.Context, kid string) (any, error) {
+	s.mu.Lock()
+	now := time.Now()
+	_, ok := s.lookup(kid)
+	// fetch the keys for the first time, once they expire, or when the
+	// issuer may have rotated them
+	stale := s.fetched.IsZero() || now.After(s.expires) || (!ok && now.Sub(s.attempted) >= s.MinRefresh)
+	if stale && (now.After(s.backoff) || s.inflight != nil) {
+		f := s.inflight
+		if f == nil {
+			f = &jwksFetch{done: make(chan struct{})}
+			s.inflight, s.attempted = f, now
+			// shared by every waiting request, so not bound to this one
+			go s.refresh(context.WithoutCancel(ctx), f)
+		}
+		s.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		s.mu.Lock()
+	}
+	defer s.mu.Unlock()
+
+	key, ok := s.lookup(kid)
+	if !ok && s.fetched.IsZero() && s.err != nil {
+		return nil, s.err
+	}
+	if !ok {
+		return nil, errUnknownKey
+	}
+	// keep using the keys we have until the source is back
+	return key, nil
+}
+
+// refresh fetches the keys, backing off if it fails.
+func (s *JWKS) refresh(ctx context.Context, f *jwksFetch) {
+	defer close(f.done)
+	set, ttl, err := s.fetch(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.inflight, s.err, f.err = nil, err, err
+	if err != nil {
+		s.backoff = now.Add(s.Backoff)
+		log.FromContext(ctx).WarnContext(ctx, "failed to refresh jwks", slog.Any("err", err))
+		return
+	}
+	if ttl <= 0 {
+		ttl = s.TTL
+	}
+	s.set, s.fetched, s.expires = set, now, now.Add(ttl)
+}
+
+func (s *JWKS) lookup(kid string) (any, bool) {
+	for _, k := range s.set.Key(kid) {
+		if k.Use == "" || k.Use == "sig" {
+			return k.Key, true
+		}
+	}
+	return nil, false
+}
+
+// readJWKS decodes a key set keeping only its public keys.
+func readJWKS(r io.Reader) (jose.JSONWebKeySet, error) {
+	var set jose.JSONWebKeySet
+	if err := json.NewDecoder(r).Decode(&set); err != nil {
+		return set, fmt.Errorf("failed to decode jwks: %w", err)
+	}
+	keys := set.Keys[:0]
+	for _, k := range set.Keys {
+		// symmetric keys have no public part
+		if pk := k.Public(); k.Valid() && pk.Key != nil {
+			keys = append(keys, pk)
+		}
+	}
+	set.Keys = keys
+	return set, nil
+}
+
+// maxAge returns the max-age directive of a Cache-Control header.
+func maxAge(cc string) time.Duration {
+	for _, d := range strings.Split(cc, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(d), "=")
+		if !strings.EqualFold(k, "max-age") {
+			continue
+		}
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return 0
+}
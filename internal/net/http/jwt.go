@@ -0,0 +1,124 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const (
+	DefaultJWTLeeway     = time.Minute
+	DefaultJWTScopeClaim = "scope"
+)
+
+// jwtAlgorithms are the signature algorithms a token may be signed with.
+var jwtAlgorithms = []jose.SignatureAlgorithm{jose.RS256, jose.ES256, jose.EdDSA}
+
+// A KeySet returns the public key a token was signed with by its key id.
+type KeySet interface {
+	Key(ctx context.Context, kid string) (any, error)
+}
+
+// JWT authenticates callers by JSON Web Tokens. The subject of the token is
+// the id of the [Principal].
+type JWT struct {
+	keys KeySet
+	// Issuer, if set, must match the iss claim.
+	Issuer string
+	// Audience, if set, must be one of the aud claim.
+	Audience string
+	// Leeway is the allowed clock skew when checking the time claims.
+	Leeway time.Duration
+	// ScopeClaim is the claim holding the scopes of the caller, either as a
+	// space separated string or as an array of strings.
+	ScopeClaim string
+	// ScopeMap, if set, maps each value of the scope claim to the scopes it
+	// grants, such as a group to the scopes of its members. Values missing
+	// from the map grant nothing. Otherwise values are used as scopes.
+	ScopeMap map[string][]Scope
+	// TenantClaim, if set, is the claim holding the tenant of the caller.
+	TenantClaim string
+}
+
+// NewJWT returns a [JWT] verifying tokens with keys.
+func NewJWT(keys KeySet, opts ...func(*JWT)) *JWT {
+	j := &JWT{
+		keys:       keys,
+		Leeway:     DefaultJWTLeeway,
+		ScopeClaim: DefaultJWTScopeClaim,
+	}
+	for _, o := range opts {
+		o(j)
+	}
+	return j
+}
+
+// Authenticate implements [Authenticator].
+func (j *JWT) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	tok, err := jwt.ParseSigned(token, jwtAlgorithms)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if len(tok.Headers) != 1 {
+		return nil, fmt.Errorf("%w: token must have one signature", ErrUnauthenticated)
+	}
+	key, err := j.keys.Key(ctx, tok.Headers[0].KeyID)
+	if errors.Is(err, errUnknownKey) {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		claims jwt.Claims
+		extra  map[string]any
+	)
+	if err := tok.Claims(key, &claims, &extra); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	exp := jwt.Expected{Issuer: j.Issuer, Time: time.Now()}
+	if j.Audience != "" {
+		exp.AnyAudience = jwt.Audience{j.Audience}
+	}
+	if err := claims.ValidateWithLeeway(exp, j.Leeway); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if claims.Expiry == nil || claims.Subject == "" {
+		return nil, fmt.Errorf("%w: token must have exp and sub claims", ErrUnauthenticated)
+	}
+	p := &Principal{ID: claims.Subject, Scopes: j.scopes(extra[j.ScopeClaim])}
+	if j.TenantClaim != "" {
+		p.Tenant, _ = extra[j.TenantClaim].(string)
+	}
+	return p, nil
+}
+
+// scopes returns the scopes granted by the value of the scope claim.
+func (j *JWT) scopes(v any) []Scope {
+	var values []string
+	switch v := v.(type) {
+	case string:
+		values = strings.Fields(v)
+	case []any:
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	var scopes []Scope
+	for _, s := range values {
+		if j.ScopeMap == nil {
+			scopes = append(scopes, Scope(s))
+			continue
+		}
+		scopes = append(scopes, j.ScopeMap[s]...)
+	}
+	return scopes
+}
@@ -0,0 +1,191 @@
+package http_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_JWT(t *testing.T) {
+	const (
+		issuer   = "https://issuer.example"
+		audience = "blob"
+	)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	is.OK(t, err) // generate rsa key
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	is.OK(t, err) // generate ecdsa key
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	is.OK(t, err) // generate ed25519 key
+
+	claims := func(f func(*jwt.Claims, map[string]any)) (jwt.Claims, map[string]any) {
+		now := time.Now()
+		c := jwt.Claims{
+			Issuer:   issuer,
+			Subject:  "user-1",
+			Audience: jwt.Audience{audience},
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+		}
+		extra := map[string]any{"scope": "files:read files:write"}
+		if f != nil {
+			f(&c, extra)
+		}
+		return c, extra
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		for _, tc := range []struct {
+			alg jose.SignatureAlgorithm
+			key crypto.Signer
+		}{
+			{jose.RS256, rsaKey},
+			{jose.ES256, ecKey},
+			{jose.EdDSA, edKey},
+		} {
+			t.Run(string(tc.alg), func(t *testing.T) {
+				ks := newTestKeyServer(t, jose.JSONWebKey{Key: tc.key.Public(), KeyID: "k1", Algorithm: string(tc.alg), Use: "sig"})
+				j := NewJWT(NewURLJWKS(ks.URL, ks.Client()), func(j *JWT) { j.Issuer, j.Audience = issuer, audience })
+
+				p, err := j.Authenticate(context.Background(), sign(t, tc.alg, tc.key, "k1")(claims(nil)))
+				is.OK(t, err) // authenticate token
+				is.Equal(t, p.ID, "user-1")
+				is.True(t, p.Can(ScopeFilesRead))
+				is.True(t, p.Can(ScopeFilesWrite))
+			})
+		}
+	})
+
+	t.Run("ScopeMap", func(t *testing.T) {
+		ks := newTestKeyServer(t, jose.JSONWebKey{Key: ecKey.Public(), KeyID: "k1", Use: "sig"})
+		j := NewJWT(NewURLJWKS(ks.URL, ks.Client()), func(j *JWT) {
+			j.ScopeClaim = "groups"
+			j.ScopeMap = map[string][]Scope{"readers": {ScopeFilesRead}}
+		})
+
+		p, err := j.Authenticate(context.Background(), sign(t, jose.ES256, ecKey, "k1")(claims(func(_ *jwt.Claims, m map[string]any) {
+			m["groups"] = []string{"readers", "others"}
+		})))
+		is.OK(t, err) // authenticate token
+		is.True(t, p.Can(ScopeFilesRead))
+		is.True(t, !p.Can(ScopeFilesWrite))
+	})
+
+	t.Run("Err", func(t *testing.T) {
+		ks := newTestKeyServer(t, jose.JSONWebKey{Key: ecKey.Public(), KeyID: "k1", Use: "sig"})
+		j := NewJWT(NewURLJWKS(ks.URL, ks.Client()), func(j *JWT) { j.Issuer, j.Audience = issuer, audience })
+		es256 := sign(t, jose.ES256, ecKey, "k1")
+
+		for name, token := range map[string]string{
+			"Issuer":   es256(claims(func(c *jwt.Claims, _ map[string]any) { c.Issuer = "https://other.example" })),
+			"Audience": es256(claims(func(c *jwt.Claims, _ map[string]any) { c.Audience = jwt.Audience{"other"} })),
+			"Expired":  es256(claims(func(c *jwt.Claims, _ map[string]any) { c.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour)) })),
+			"NoExpiry": es256(claims(func(c *jwt.Claims, _ map[string]any) { c.Expiry = nil })),
+			"Subject":  es256(claims(func(c *jwt.Claims, _ map[string]any) { c.Subject = "" })),
+			"Key":      sign(t, jose.ES256, ecKey, "k2")(claims(nil)),
+			"Signer":   sign(t, jose.RS256, rsaKey, "k1")(claims(nil)),
+			"HS256":    sign(t, jose.HS256, []byte("0123456789abcdef0123456789abcdef"), "k1")(claims(nil)),
+			"Garbage":  "not.a.token",
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := j.Authenticate(context.Background(), token)
+				is.NotOK(t, err, ErrUnauthenticated)
+			})
+		}
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		ks := newTestKeyServer(t, jose.JSONWebKey{Key: ecKey.Public(), KeyID: "k1", Use: "sig"})
+		j := NewJWT(NewURLJWKS(ks.URL, ks.Client(), func(s *JWKS) { s.MinRefresh = 0 }))
+
+		for range 2 {
+			_, err := j.Authenticate(context.Background(), sign(t, jose.ES256, ecKey, "k1")(claims(nil)))
+			is.OK(t, err) // authenticate with cached keys
+		}
+		is.Equal(t, ks.fetches.Load(), int64(1))
+
+		ks.set(jose.JSONWebKey{Key: edKey.Public(), KeyID: "k2", Use: "sig"})
+		_, err := j.Authenticate(context.Background(), sign(t, jose.EdDSA, edKey, "k2")(claims(nil)))
+		is.OK(t, err) // authenticate with rotated key
+		is.Equal(t, ks.fetches.Load(), int64(2))
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		ks := newTestKeyServer(t, jose.JSONWebKey{Key: ecKey.Public(), KeyID: "k1", Use: "sig"})
+		j := NewJWT(NewURLJWKS(ks.URL, ks.Client(), func(s *JWKS) { s.TTL, s.MinRefresh, s.Backoff = time.Nanosecond, 0, time.Hour }))
+
+		_, err := j.Authenticate(context.Background(), sign(t, jose.ES256, ecKey, "k1")(claims(nil)))
+		is.OK(t, err) // authenticate with fetched keys
+		ks.down.Store(true)
+		for _, kid := range []string{"k1", "k1", "k2", "k3"} {
+			_, err := j.Authenticate(context.Background(), sign(t, jose.ES256, ecKey, kid)(claims(nil)))
+			if kid == "k1" {
+				is.OK(t, err) // authenticate with expired keys while the source is down
+			}
+		}
+		is.Equal(t, ks.fetches.Load(), int64(2)) // backed off after the failure
+	})
+}
+
+type testKeyServer struct {
+	*httptest.Server
+	fetches atomic.Int64
+	// down, if set, fails every fetch
+	down atomic.Bool
+
+	mu   sync.Mutex
+	keys jose.JSONWebKeySet
+}
+
+func (ks *testKeyServer) set(keys ...jose.JSONWebKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = jose.JSONWebKeySet{Keys: keys}
+}
+
+func newTestKeyServer(tb testing.TB, keys ...jose.JSONWebKey) *testKeyServer {
+	tb.Helper()
+	ks := &testKeyServer{}
+	ks.set(keys...)
+	ks.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ks.fetches.Add(1)
+		if ks.down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		w.Header().Set("Content-Type", ContentTypJSON)
+		json.NewEncoder(w).Encode(ks.keys)
+	}))
+	tb.Cleanup(ks.Close)
+	return ks
+}
+
+// sign returns a function signing claims with the key.
+func sign(tb testing.TB, alg jose.SignatureAlgorithm, key any, kid string) func(jwt.Claims, map[string]any) string {
+	tb.Helper()
+	s, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", kid))
+	is.OK(tb, err) // return signer
+	return func(c jwt.Claims, extra map[string]any) string {
+		token, err := jwt.Signed(s).Claims(c).Claims(extra).Serialize()
+		is.OK(tb, err) // sign token
+		return token
+	}
+}
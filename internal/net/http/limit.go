@@ -0,0 +1,295 @@
+package http
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	DefaultLimiterIdle = 10 * time.Minute
+	// minBandwidthBurst is the smallest number of bytes read or written at
+	// once when the bandwidth is limited.
+	minBandwidthBurst = 32 << 10
+)
+
+// Limiter throttles each client, identified by its principal or otherwise by
+// its IP address within its tenant, as well as the bandwidth of all
+// transfers. Each IP address is also throttled before it is authenticated, so
+// that credentials cannot be guessed without limit. Requests over a limit are
+// rejected with 429 Too Many Requests and a Retry-After header.
+//
+// A Limiter can be shared by the handlers of several tenants.
+type Limiter struct {
+	// Rate, if positive, is the number of requests per second each client
+	// can make, in bursts of up to Burst requests.
+	Rate  rate.Limit
+	Burst int
+	// AddrRate is the number of requests per second each IP address can
+	// make before it is authenticated, in bursts of up to AddrBurst
+	// requests. It defaults to Rate.
+	AddrRate  rate.Limit
+	AddrBurst int
+	// MaxTransfers, if positive, is the number of uploads and downloads each
+	// client can have in progress.
+	MaxTransfers int
+	// Bandwidth, if positive, is the number of bytes per second shared by
+	// all uploads and downloads.
+	Bandwidth int64
+	// Idle is how long a client is remembered after its last request.
+	Idle time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*client
+	swept   time.Time
+	bw      *rate.Limiter
+
+	limited   *prometheus.CounterVec
+	tracked   prometheus.Gauge
+	throttled prometheus.Counter
+}
+
+type client struct {
+	rate      *rate.Limiter
+	transfers int
+	seen      time.Time
+}
+
+// NewLimiter returns a [Limiter] registered with reg. Burst and AddrBurst
+// default to their rate, rounded up.
+func NewLimiter(reg *prometheus.Registry, opts ...func(*Limiter)) *Limiter {
+	l := &Limiter{
+		Idle:    DefaultLimiterIdle,
+		clients: make(map[string]*client),
+		limited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "blob",
+			Subsystem: "http",
+			Name:      "limited_requests_total",
+			Help:      "Requests rejected by the limiter by reason.",
+		}, []string{"reason"}),
+		tracked: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "blob",
+			Name:      "limiter_clients",
+			Help:      "Clients remembered by the limiter.",
+		}),
+		throttled: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "blob",
+			Name:      "bandwidth_throttled_seconds_total",
+			Help:      "Time transfers waited for bandwidth.",
+		}),
+	}
+	for _, o := range opts {
+		o(l)
+	}
+	if l.AddrRate == 0 {
+		l.AddrRate = l.Rate
+	}
+	if l.Burst <= 0 {
+		l.Burst = max(int(math.Ceil(float64(l.Rate))), 1)
+	}
+	if l.AddrBurst <= 0 {
+		l.AddrBurst = max(int(math.Ceil(float64(l.AddrRate))), 1)
+	}
+	if l.Bandwidth > 0 {
+		l.bw = rate.NewLimiter(rate.Limit(l.Bandwidth), int(max(l.Bandwidth, minBandwidthBurst)))
+	}
+	reg.MustRegister(l.limited, l.tracked, l.throttled)
+	return l
+}
+
+// addrLimitedKey marks a request already limited by its IP address.
+var addrLimitedKey = &contextKey{"addr-limited"}
+
+// LimitHandler rejects the requests of IP addresses over the AddrRate of l
+// before h authenticates them. A request is only limited once however many
+// handlers it passes through. If l is nil, h is returned.
+func LimitHandler(l *Limiter, h http.Handler) http.Handler {
+	if l == nil || l.AddrRate <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if ctx.Value(addrLimitedKey) != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		l.mu.Lock()
+		// the tenant is not known before authentication
+		c := l.client("addr:"+clientIP(r), l.AddrRate, l.AddrBurst)
+		l.mu.Unlock()
+		if !l.allow(w, r, c, "addr") {
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(ctx, addrLimitedKey, true)))
+	})
+}
+
+// limit rejects the requests of clients over their rate.
+func (l *Limiter) limit(h http.Handler) http.Handler {
+	if l == nil || l.Rate <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.mu.Lock()
+		c := l.client(clientKey(r), l.Rate, l.Burst)
+		l.mu.Unlock()
+		if l.allow(w, r, c, "rate") {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// allow reports whether the client is within its rate, otherwise rejecting
+// the request.
+func (l *Limiter) allow(w http.ResponseWriter, r *http.Request, c *client, reason string) bool {
+	res := c.rate.Reserve()
+	if d := res.Delay(); d > 0 {
+		res.Cancel()
+		l.reject(w, r, reason, "too many requests", d)
+		return false
+	}
+	return true
+}
+
+// transfer rejects the transfers of clients with too many in progress and
+// throttles the content to the bandwidth.
+func (l *Limiter) transfer(h http.Handler) http.Handler {
+	if l == nil || (l.MaxTransfers <= 0 && l.bw == nil) {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.MaxTransfers > 0 {
+			l.mu.Lock()
+			c := l.client(clientKey(r), l.Rate, l.Burst)
+			ok := c.transfers < l.MaxTransfers
+			if ok {
+				c.transfers++
+			}
+			l.mu.Unlock()
+			if !ok {
+				// a transfer may take any time to finish
+				l.reject(w, r, "transfers", "too many transfers in progress", time.Second)
+				return
+			}
+			defer func() {
+				l.mu.Lock()
+				c.transfers--
+				l.mu.Unlock()
+			}()
+		}
+		if l.bw != nil {
+			ctx := r.Context()
+			r.Body = &throttledReader{r.Body, ctx, l}
+			w = &throttledWriter{w, ctx, l}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// client returns the state of the client with the key, limited to the rate
+// if it is positive, forgetting those that have been idle. It must be called
+// with the lock held.
+func (l *Limiter) client(key string, limit rate.Limit, burst int) *client {
+	now := time.Now()
+	if now.Sub(l.swept) > l.Idle {
+		for k, c := range l.clients {
+			if c.transfers == 0 && now.Sub(c.seen) > l.Idle {
+				delete(l.clients, k)
+			}
+		}
+		l.swept = now
+	}
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{}
+		if limit > 0 {
+			c.rate = rate.NewLimiter(limit, burst)
+		}
+		l.clients[key] = c
+	}
+	c.seen = now
+	l.tracked.Set(float64(len(l.clients)))
+	return c
+}
+
+// clientKey returns the key of the client making an authenticated request.
+func clientKey(r *http.Request) string {
+	ctx := r.Context()
+	// principals and addresses cannot be confused
+	key := "ip:" + clientIP(r)
+	if p, ok := PrincipalOf(ctx); ok {
+		key = "principal:" + p.ID
+	}
+	if tenant, ok := TenantOf(ctx); ok {
+		key = tenant + "/" + key
+	}
+	return key
+}
+
+// reject responds with 429 Too Many Requests, asking to retry after d.
+func (l *Limiter) reject(w http.ResponseWriter, r *http.Request, reason, s string, d time.Duration) {
+	l.limited.WithLabelValues(reason).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(d.Seconds())), 1)))
+	statusHandler{code: http.StatusTooManyRequests, s: s}.ServeHTTP(w, r)
+}
+
+// wait blocks until n bytes of bandwidth are available.
+func (l *Limiter) wait(ctx context.Context, n int) error {
+	start := time.Now()
+	err := l.bw.WaitN(ctx, n)
+	l.throttled.Add(time.Since(start).Seconds())
+	return err
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	ctx context.Context
+	l   *Limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > t.l.bw.Burst() {
+		p = p[:t.l.bw.Burst()]
+	}
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := t.l.wait(t.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+	l   *Limiter
+}
+
+func (t *throttledWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p[:min(len(p), t.l.bw.Burst())]
+		if err := t.l.wait(t.ctx, len(chunk)); err != nil {
+			return n, err
+		}
+		m, err := t.ResponseWriter.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// Unwrap is called by [http.ResponseController].
+func (t *throttledWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
@@ -0,0 +1,129 @@
+package http_test
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Limiter(t *testing.T) {
+	scopes := []Scope{ScopeFilesRead, ScopeFilesWrite}
+	auth := NewAPIKeys(MapKeyStore{
+		HashKey("alice"): {ID: "alice", Scopes: scopes},
+		HashKey("bob"):   {ID: "bob", Scopes: scopes},
+	})
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	t.Run("Rate", func(t *testing.T) {
+		l := NewLimiter(prometheus.NewRegistry(), func(l *Limiter) { l.Rate, l.AddrRate = 0.01, 10 })
+		c, ctx := newTestClient(t, Handler(nil, func(o *Options) { o.Authenticator, o.Limiter = auth, l })), context.Background()
+
+		for _, tc := range []struct {
+			token string
+			code  int
+		}{
+			{"alice", http.StatusBadRequest},
+			{"alice", http.StatusTooManyRequests},
+			{"bob", http.StatusBadRequest},
+		} {
+			res, err := c.Do(ctx, "GET /cloud-storage/files/invalid", nil, bearer(tc.token), acceptAll)
+			is.OK(t, err) // return response
+			is.Equal(t, res.StatusCode, tc.code)
+			if tc.code == http.StatusTooManyRequests {
+				is.True(t, res.Header.Get("Retry-After") != "")
+			}
+			is.OK(t, res.Body.Close())
+		}
+	})
+
+	t.Run("Addr", func(t *testing.T) {
+		l := NewLimiter(prometheus.NewRegistry(), func(l *Limiter) { l.Rate = 0.01 })
+		c, ctx := newTestClient(t, Handler(nil, func(o *Options) { o.Authenticator, o.Limiter = auth, l })), context.Background()
+
+		// invalid credentials are limited before they are rejected
+		for _, tc := range []struct {
+			token string
+			code  int
+		}{
+			{"mallory", http.StatusUnauthorized},
+			{"mallory", http.StatusTooManyRequests},
+			{"alice", http.StatusTooManyRequests},
+		} {
+			res, err := c.Do(ctx, "GET /cloud-storage/files/invalid", nil, bearer(tc.token), acceptAll)
+			is.OK(t, err) // return response
+			is.Equal(t, res.StatusCode, tc.code)
+			if tc.code == http.StatusTooManyRequests {
+				is.True(t, res.Header.Get("Retry-After") != "")
+			}
+			is.OK(t, res.Body.Close())
+		}
+	})
+
+	t.Run("Transfers", func(t *testing.T) {
+		up := newTestUploader(t)
+		l := NewLimiter(prometheus.NewRegistry(), func(l *Limiter) { l.MaxTransfers = 1 })
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Authenticator, o.Limiter = auth, l })), context.Background()
+
+		// the upload is in progress until the body is closed
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		errc := make(chan error, 1)
+		go func() {
+			res, err := c.Do(ctx, "POST /cloud-storage/files", pr, bearer("alice"), acceptAll, func(r *http.Request) {
+				r.Header.Set("Content-Type", mw.FormDataContentType())
+			})
+			if err == nil {
+				err = res.Body.Close()
+			}
+			errc <- err
+		}()
+		fw, err := mw.CreateFormFile("file", "slow.txt")
+		is.OK(t, err) // create form file
+		_, err = io.WriteString(fw, "in progress")
+		is.OK(t, err) // write form file
+
+		download := "GET /cloud-storage/files/" + uuid.NewString()
+		code := func(token string) int {
+			res, err := c.Do(ctx, download, nil, bearer(token), acceptAll)
+			is.OK(t, err) // return download response
+			is.OK(t, res.Body.Close())
+			return res.StatusCode
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for code("alice") != http.StatusTooManyRequests {
+			is.True(t, time.Now().Before(deadline)) // upload in progress
+			time.Sleep(10 * time.Millisecond)
+		}
+		is.Equal(t, code("bob"), http.StatusNotFound)
+
+		is.OK(t, mw.Close())
+		is.OK(t, pw.Close())
+		is.OK(t, <-errc) // complete upload
+		is.Equal(t, code("alice"), http.StatusNotFound)
+	})
+
+	t.Run("Bandwidth", func(t *testing.T) {
+		up := newTestUploader(t)
+		l := NewLimiter(prometheus.NewRegistry(), func(l *Limiter) { l.Bandwidth = 1 << 20 })
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Limiter = l })), context.Background()
+		id := postTestFile(t, c)
+
+		res, err := c.Do(ctx, "GET /cloud-storage/files/"+id.String(), nil, acceptAll)
+		is.OK(t, err) // return download response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		p, err := io.ReadAll(res.Body)
+		is.OK(t, err) // read content
+		is.OK(t, res.Body.Close())
+		is.Equal(t, len(p), 14)
+	})
+}
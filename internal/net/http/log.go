@@ -0,0 +1,71 @@
+package http
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.adoublef/blob/internal/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var accessLogKey = &contextKey{"access-log"}
+
+// accessLog collects attributes of a request set by its handler.
+type accessLog struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// logAttrs adds attributes to the access log entry of the request.
+func logAttrs(ctx context.Context, attrs ...slog.Attr) {
+	al, ok := value[*accessLog](ctx, accessLogKey)
+	if !ok {
+		return
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.attrs = append(al.attrs, attrs...)
+}
+
+// loggerHandler carries l in the context of each request.
+func loggerHandler(l *slog.Logger, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(log.NewContext(r.Context(), l)))
+	})
+}
+
+// logHandler writes an access log entry once the request has been served.
+func logHandler(pattern string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		start := time.Now()
+
+		l := log.FromContext(ctx)
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			l = l.With(slog.String("traceId", sc.TraceID().String()))
+		}
+		al := &accessLog{}
+		ctx = log.NewContext(ctx, l)
+		ctx = context.WithValue(ctx, accessLogKey, al)
+
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if sw.code >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := append([]slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("pattern", pattern),
+			slog.Int("status", sw.code),
+			slog.Int64("bytes", sw.n),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("clientIp", clientIP(r)),
+		}, al.attrs...)
+		l.LogAttrs(ctx, level, "request", attrs...)
+	})
+}
@@ -0,0 +1,72 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_logHandler(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		var buf syncBuffer
+		l := slog.New(slog.NewJSONHandler(&buf, nil))
+		c, ctx := newTestClient(t, Handler(newTestUploader(t), func(o *Options) { o.Logger = l })), context.Background()
+
+		res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt")
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		var completed struct {
+			ID string `json:"resourceId"`
+		}
+		is.OK(t, json.NewDecoder(res.Body).Decode(&completed))
+		is.OK(t, res.Body.Close())
+
+		var entry struct {
+			Msg       string `json:"msg"`
+			Method    string `json:"method"`
+			Pattern   string `json:"pattern"`
+			Status    int    `json:"status"`
+			Bytes     int64  `json:"bytes"`
+			ClientIP  string `json:"clientIp"`
+			RequestID string `json:"requestId"`
+			BlobID    string `json:"blobId"`
+			BlobSize  int64  `json:"blobSize"`
+		}
+		is.OK(t, json.Unmarshal(buf.Bytes(), &entry)) // decode access log
+		is.Equal(t, entry.Msg, "request")
+		is.Equal(t, entry.Method, http.MethodPost)
+		is.Equal(t, entry.Pattern, "POST /cloud-storage/files")
+		is.Equal(t, entry.Status, http.StatusOK)
+		is.True(t, entry.Bytes > 0)
+		is.True(t, entry.ClientIP != "")
+		is.Equal(t, entry.RequestID, res.Header.Get(HeaderRequestID))
+		is.Equal(t, entry.BlobID, completed.ID)
+		is.Equal(t, entry.BlobSize, int64(len("hello, world!\n")))
+	})
+}
+
+// syncBuffer is a [bytes.Buffer] safe to write from the server while the
+// test reads it.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.b.Bytes())
+}
@@ -36,7 +36,7 @@ type TestClient struct {
 	testing.TB
 }
 
-func (tc *TestClient) PostFile(ctx context.Context, pattern string, filename string) (*http.Response, error) {
+func (tc *TestClient) PostFile(ctx context.Context, pattern string, filename string, opts ...func(*http.Request)) (*http.Response, error) {
 	f, err := embedFS.Open(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %v", err)
@@ -77,7 +77,7 @@ func (tc *TestClient) PostFile(ctx context.Context, pattern string, filename str
 		r.Header.Set("Accept", "*/*")
 		// set encoding?
 	}
-	return tc.Do(ctx, pattern, pr, o)
+	return tc.Do(ctx, pattern, pr, append([]func(*http.Request){o}, opts...)...)
 }
 
 func (tc *TestClient) Do(ctx context.Context, pattern string, body io.Reader, opts ...func(*http.Request)) (*http.Response, error) {
@@ -116,6 +116,13 @@ func newTestClient(tb testing.TB, h http.Handler) *TestClient {
 
 type TestUploader struct {
 	*os.Client
+	bucket string
+	s3     *s3.Client
+}
+
+// Presigner returns an [os.Presigner] for the bucket of the uploader.
+func (tu *TestUploader) Presigner(opts ...func(*os.Presigner)) *os.Presigner {
+	return os.NewPresigner(tu.bucket, tu.s3, s3.NewPresignClient(tu.s3), opts...)
 }
 
 func newTestUploader(tb testing.TB) *TestUploader {
@@ -152,7 +159,7 @@ func newTestUploader(tb testing.TB) *TestUploader {
 	_, err = client.CreateBucket(context.Background(), p)
 	is.OK(tb, err) // create bucket
 
-	return &TestUploader{Client: os.New(bucket, client)}
+	return &TestUploader{Client: os.New(bucket, client), bucket: bucket, s3: client}
 }
 
 var compose struct {
@@ -0,0 +1,369 @@
+package http
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"go.adoublef/blob/internal/database/sql"
+	"go.adoublef/blob/internal/log"
+)
+
+const (
+	MaxListLimit = 1000
+	MaxTags      = 32
+	MaxTagValue  = 256
+
+	// HeaderMetaPrefix prefixes the headers carrying the tags of a file, such
+	// as X-Blob-Meta-Project. Form fields are prefixed by "meta-" instead.
+	HeaderMetaPrefix = "X-Blob-Meta-"
+	formMetaPrefix   = "meta-"
+
+	// HeaderExpiresIn and HeaderExpiresAt set when an uploaded file is
+	// deleted, as a duration such as 24h or an RFC 3339 time. They can also be
+	// sent as the form fields "expires-in" and "expires-at".
+	HeaderExpiresIn = "X-Blob-Expires-In"
+	HeaderExpiresAt = "X-Blob-Expires-At"
+)
+
+var tagKey = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// Metadata records what is known about each blob beyond its content.
+type Metadata interface {
+	Create(ctx context.Context, b sql.Blob, qs sql.Quotas) error
+	Blob(ctx context.Context, id uuid.UUID) (sql.Blob, error)
+	List(ctx context.Context, o sql.ListOptions) ([]sql.Blob, error)
+	SetTags(ctx context.Context, id uuid.UUID, tags map[string]string) (sql.Blob, error)
+	UpdateTags(ctx context.Context, id uuid.UUID, patch map[string]*string) (sql.Blob, error)
+	Trash(ctx context.Context, id uuid.UUID) error
+	Restore(ctx context.Context, id uuid.UUID) (sql.Blob, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	AddVersion(ctx context.Context, b sql.Blob, qs sql.Quotas) (sql.Blob, error)
+	SetVersion(ctx context.Context, id uuid.UUID, version int) (sql.Blob, error)
+	BlobVersion(ctx context.Context, id uuid.UUID, version int) (sql.Blob, error)
+	Versions(ctx context.Context, id uuid.UUID) ([]sql.Blob, error)
+	Usage(ctx context.Context, owner string) (sql.Usage, error)
+	TotalUsage(ctx context.Context) (sql.Usage, error)
+}
+
+// headerTags returns the tags carried by the headers. Keys are lower case.
+func headerTags(h http.Header) map[string]string {
+	var tags map[string]string
+	for k := range h {
+		if len(k) <= len(HeaderMetaPrefix) || !strings.EqualFold(k[:len(HeaderMetaPrefix)], HeaderMetaPrefix) {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[strings.ToLower(k[len(HeaderMetaPrefix):])] = h.Get(k)
+	}
+	return tags
+}
+
+// validateTag reports whether the key and value can be stored, and sent back
+// as a header.
+func validateTag(k, v string) error {
+	if !tagKey.MatchString(k) {
+		return fmt.Errorf("tag %q must be lower case alphanumeric, '.', '_' or '-' and at most 64 characters", k)
+	}
+	if len(v) > MaxTagValue || !utf8.ValidString(v) || strings.ContainsFunc(v, unicode.IsControl) {
+		return fmt.Errorf("tag %q must be printable and at most %d bytes", k, MaxTagValue)
+	}
+	return nil
+}
+
+// parseExpiry returns when a file expires given a duration or a time, or the
+// zero time if neither is set.
+func parseExpiry(in, at string, now time.Time) (time.Time, error) {
+	switch {
+	case in != "" && at != "":
+		return time.Time{}, errors.New("only one of expires-in and expires-at can be set")
+	case in != "":
+		d, err := time.ParseDuration(in)
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("expires-in %q must be a positive duration", in)
+		}
+		return now.Add(d), nil
+	case at != "":
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil || !t.After(now) {
+			return time.Time{}, fmt.Errorf("expires-at %q must be a future RFC 3339 time", at)
+		}
+		return t, nil
+	}
+	return time.Time{}, nil
+}
+
+// formTags returns the tags of the form fields prefixed by "meta-" merged
+// into tags.
+func formTags(fields map[string]string, tags map[string]string) map[string]string {
+	for k, v := range fields {
+		k, ok := strings.CutPrefix(k, formMetaPrefix)
+		if !ok {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[k] = v
+	}
+	return tags
+}
+
+func validateTags(tags map[string]string) error {
+	if len(tags) > MaxTags {
+		return fmt.Errorf("at most %d tags are allowed", MaxTags)
+	}
+	for k, v := range tags {
+		if err := validateTag(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type Deleter interface {
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// createBlob records the metadata of a blob that has been written. If the
+// metadata cannot be recorded, such as when the blob exceeds the quotas, the
+// blob is deleted, as it could not be found, unless it was already recorded.
+func createBlob(ctx context.Context, d Deleter, md Metadata, q quotas, b sql.Blob) error {
+	if p, ok := PrincipalOf(ctx); ok {
+		b.Owner = p.ID
+	}
+	if b.ContentType == "" {
+		b.ContentType = "application/octet-stream"
+	}
+	err := md.Create(ctx, b, q.limits())
+	if err == nil || errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	// the client may have gone away
+	if err := d.Delete(context.WithoutCancel(ctx), b.ID); err != nil {
+		log.FromContext(ctx).WarnContext(ctx, "failed to delete blob without metadata", slog.String("blobId", b.ID.String()), slog.Any("err", err))
+	}
+	if errors.Is(err, errQuotaExceeded) {
+		return err
+	}
+	return fmt.Errorf("failed to record metadata: %w", err)
+}
+
+// reprDigest returns the Repr-Digest header (RFC 9530) of a hex encoded
+// SHA-256 checksum.
+func reprDigest(sum string) string {
+	p, err := hex.DecodeString(sum)
+	if err != nil || len(p) == 0 {
+		return ""
+	}
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(p) + ":"
+}
+
+type blobInfo struct {
+	ID          string            `json:"resourceId"`
+	Filename    string            `json:"filename,omitempty"`
+	ContentType string            `json:"contentType"`
+	Size        int64             `json:"size"`
+	SHA256      string            `json:"sha256,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	Version     int               `json:"version,omitempty"`
+	ExpiresAt   *time.Time        `json:"expiresAt,omitempty"`
+	DeletedAt   *time.Time        `json:"deletedAt,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func newBlobInfo(b sql.Blob) blobInfo {
+	return blobInfo{
+		ID:          b.ID.String(),
+		Filename:    b.Filename,
+		ContentType: b.ContentType,
+		Size:        b.Size,
+		SHA256:      b.SHA256,
+		Owner:       b.Owner,
+		CreatedAt:   b.CreatedAt.UTC(),
+		Version:     b.Version,
+		ExpiresAt:   optionalTime(b.ExpiresAt),
+		DeletedAt:   optionalTime(b.DeletedAt),
+		Metadata:    b.Tags,
+	}
+}
+
+// optionalTime returns nil for the zero time so it is omitted.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// handleListCloudStorage lists blobs, or those in the trash, oldest first.
+// The next page starts after the last blob of the previous one. Blobs can be
+// filtered by tags, given as ?tag=key:value, which must all match.
+func handleListCloudStorage(md Metadata, trashed bool) http.HandlerFunc {
+	var badQuery = func(format string, v ...any) statusHandler {
+		return statusHandler{
+			code: http.StatusBadRequest,
+			s:    fmt.Sprintf(format, v...),
+		}
+	}
+
+	type response struct {
+		Files []blobInfo `json:"files"`
+		Next  string     `json:"next,omitempty"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		q := r.URL.Query()
+		var after uuid.UUID
+		if s := q.Get("after"); s != "" {
+			var err error
+			if after, err = uuid.Parse(s); err != nil {
+				badQuery("after must be a resource id").ServeHTTP(w, r)
+				return
+			}
+		}
+		limit := sql.DefaultListLimit
+		if s := q.Get("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > MaxListLimit {
+				badQuery("limit must be between 1 and %d", MaxListLimit).ServeHTTP(w, r)
+				return
+			}
+		}
+		var tags map[string]string
+		for _, s := range q["tag"] {
+			k, v, ok := strings.Cut(s, ":")
+			if !ok {
+				badQuery("tag must be formatted as key:value").ServeHTTP(w, r)
+				return
+			}
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			tags[k] = v
+		}
+
+		bs, err := md.List(ctx, sql.ListOptions{After: after, Limit: limit, Tags: tags, Trashed: trashed})
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		res := response{Files: make([]blobInfo, len(bs))}
+		for i, b := range bs {
+			res.Files[i] = newBlobInfo(b)
+		}
+		if len(bs) == limit {
+			res.Next = bs[len(bs)-1].ID.String()
+		}
+		writeJSON(w, r, http.StatusOK, res)
+	}
+}
+
+// handleMetadata serves the tags of a blob. They are replaced by PUT and
+// merged by PATCH, where a null value removes the tag (RFC 7396).
+func handleMetadata(md Metadata) http.HandlerFunc {
+	var badPathValue = statusHandler{
+		code: http.StatusBadRequest,
+		s:    `path parameter has invalid format`,
+	}
+	var notFound = statusHandler{
+		code: http.StatusNotFound,
+		s:    `file does not exist`,
+	}
+	var unprocessableEntity = func(format string, v ...any) statusHandler {
+		return statusHandler{
+			code: http.StatusUnprocessableEntity,
+			s:    fmt.Sprintf(format, v...),
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := uuid.Parse(r.PathValue("file"))
+		if err != nil {
+			badPathValue.ServeHTTP(w, r)
+			return
+		}
+		logAttrs(ctx, slog.String("blobId", id.String()))
+
+		var b sql.Blob
+		switch r.Method {
+		case http.MethodPut:
+			var tags map[string]string
+			if err := decodeJSON(w, r, &tags); err != nil {
+				unprocessableEntity("failed to decode metadata: %v", err).ServeHTTP(w, r)
+				return
+			}
+			if err := validateTags(tags); err != nil {
+				unprocessableEntity("%v", err).ServeHTTP(w, r)
+				return
+			}
+			if b, err = md.Blob(ctx, id); err != nil || b.Trashed() {
+				break
+			}
+			b, err = md.SetTags(ctx, id, tags)
+		case http.MethodPatch:
+			var patch map[string]*string
+			if err := decodeJSON(w, r, &patch); err != nil {
+				unprocessableEntity("failed to decode metadata: %v", err).ServeHTTP(w, r)
+				return
+			}
+			if b, err = md.Blob(ctx, id); err != nil || b.Trashed() {
+				break
+			}
+			// the number of tags depends on those already set
+			merged := maps.Clone(b.Tags)
+			if merged == nil {
+				merged = make(map[string]string)
+			}
+			for k, v := range patch {
+				if v == nil {
+					delete(merged, k)
+					continue
+				}
+				merged[k] = *v
+			}
+			if err := validateTags(merged); err != nil {
+				unprocessableEntity("%v", err).ServeHTTP(w, r)
+				return
+			}
+			b, err = md.UpdateTags(ctx, id, patch)
+		default:
+			b, err = md.Blob(ctx, id)
+		}
+		if err == nil && b.Trashed() {
+			err = fs.ErrNotExist
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		if b.Tags == nil {
+			b.Tags = map[string]string{}
+		}
+		writeJSON(w, r, http.StatusOK, b.Tags)
+	}
+}
@@ -0,0 +1,274 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.adoublef/blob/internal/database/sql"
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Metadata(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata = md })), context.Background()
+
+		res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt")
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		var completed struct {
+			ID uuid.UUID `json:"resourceId"`
+		}
+		is.OK(t, json.NewDecoder(res.Body).Decode(&completed)) // decode upload
+		is.OK(t, res.Body.Close())
+
+		sum := sha256.Sum256([]byte("hello, world!\n"))
+		res, err = c.Do(ctx, "GET /cloud-storage/files", nil, acceptAll)
+		is.OK(t, err) // return list response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		var list struct {
+			Files []struct {
+				ID       uuid.UUID `json:"resourceId"`
+				Filename string    `json:"filename"`
+				Size     int64     `json:"size"`
+				SHA256   string    `json:"sha256"`
+			} `json:"files"`
+		}
+		is.OK(t, json.NewDecoder(res.Body).Decode(&list)) // decode list
+		is.OK(t, res.Body.Close())
+		is.Equal(t, len(list.Files), 1)
+		is.Equal(t, list.Files[0].ID, completed.ID)
+		is.Equal(t, list.Files[0].Filename, "hello.txt")
+		is.Equal(t, list.Files[0].Size, int64(14))
+		is.Equal(t, list.Files[0].SHA256, hex.EncodeToString(sum[:]))
+
+		res, err = c.Do(ctx, "GET /cloud-storage/files/"+completed.ID.String(), nil, acceptAll)
+		is.OK(t, err) // return download response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		is.Equal(t, res.Header.Get("Content-Disposition"), `attachment; filename=hello.txt`)
+		is.Equal(t, res.Header.Get("X-Content-Type-Options"), "nosniff")
+		is.Equal(t, res.Header.Get("Repr-Digest"), "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+		is.OK(t, res.Body.Close())
+	})
+
+	t.Run("ErrNotExist", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata = md })), context.Background()
+
+		// written without metadata
+		id, _, err := up.Upload(ctx, strings.NewReader(""), 0)
+		is.OK(t, err) // upload blob
+
+		res, err := c.Do(ctx, "GET /cloud-storage/files/"+id.String(), nil, acceptAll)
+		is.OK(t, err) // return download response
+		is.Equal(t, res.StatusCode, http.StatusNotFound)
+		is.OK(t, res.Body.Close())
+	})
+
+	t.Run("Backfill", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata = md })), context.Background()
+
+		// written before metadata was recorded
+		id, _, err := up.Upload(ctx, strings.NewReader("hello"), 5)
+		is.OK(t, err) // upload blob
+		n, err := md.Backfill(ctx, up, 0)
+		is.OK(t, err) // backfill metadata
+		is.Equal(t, n, 1)
+
+		res, err := c.Do(ctx, "GET /cloud-storage/files/"+id.String(), nil, acceptAll)
+		is.OK(t, err) // return download response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		p, err := io.ReadAll(res.Body)
+		is.OK(t, err) // read content
+		is.OK(t, res.Body.Close())
+		is.Equal(t, string(p), "hello")
+	})
+
+	t.Run("ErrCreate", func(t *testing.T) {
+		up, md := newTestUploader(t), &failingMetadata{DB: newTestMetadata(t)}
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata = md })), context.Background()
+
+		res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt")
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusInternalServerError)
+		is.OK(t, res.Body.Close())
+
+		// the blob is not left behind
+		_, _, _, err = up.Download(ctx, md.id)
+		is.NotOK(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata = md })), context.Background()
+
+		res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt", func(r *http.Request) {
+			r.Header.Set("X-Blob-Meta-Project", "alpha")
+		})
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		var completed struct {
+			ID uuid.UUID `json:"resourceId"`
+		}
+		is.OK(t, json.NewDecoder(res.Body).Decode(&completed)) // decode upload
+		is.OK(t, res.Body.Close())
+		pattern := "/cloud-storage/files/" + completed.ID.String() + "/metadata"
+
+		for q, n := range map[string]int{"?tag=project:alpha": 1, "?tag=project:beta": 0} {
+			res, err = c.Do(ctx, "GET /cloud-storage/files"+q, nil, acceptAll)
+			is.OK(t, err) // return list response
+			var list struct {
+				Files []json.RawMessage `json:"files"`
+			}
+			is.OK(t, json.NewDecoder(res.Body).Decode(&list)) // decode list
+			is.OK(t, res.Body.Close())
+			is.Equal(t, len(list.Files), n)
+		}
+
+		for _, tc := range []struct {
+			method string
+			body   string
+			code   int
+			want   map[string]string
+		}{
+			{"GET", ``, http.StatusOK, map[string]string{"project": "alpha"}},
+			{"PATCH", `{"customer": "acme", "project": null}`, http.StatusOK, map[string]string{"customer": "acme"}},
+			{"PUT", `{"Bad Key": "x"}`, http.StatusUnprocessableEntity, nil},
+			{"PUT", `{"type": "invoice"}`, http.StatusOK, map[string]string{"type": "invoice"}},
+		} {
+			res, err := c.Do(ctx, tc.method+" "+pattern, strings.NewReader(tc.body), acceptAll)
+			is.OK(t, err) // return metadata response
+			is.Equal(t, res.StatusCode, tc.code)
+			if tc.want != nil {
+				var got map[string]string
+				is.OK(t, json.NewDecoder(res.Body).Decode(&got)) // decode metadata
+				is.Equal(t, got, tc.want)
+			}
+			is.OK(t, res.Body.Close())
+		}
+
+		res, err = c.Do(ctx, "GET /cloud-storage/files/"+completed.ID.String(), nil, acceptAll)
+		is.OK(t, err) // return download response
+		is.Equal(t, res.Header.Get("X-Blob-Meta-Type"), "invoice")
+		is.OK(t, res.Body.Close())
+	})
+
+	t.Run("FormTags", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata = md })), context.Background()
+
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		is.OK(t, mw.WriteField("meta-team", "storage")) // write tag field
+		fw, err := mw.CreateFormFile("file", "hello.txt")
+		is.OK(t, err) // create file part
+		_, err = io.WriteString(fw, "hello, world!\n")
+		is.OK(t, err) // write file part
+		is.OK(t, mw.Close())
+
+		res, err := c.Do(ctx, "POST /cloud-storage/files", &body, acceptAll, func(r *http.Request) {
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+		})
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		var completed struct {
+			ID uuid.UUID `json:"resourceId"`
+		}
+		is.OK(t, json.NewDecoder(res.Body).Decode(&completed)) // decode upload
+		is.OK(t, res.Body.Close())
+
+		b, err := md.Blob(ctx, completed.ID)
+		is.OK(t, err) // return metadata
+		is.Equal(t, b.Tags, map[string]string{"team": "storage"})
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata = md })), context.Background()
+
+		for _, tc := range []struct {
+			in, at string
+			code   int
+		}{
+			{in: "1h", code: http.StatusOK},
+			{in: "soon", code: http.StatusUnprocessableEntity},
+			{at: "2001-01-01T00:00:00Z", code: http.StatusUnprocessableEntity},
+			{in: "1h", at: "2999-01-01T00:00:00Z", code: http.StatusUnprocessableEntity},
+		} {
+			res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt", func(r *http.Request) {
+				if tc.in != "" {
+					r.Header.Set(HeaderExpiresIn, tc.in)
+				}
+				if tc.at != "" {
+					r.Header.Set(HeaderExpiresAt, tc.at)
+				}
+			})
+			is.OK(t, err) // return upload response
+			is.Equal(t, res.StatusCode, tc.code)
+			if tc.code == http.StatusOK {
+				var completed struct {
+					ExpiresAt time.Time `json:"expiresAt"`
+				}
+				is.OK(t, json.NewDecoder(res.Body).Decode(&completed)) // decode upload
+				is.True(t, completed.ExpiresAt.After(time.Now()))
+			}
+			is.OK(t, res.Body.Close())
+		}
+
+		// expired but not yet reaped
+		id, sz, err := up.Upload(ctx, strings.NewReader(""), 0)
+		is.OK(t, err) // upload blob
+		is.OK(t, md.Create(ctx, sql.Blob{ID: id, Size: sz, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(-time.Second)}, sql.Quotas{}))
+
+		res, err := c.Do(ctx, "GET /cloud-storage/files/"+id.String(), nil, acceptAll)
+		is.OK(t, err) // return download response
+		is.Equal(t, res.StatusCode, http.StatusGone)
+		is.OK(t, res.Body.Close())
+	})
+
+	t.Run("ErrLimit", func(t *testing.T) {
+		c, ctx := newTestClient(t, Handler(nil, func(o *Options) { o.Metadata = newTestMetadata(t) })), context.Background()
+
+		for _, q := range []string{"?limit=0", "?limit=1001", "?after=1"} {
+			res, err := c.Do(ctx, "GET /cloud-storage/files"+q, nil, acceptAll)
+			is.OK(t, err) // return list response
+			is.Equal(t, res.StatusCode, http.StatusBadRequest)
+			is.OK(t, res.Body.Close())
+		}
+	})
+}
+
+// failingMetadata fails to record blobs.
+type failingMetadata struct {
+	*sql.DB
+	id uuid.UUID
+}
+
+func (md *failingMetadata) Create(ctx context.Context, b sql.Blob, _ sql.Quotas) error {
+	md.id = b.ID
+	return errors.New("disk full")
+}
+
+func newTestMetadata(tb testing.TB) *sql.DB {
+	tb.Helper()
+	db, err := sql.Open(context.Background(), filepath.Join(tb.TempDir(), "blob.db"))
+	is.OK(tb, err) // open metadata database
+	tb.Cleanup(func() { db.Close() })
+	return db
+}
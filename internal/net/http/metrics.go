@@ -0,0 +1,99 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	directionUp   = "upload"
+	directionDown = "download"
+)
+
+// Metrics records the requests served by the [Handler] and exposes the
+// collectors of its registry.
+type Metrics struct {
+	h http.Handler
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	reqSize  *prometheus.HistogramVec
+	resSize  *prometheus.HistogramVec
+	bytes    *prometheus.CounterVec
+	inFlight *prometheus.GaugeVec
+}
+
+// NewMetrics returns [Metrics] registered with reg.
+func NewMetrics(reg *prometheus.Registry) *Metrics {
+	sizes := prometheus.ExponentialBuckets(1<<8, 4, 10) // 256B to 64MB
+	m := &Metrics{
+		h: promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "blob",
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Requests served by route pattern and status code.",
+		}, []string{"pattern", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "blob",
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Time to serve a request by route pattern and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"pattern", "code"}),
+		reqSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "blob",
+			Subsystem: "http",
+			Name:      "request_size_bytes",
+			Help:      "Approximate size of requests by route pattern and status code.",
+			Buckets:   sizes,
+		}, []string{"pattern", "code"}),
+		resSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "blob",
+			Subsystem: "http",
+			Name:      "response_size_bytes",
+			Help:      "Size of uncompressed responses by route pattern and status code.",
+			Buckets:   sizes,
+		}, []string{"pattern", "code"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "blob",
+			Name:      "transferred_bytes_total",
+			Help:      "Bytes of blob content transferred by direction.",
+		}, []string{"direction"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "blob",
+			Name:      "transfers_in_flight",
+			Help:      "Uploads and downloads currently in progress.",
+		}, []string{"direction"}),
+	}
+	reg.MustRegister(m.requests, m.duration, m.reqSize, m.resSize, m.bytes, m.inFlight)
+	return m
+}
+
+// ServeHTTP serves the collectors of the registry in the Prometheus
+// exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.h.ServeHTTP(w, r)
+}
+
+// instrument records the requests served by h under the given pattern.
+func (m *Metrics) instrument(pattern string, h http.Handler) http.Handler {
+	l := prometheus.Labels{"pattern": pattern}
+	h = promhttp.InstrumentHandlerResponseSize(m.resSize.MustCurryWith(l), h)
+	h = promhttp.InstrumentHandlerRequestSize(m.reqSize.MustCurryWith(l), h)
+	h = promhttp.InstrumentHandlerDuration(m.duration.MustCurryWith(l), h)
+	return promhttp.InstrumentHandlerCounter(m.requests.MustCurryWith(l), h)
+}
+
+// transfer marks a transfer in the direction as in flight until done is
+// called with the number of bytes transferred.
+func (m *Metrics) transfer(direction string) (done func(n int64)) {
+	g := m.inFlight.WithLabelValues(direction)
+	g.Inc()
+	return func(n int64) {
+		g.Dec()
+		m.bytes.WithLabelValues(direction).Add(float64(n))
+	}
+}
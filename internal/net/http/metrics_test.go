@@ -0,0 +1,41 @@
+package http_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Metrics(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		m := NewMetrics(prometheus.NewRegistry())
+		c, ctx := newTestClient(t, Handler(newTestUploader(t), func(o *Options) { o.Metrics = m })), context.Background()
+
+		res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt")
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		is.OK(t, res.Body.Close())
+
+		// scrapers do not send an accept header for json or html
+		res, err = newTestClient(t, m).Do(ctx, "GET /metrics", nil)
+		is.OK(t, err) // return metrics response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		p, err := io.ReadAll(res.Body)
+		is.OK(t, err) // read metrics
+		is.OK(t, res.Body.Close())
+
+		for _, s := range []string{
+			`blob_http_requests_total{code="200",pattern="POST /cloud-storage/files"} 1`,
+			`blob_transferred_bytes_total{direction="upload"} 14`,
+			`blob_transfers_in_flight{direction="upload"} 0`,
+		} {
+			is.True(t, strings.Contains(string(p), s))
+		}
+	})
+}
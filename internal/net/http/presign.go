@@ -0,0 +1,405 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"go.adoublef/blob/internal/database/sql"
+	"go.adoublef/blob/internal/log"
+	"go.adoublef/blob/internal/os"
+)
+
+// Presigner hands out requests that transfer blobs directly to and from the
+// bucket so their content does not pass through the server.
+type Presigner interface {
+	PresignUpload(ctx context.Context, size int64) (os.PresignedUpload, error)
+	PresignParts(ctx context.Context, id uuid.UUID, uploadID string, size int64, first int32) (parts []os.PresignedRequest, expires time.Time, err error)
+	Complete(ctx context.Context, id uuid.UUID, uploadID string, parts []os.CompletedPart) (sz int64, etag string, err error)
+	PresignDownload(ctx context.Context, id, vid uuid.UUID) (req os.PresignedRequest, expires time.Time, err error)
+}
+
+type presignedRequest struct {
+	Method string            `json:"method"`
+	URL    string            `json:"url"`
+	Header map[string]string `json:"headers,omitempty"`
+}
+
+func newPresignedRequest(req os.PresignedRequest) presignedRequest {
+	pr := presignedRequest{Method: req.Method, URL: req.URL}
+	for k := range req.Header {
+		if pr.Header == nil {
+			pr.Header = make(map[string]string)
+		}
+		pr.Header[k] = req.Header.Get(k)
+	}
+	return pr
+}
+
+// decodeJSON decodes the body of the request into v, rejecting unknown
+// fields.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, DefaultMaxBytes))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// writeJSON writes v as the body of the response.
+func writeJSON(w http.ResponseWriter, r *http.Request, code int, v any) {
+	w.Header().Set("Content-Type", ContentTypJSON)
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		ctx := r.Context()
+		log.FromContext(ctx).WarnContext(ctx, "failed to write response", slog.Any("err", err))
+	}
+}
+
+// checkUploadSize writes an error and returns false if a presigned upload
+// of size bytes is not allowed, being larger than maxSize if it is positive or
+// than the quotas of the caller.
+func checkUploadSize(w http.ResponseWriter, r *http.Request, size *int64, maxSize int64, q quotas) bool {
+	ctx := r.Context()
+	if size == nil || *size < 0 || *size > os.MaxObjectSize {
+		statusHandler{
+			code: http.StatusUnprocessableEntity,
+			s:    fmt.Sprintf("size must be between 0 and %d bytes", int64(os.MaxObjectSize)),
+		}.ServeHTTP(w, r)
+		return false
+	}
+	if maxSize > 0 && *size > maxSize {
+		statusHandler{
+			code: http.StatusRequestEntityTooLarge,
+			s:    fmt.Sprintf("file is larger than %d bytes", maxSize),
+		}.ServeHTTP(w, r)
+		return false
+	}
+	_, err := q.admit(ctx, ownerOf(ctx), 1, *size)
+	if errors.Is(err, errQuotaExceeded) {
+		insufficientStorage(err).ServeHTTP(w, r)
+		return false
+	}
+	if err != nil {
+		Error(w, r, err)
+		return false
+	}
+	return true
+}
+
+// handlePresignUpload starts an upload that the client sends to the bucket,
+// rejecting sizes larger than maxSize if it is positive. Only the first parts
+// are presigned, the rest by [handlePresignParts].
+func handlePresignUpload(p Presigner, maxSize int64, q quotas) http.HandlerFunc {
+	var unprocessableEntity = func(format string, v ...any) statusHandler {
+		return statusHandler{
+			code: http.StatusUnprocessableEntity,
+			s:    fmt.Sprintf(format, v...),
+		}
+	}
+
+	type request struct {
+		Size *int64 `json:"size"`
+	}
+	type response struct {
+		ID        string             `json:"resourceId"`
+		UploadID  string             `json:"uploadId"`
+		PartSize  int64              `json:"partSize"`
+		PartCount int32              `json:"partCount"`
+		Parts     []presignedRequest `json:"parts"`
+		ExpiresAt time.Time          `json:"expiresAt"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var req request
+		if err := decodeJSON(w, r, &req); err != nil {
+			unprocessableEntity("failed to decode request: %v", err).ServeHTTP(w, r)
+			return
+		}
+		if !checkUploadSize(w, r, req.Size, maxSize, q) {
+			return
+		}
+
+		ctx, span := startSpan(ctx, "PresignUpload", blobSizeKey.Int64(*req.Size))
+		pu, err := p.PresignUpload(ctx, *req.Size)
+		if err == nil {
+			span.SetAttributes(blobIDKey.String(pu.ID.String()))
+			logAttrs(ctx, slog.String("blobId", pu.ID.String()), slog.Int64("blobSize", *req.Size))
+		}
+		if p, ok := PrincipalOf(ctx); ok {
+			span.SetAttributes(blobOwnerKey.String(p.ID))
+		}
+		endSpan(span, err)
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+
+		res := response{
+			ID:        pu.ID.String(),
+			UploadID:  pu.UploadID,
+			PartSize:  pu.PartSize,
+			PartCount: pu.PartCount,
+			ExpiresAt: pu.Expires.UTC(),
+		}
+		for _, part := range pu.Parts {
+			res.Parts = append(res.Parts, newPresignedRequest(part))
+		}
+		writeJSON(w, r, http.StatusCreated, res)
+	}
+}
+
+// handlePresignParts presigns further parts of an upload, starting from
+// firstPart. The size is checked again as it fixes the size of each part.
+func handlePresignParts(p Presigner, maxSize int64, q quotas) http.HandlerFunc {
+	var badPathValue = statusHandler{
+		code: http.StatusBadRequest,
+		s:    `path parameter has invalid format`,
+	}
+	var unprocessableEntity = func(format string, v ...any) statusHandler {
+		return statusHandler{
+			code: http.StatusUnprocessableEntity,
+			s:    fmt.Sprintf(format, v...),
+		}
+	}
+
+	type request struct {
+		UploadID  string `json:"uploadId"`
+		Size      *int64 `json:"size"`
+		FirstPart int32  `json:"firstPart"`
+	}
+	type response struct {
+		Parts     []presignedRequest `json:"parts"`
+		ExpiresAt time.Time          `json:"expiresAt"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := uuid.Parse(r.PathValue("file"))
+		if err != nil {
+			badPathValue.ServeHTTP(w, r)
+			return
+		}
+		var req request
+		if err := decodeJSON(w, r, &req); err != nil {
+			unprocessableEntity("failed to decode request: %v", err).ServeHTTP(w, r)
+			return
+		}
+		if req.UploadID == "" {
+			unprocessableEntity("uploadId is required").ServeHTTP(w, r)
+			return
+		}
+		if !checkUploadSize(w, r, req.Size, maxSize, q) {
+			return
+		}
+
+		logAttrs(ctx, slog.String("blobId", id.String()))
+		ctx, span := startSpan(ctx, "PresignParts", blobIDKey.String(id.String()), blobSizeKey.Int64(*req.Size))
+		parts, expires, err := p.PresignParts(ctx, id, req.UploadID, *req.Size, req.FirstPart)
+		if errors.Is(err, os.ErrInvalidUpload) {
+			endSpan(span, nil)
+			unprocessableEntity("firstPart is not a part of the upload").ServeHTTP(w, r)
+			return
+		}
+		endSpan(span, err)
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+
+		res := response{ExpiresAt: expires.UTC()}
+		for _, part := range parts {
+			res.Parts = append(res.Parts, newPresignedRequest(part))
+		}
+		writeJSON(w, r, http.StatusCreated, res)
+	}
+}
+
+// handleCompleteUpload completes an upload sent to the bucket, verifying the
+// blob exists. The blob is deleted if it exceeds the quotas of the caller,
+// which may have been used by other uploads since it was presigned.
+func handleCompleteUpload(p Presigner, d Deleter, md Metadata, q quotas) http.HandlerFunc {
+	var badPathValue = statusHandler{
+		code: http.StatusBadRequest,
+		s:    `path parameter has invalid format`,
+	}
+	var unprocessableEntity = func(format string, v ...any) statusHandler {
+		return statusHandler{
+			code: http.StatusUnprocessableEntity,
+			s:    fmt.Sprintf(format, v...),
+		}
+	}
+	var conflict = statusHandler{
+		code: http.StatusConflict,
+		s:    `upload is already complete`,
+	}
+
+	type part struct {
+		PartNumber int32  `json:"partNumber"`
+		ETag       string `json:"etag"`
+	}
+	type request struct {
+		UploadID    string            `json:"uploadId"`
+		Parts       []part            `json:"parts"`
+		Filename    string            `json:"filename"`
+		ContentType string            `json:"contentType"`
+		Metadata    map[string]string `json:"metadata"`
+		ExpiresIn   string            `json:"expiresIn"`
+		ExpiresAt   string            `json:"expiresAt"`
+	}
+	type completed struct {
+		ID        string     `json:"resourceId"`
+		Size      int64      `json:"bytesWritten"`
+		ETag      string     `json:"etag"`
+		ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := uuid.Parse(r.PathValue("file"))
+		if err != nil {
+			badPathValue.ServeHTTP(w, r)
+			return
+		}
+		var req request
+		if err := decodeJSON(w, r, &req); err != nil {
+			unprocessableEntity("failed to decode request: %v", err).ServeHTTP(w, r)
+			return
+		}
+		if req.UploadID == "" || len(req.Parts) == 0 {
+			unprocessableEntity("uploadId and parts are required").ServeHTTP(w, r)
+			return
+		}
+		if err := validateTags(req.Metadata); err != nil {
+			unprocessableEntity("%v", err).ServeHTTP(w, r)
+			return
+		}
+		expires, err := parseExpiry(req.ExpiresIn, req.ExpiresAt, time.Now())
+		if err != nil {
+			unprocessableEntity("%v", err).ServeHTTP(w, r)
+			return
+		}
+		parts := make([]os.CompletedPart, len(req.Parts))
+		for i, p := range req.Parts {
+			if p.PartNumber < 1 || int64(p.PartNumber) > os.MaxUploadParts || p.ETag == "" {
+				unprocessableEntity("part %d must have a partNumber and etag", i).ServeHTTP(w, r)
+				return
+			}
+			parts[i] = os.CompletedPart{Number: p.PartNumber, ETag: p.ETag}
+		}
+
+		logAttrs(ctx, slog.String("blobId", id.String()))
+		// a recorded blob is never completed again, which would let its
+		// object be deleted if it exceeds the quotas
+		if md != nil {
+			_, err := md.Blob(ctx, id)
+			if err == nil {
+				conflict.ServeHTTP(w, r)
+				return
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				Error(w, r, err)
+				return
+			}
+		}
+		ctx, span := startSpan(ctx, "CompleteUpload", blobIDKey.String(id.String()))
+		sz, etag, err := p.Complete(ctx, id, req.UploadID, parts)
+		if err == nil && md != nil {
+			err = createBlob(ctx, d, md, q, sql.Blob{
+				ID:          id,
+				Filename:    req.Filename,
+				ContentType: req.ContentType,
+				Size:        sz,
+				CreatedAt:   time.Now(),
+				ExpiresAt:   expires,
+				Tags:        req.Metadata,
+			})
+		}
+		if err == nil {
+			span.SetAttributes(blobSizeKey.Int64(sz))
+			logAttrs(ctx, slog.Int64("blobSize", sz))
+		}
+		if p, ok := PrincipalOf(ctx); ok {
+			span.SetAttributes(blobOwnerKey.String(p.ID))
+		}
+		if errors.Is(err, os.ErrInvalidUpload) {
+			endSpan(span, nil)
+			unprocessableEntity("%v", err).ServeHTTP(w, r)
+			return
+		}
+		if errors.Is(err, fs.ErrExist) {
+			endSpan(span, nil)
+			conflict.ServeHTTP(w, r)
+			return
+		}
+		if errors.Is(err, errQuotaExceeded) {
+			endSpan(span, nil)
+			insufficientStorage(err).ServeHTTP(w, r)
+			return
+		}
+		endSpan(span, err)
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, completed{ID: id.String(), Size: sz, ETag: etag, ExpiresAt: optionalTime(expires)})
+	}
+}
+
+// handlePresignDownload returns a request for the content of the current
+// version of a blob.
+func handlePresignDownload(p Presigner, md Metadata) http.HandlerFunc {
+	var badPathValue = statusHandler{
+		code: http.StatusBadRequest,
+		s:    `path parameter has invalid format`,
+	}
+	var notFound = statusHandler{
+		code: http.StatusNotFound,
+		s:    `file does not exist`,
+	}
+
+	type response struct {
+		presignedRequest
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := uuid.Parse(r.PathValue("file"))
+		if err != nil {
+			badPathValue.ServeHTTP(w, r)
+			return
+		}
+		logAttrs(ctx, slog.String("blobId", id.String()))
+		var b sql.Blob
+		if md != nil {
+			b, err = md.Blob(ctx, id)
+			if errors.Is(err, fs.ErrNotExist) || (err == nil && (b.Trashed() || b.Expired(time.Now()))) {
+				notFound.ServeHTTP(w, r)
+				return
+			}
+			if err != nil {
+				Error(w, r, err)
+				return
+			}
+		}
+		ctx, span := startSpan(ctx, "PresignDownload", blobIDKey.String(id.String()))
+		req, expires, err := p.PresignDownload(ctx, id, b.VersionID)
+		if errors.Is(err, fs.ErrNotExist) {
+			endSpan(span, nil)
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		endSpan(span, err)
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusCreated, response{newPresignedRequest(req), expires.UTC()})
+	}
+}
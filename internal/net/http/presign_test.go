@@ -0,0 +1,146 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/os"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_handlePresignUpload(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		size int
+	}{
+		{"SinglePart", 14},
+		{"Multipart", 5<<20 + 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			up := newTestUploader(t)
+			h := Handler(up, func(o *Options) {
+				o.Presigner = up.Presigner(func(p *os.Presigner) { p.PartSize, p.MaxParts = 5<<20, 1 })
+			})
+			c, ctx := newTestClient(t, h), context.Background()
+
+			p := make([]byte, tc.size)
+			_, err := rand.Read(p)
+			is.OK(t, err) // return random object
+
+			res, err := c.Do(ctx, "POST /cloud-storage/uploads", strings.NewReader(fmt.Sprintf(`{"size": %d}`, tc.size)), acceptAll)
+			is.OK(t, err) // return presigned upload
+			is.Equal(t, res.StatusCode, http.StatusCreated)
+			type presignedRequest struct {
+				Method  string            `json:"method"`
+				URL     string            `json:"url"`
+				Headers map[string]string `json:"headers"`
+			}
+			var presigned struct {
+				ID        uuid.UUID          `json:"resourceId"`
+				UploadID  string             `json:"uploadId"`
+				PartSize  int64              `json:"partSize"`
+				PartCount int                `json:"partCount"`
+				Parts     []presignedRequest `json:"parts"`
+			}
+			is.OK(t, json.NewDecoder(res.Body).Decode(&presigned)) // decode presigned upload
+			is.OK(t, res.Body.Close())
+
+			// only the first part is presigned with the upload
+			for len(presigned.Parts) < presigned.PartCount {
+				body := fmt.Sprintf(`{"uploadId": %q, "size": %d, "firstPart": %d}`, presigned.UploadID, tc.size, len(presigned.Parts)+1)
+				res, err := c.Do(ctx, "POST /cloud-storage/uploads/"+presigned.ID.String()+"/parts", strings.NewReader(body), acceptAll)
+				is.OK(t, err) // return presigned parts
+				is.Equal(t, res.StatusCode, http.StatusCreated)
+				var more struct {
+					Parts []presignedRequest `json:"parts"`
+				}
+				is.OK(t, json.NewDecoder(res.Body).Decode(&more)) // decode presigned parts
+				is.OK(t, res.Body.Close())
+				is.Equal(t, len(more.Parts), 1)
+				presigned.Parts = append(presigned.Parts, more.Parts...)
+			}
+
+			type part struct {
+				PartNumber int    `json:"partNumber"`
+				ETag       string `json:"etag"`
+			}
+			var parts []part
+			for i, pr := range presigned.Parts {
+				off := int64(i) * presigned.PartSize
+				body := p[off:min(off+presigned.PartSize, int64(len(p)))]
+				req, err := http.NewRequestWithContext(ctx, pr.Method, pr.URL, bytes.NewReader(body))
+				is.OK(t, err) // return part request
+				for k, v := range pr.Headers {
+					req.Header.Set(k, v)
+				}
+				res, err := http.DefaultClient.Do(req)
+				is.OK(t, err) // send part to bucket
+				is.Equal(t, res.StatusCode, http.StatusOK)
+				is.OK(t, res.Body.Close())
+				parts = append(parts, part{i + 1, res.Header.Get("ETag")})
+			}
+
+			complete, err := json.Marshal(map[string]any{"uploadId": presigned.UploadID, "parts": parts})
+			is.OK(t, err) // encode completion
+			res, err = c.Do(ctx, "POST /cloud-storage/uploads/"+presigned.ID.String()+"/complete", bytes.NewReader(complete), acceptAll)
+			is.OK(t, err) // complete upload
+			is.Equal(t, res.StatusCode, http.StatusOK)
+			var completed struct {
+				Size int64 `json:"bytesWritten"`
+			}
+			is.OK(t, json.NewDecoder(res.Body).Decode(&completed)) // decode completed upload
+			is.OK(t, res.Body.Close())
+			is.Equal(t, completed.Size, int64(tc.size))
+
+			res, err = c.Do(ctx, "POST /cloud-storage/files/"+presigned.ID.String()+"/presigned-urls", nil, acceptAll)
+			is.OK(t, err) // return presigned download
+			is.Equal(t, res.StatusCode, http.StatusCreated)
+			var download struct {
+				URL string `json:"url"`
+			}
+			is.OK(t, json.NewDecoder(res.Body).Decode(&download)) // decode presigned download
+			is.OK(t, res.Body.Close())
+
+			res, err = http.Get(download.URL)
+			is.OK(t, err) // download from bucket
+			got, err := io.ReadAll(res.Body)
+			is.OK(t, err) // read object
+			is.OK(t, res.Body.Close())
+			is.True(t, bytes.Equal(got, p)) // got;want object
+		})
+	}
+
+	t.Run("Err", func(t *testing.T) {
+		up := newTestUploader(t)
+		h := Handler(up, func(o *Options) { o.Presigner = up.Presigner() })
+		c, ctx := newTestClient(t, h), context.Background()
+
+		for _, tc := range []struct {
+			pattern string
+			body    string
+			code    int
+		}{
+			{"POST /cloud-storage/uploads", `{}`, http.StatusUnprocessableEntity},
+			{"POST /cloud-storage/uploads", `{"size": -1}`, http.StatusUnprocessableEntity},
+			{"POST /cloud-storage/uploads/" + uuid.NewString() + "/parts", `{"size": 14}`, http.StatusUnprocessableEntity},
+			{"POST /cloud-storage/uploads/" + uuid.NewString() + "/parts", `{"uploadId": "1", "size": 14, "firstPart": 2}`, http.StatusUnprocessableEntity},
+			{"POST /cloud-storage/uploads/" + uuid.NewString() + "/complete", `{}`, http.StatusUnprocessableEntity},
+			{"POST /cloud-storage/uploads/" + uuid.NewString() + "/complete", `{"uploadId": "1"}`, http.StatusUnprocessableEntity},
+			{"POST /cloud-storage/files/" + uuid.NewString() + "/presigned-urls", ``, http.StatusNotFound},
+		} {
+			res, err := c.Do(ctx, tc.pattern, strings.NewReader(tc.body), acceptAll)
+			is.OK(t, err) // return response
+			is.Equal(t, res.StatusCode, tc.code)
+			is.OK(t, res.Body.Close())
+		}
+	})
+}
@@ -0,0 +1,286 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.adoublef/blob/internal/os"
+)
+
+// HeaderUploadSession names the session whose events report the progress of
+// an upload.
+const HeaderUploadSession = "X-Upload-Session"
+
+const (
+	DefaultProgressRetention = time.Minute
+	DefaultProgressInterval  = 250 * time.Millisecond
+	DefaultProgressHeartbeat = 15 * time.Second
+)
+
+// Progress reports the uploads made with the [HeaderUploadSession] header to
+// the callers subscribed to the events of the session, as Server-Sent Events.
+// Sessions are chosen by the caller and are only visible to the principal
+// that made the upload.
+type Progress struct {
+	// Retention is how long the events of a finished upload can still be
+	// subscribed to.
+	Retention time.Duration
+	// Interval is the least time between two progress events.
+	Interval time.Duration
+	// Heartbeat is how often a comment is sent to keep an idle subscription
+	// from being closed.
+	Heartbeat time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*uploadSession
+	swept    time.Time
+}
+
+type uploadSession struct {
+	progress os.Progress
+	// status is the status code of the finished upload, or 0 until then.
+	status   int
+	active   bool
+	watchers int
+	seen     time.Time
+	// changed is closed and replaced each time the session changes.
+	changed chan struct{}
+}
+
+// NewProgress returns a [Progress].
+func NewProgress(opts ...func(*Progress)) *Progress {
+	p := &Progress{
+		Retention: DefaultProgressRetention,
+		Interval:  DefaultProgressInterval,
+		Heartbeat: DefaultProgressHeartbeat,
+		sessions:  make(map[string]*uploadSession),
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	return p
+}
+
+// track reports the progress of uploads that name a session. An upload
+// cannot use a session while another is in progress.
+func (p *Progress) track(h http.Handler) http.Handler {
+	if p == nil {
+		return h
+	}
+	var badRequest = statusHandler{
+		code: http.StatusBadRequest,
+		s:    HeaderUploadSession + ` header has invalid format`,
+	}
+	var conflict = statusHandler{
+		code: http.StatusConflict,
+		s:    `upload session is in use`,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		v := r.Header.Get(HeaderUploadSession)
+		if v == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		id, err := uuid.Parse(v)
+		if err != nil {
+			badRequest.ServeHTTP(w, r)
+			return
+		}
+		key := sessionKey(r, id)
+		s, ok := p.start(key)
+		if !ok {
+			conflict.ServeHTTP(w, r)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		ctx = os.WithProgress(ctx, func(pr os.Progress) { p.update(s, pr) })
+		defer func() { p.finish(s, sw.code) }()
+		h.ServeHTTP(sw, r.WithContext(ctx))
+	})
+}
+
+// sessionKey returns the key of the session of the caller.
+func sessionKey(r *http.Request, id uuid.UUID) string {
+	// callers cannot watch each other's uploads
+	if p, ok := PrincipalOf(r.Context()); ok {
+		return p.ID + "/" + id.String()
+	}
+	return "/" + id.String()
+}
+
+// session returns the session of the key, forgetting those that have been
+// idle. It must be called with the lock held.
+func (p *Progress) session(key string) *uploadSession {
+	now := time.Now()
+	if now.Sub(p.swept) > p.Retention {
+		for k, s := range p.sessions {
+			if !s.active && s.watchers == 0 && now.Sub(s.seen) > p.Retention {
+				delete(p.sessions, k)
+			}
+		}
+		p.swept = now
+	}
+	s, ok := p.sessions[key]
+	if !ok {
+		s = &uploadSession{changed: make(chan struct{})}
+		p.sessions[key] = s
+	}
+	s.seen = now
+	return s
+}
+
+// start marks the session as in use by an upload, reporting false if it
+// already is.
+func (p *Progress) start(key string) (*uploadSession, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.session(key)
+	if s.active {
+		return nil, false
+	}
+	s.active, s.progress, s.status = true, os.Progress{}, 0
+	s.notify()
+	return s, true
+}
+
+func (p *Progress) update(s *uploadSession, pr os.Progress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.progress, s.seen = pr, time.Now()
+	s.notify()
+}
+
+func (p *Progress) finish(s *uploadSession, code int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.active, s.status, s.seen = false, code, time.Now()
+	s.notify()
+}
+
+// watch returns the session of the key, which is kept until unwatched.
+func (p *Progress) watch(key string) *uploadSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.session(key)
+	s.watchers++
+	return s
+}
+
+func (p *Progress) unwatch(s *uploadSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.watchers--
+	s.seen = time.Now()
+}
+
+// state returns the progress and status of the session, along with a channel
+// closed when either changes.
+func (p *Progress) state(s *uploadSession) (os.Progress, int, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return s.progress, s.status, s.changed
+}
+
+func (s *uploadSession) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// handleUploadEvents streams the progress of the uploads of a session as a
+// "progress" event each time more of the file is received or stored,
+// followed by a "complete" or "failed" event with the status code of the
+// upload. Subscribing before the upload starts or soon after it finishes is
+// allowed.
+func handleUploadEvents(p *Progress) http.HandlerFunc {
+	var badRequest = statusHandler{
+		code: http.StatusBadRequest,
+		s:    `path parameter has invalid format`,
+	}
+
+	type event struct {
+		Received  int64 `json:"bytesReceived"`
+		Committed int64 `json:"bytesCommitted"`
+		Status    int   `json:"status,omitempty"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := uuid.Parse(r.PathValue("session"))
+		if err != nil {
+			badRequest.ServeHTTP(w, r)
+			return
+		}
+		s := p.watch(sessionKey(r, id))
+		defer p.unwatch(s)
+
+		rc := http.NewResponseController(w)
+		// the stream outlives the write timeout of the server
+		_ = rc.SetWriteDeadline(time.Time{})
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+
+		heartbeat := time.NewTicker(p.Heartbeat)
+		defer heartbeat.Stop()
+		var (
+			last os.Progress
+			sent time.Time
+		)
+		for {
+			pr, status, changed := p.state(s)
+			if sent.IsZero() || pr != last {
+				if err := writeEvent(w, "progress", event{pr.Received, pr.Committed, 0}); err != nil {
+					return
+				}
+				last, sent = pr, time.Now()
+			}
+			if status != 0 {
+				name := "complete"
+				if status >= http.StatusBadRequest {
+					name = "failed"
+				}
+				_ = writeEvent(w, name, event{pr.Received, pr.Committed, status})
+				_ = rc.Flush()
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+
+			select {
+			case <-changed:
+			case <-heartbeat.C:
+				if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+				continue
+			case <-ctx.Done():
+				return
+			}
+			// changes within an interval are sent as one event
+			wait := time.NewTimer(time.Until(sent.Add(p.Interval)))
+			select {
+			case <-wait.C:
+			case <-ctx.Done():
+				wait.Stop()
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes v as the data of a Server-Sent Event.
+func writeEvent(w io.Writer, name string, v any) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, p)
+	return err
+}
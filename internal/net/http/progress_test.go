@@ -0,0 +1,122 @@
+package http_test
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Progress(t *testing.T) {
+	session := func(id string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set(HeaderUploadSession, id) }
+	}
+	events := func(c *TestClient, id string) io.ReadCloser {
+		res, err := c.Do(context.Background(), "GET /cloud-storage/uploads/"+id+"/events", nil, func(r *http.Request) {
+			r.Header.Set("Accept", "text/event-stream")
+		})
+		is.OK(t, err) // return events response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		is.Equal(t, res.Header.Get("Content-Type"), "text/event-stream")
+		return res.Body
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		up := newTestUploader(t)
+		c, ctx := newTestClient(t, Handler(up)), context.Background()
+		id := uuid.NewString()
+
+		// subscribed before the upload starts
+		body := events(c, id)
+		res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt", session(id))
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		is.OK(t, res.Body.Close())
+
+		p, err := io.ReadAll(body)
+		is.OK(t, err) // read events
+		is.OK(t, body.Close())
+		is.True(t, strings.HasPrefix(string(p), "event: progress\n"))
+		is.True(t, strings.HasSuffix(string(p), "event: complete\ndata: {\"bytesReceived\":14,\"bytesCommitted\":14,\"status\":200}\n\n"))
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		up := newTestUploader(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.MaxFileSize = 4 })), context.Background()
+		id := uuid.NewString()
+
+		res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt", session(id))
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusRequestEntityTooLarge)
+		is.OK(t, res.Body.Close())
+
+		// subscribed after the upload finishes
+		body := events(c, id)
+		p, err := io.ReadAll(body)
+		is.OK(t, err) // read events
+		is.OK(t, body.Close())
+		is.True(t, strings.Contains(string(p), "event: failed\n"))
+		is.True(t, strings.Contains(string(p), `"status":413`))
+	})
+
+	t.Run("InUse", func(t *testing.T) {
+		up := newTestUploader(t)
+		c, ctx := newTestClient(t, Handler(up)), context.Background()
+		id := uuid.NewString()
+
+		// the upload is in progress until the body is closed
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		errc := make(chan error, 1)
+		go func() {
+			res, err := c.Do(ctx, "POST /cloud-storage/files", pr, session(id), acceptAll, func(r *http.Request) {
+				r.Header.Set("Content-Type", mw.FormDataContentType())
+			})
+			if err == nil {
+				err = res.Body.Close()
+			}
+			errc <- err
+		}()
+		fw, err := mw.CreateFormFile("file", "slow.txt")
+		is.OK(t, err) // create form file
+		_, err = io.WriteString(fw, "in progress")
+		is.OK(t, err) // write form file
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt", session(id))
+			is.OK(t, err) // return upload response
+			is.OK(t, res.Body.Close())
+			if res.StatusCode == http.StatusConflict {
+				break
+			}
+			is.True(t, time.Now().Before(deadline)) // upload in progress
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		is.OK(t, mw.Close())
+		is.OK(t, pw.Close())
+		is.OK(t, <-errc) // complete upload
+	})
+
+	t.Run("ErrSession", func(t *testing.T) {
+		c, ctx := newTestClient(t, Handler(nil)), context.Background()
+
+		res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt", session("invalid"))
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusBadRequest)
+		is.OK(t, res.Body.Close())
+
+		res, err = c.Do(ctx, "GET /cloud-storage/uploads/invalid/events", nil, acceptAll)
+		is.OK(t, err) // return events response
+		is.Equal(t, res.StatusCode, http.StatusBadRequest)
+		is.OK(t, res.Body.Close())
+	})
+}
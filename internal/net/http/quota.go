@@ -0,0 +1,162 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.adoublef/blob/internal/database/sql"
+)
+
+// errQuotaExceeded is also returned by the metadata, which checks the quotas
+// again as each file is recorded.
+var errQuotaExceeded = sql.ErrQuotaExceeded
+
+// Quota caps the storage used by files, counting each of their versions and
+// those in the trash until they are purged. Zero values are unlimited.
+type Quota struct {
+	Bytes   int64
+	Objects int64
+}
+
+// quotas caps the storage of the tenant and of each of its callers. Callers
+// that are not authenticated are only capped by the tenant.
+type quotas struct {
+	md     Metadata
+	tenant Quota
+	user   Quota
+}
+
+// remaining returns the bytes that can still be stored once the owner adds
+// the given number of files, or a negative number if that is unlimited. It
+// fails with errQuotaExceeded if the files cannot be added.
+func (q quotas) remaining(ctx context.Context, owner string, objects int64) (int64, error) {
+	n := int64(-1)
+	check := func(name string, quota Quota, usage func() (sql.Usage, error)) error {
+		if q.md == nil || quota == (Quota{}) {
+			return nil
+		}
+		u, err := usage()
+		if err != nil {
+			return err
+		}
+		if quota.Objects > 0 && u.Objects+objects > quota.Objects {
+			return fmt.Errorf("%w: %s is limited to %d files", errQuotaExceeded, name, quota.Objects)
+		}
+		if left := max(quota.Bytes-u.Bytes, 0); quota.Bytes > 0 && (n < 0 || left < n) {
+			n = left
+		}
+		return nil
+	}
+	if err := check("tenant", q.tenant, func() (sql.Usage, error) { return q.md.TotalUsage(ctx) }); err != nil {
+		return 0, err
+	}
+	if owner == "" {
+		return n, nil
+	}
+	return n, check("caller", q.user, func() (sql.Usage, error) { return q.md.Usage(ctx, owner) })
+}
+
+// admit returns the bytes that can still be stored as with remaining,
+// failing with errQuotaExceeded if they are fewer than size.
+func (q quotas) admit(ctx context.Context, owner string, objects, size int64) (int64, error) {
+	left, err := q.remaining(ctx, owner, objects)
+	if err == nil && left >= 0 && size > left {
+		err = errQuotaExceeded
+	}
+	return left, err
+}
+
+// limits returns the quotas checked by the metadata as each file is recorded,
+// so that concurrent uploads cannot together exceed them.
+func (q quotas) limits() sql.Quotas {
+	return sql.Quotas{Total: sql.Usage(q.tenant), Owner: sql.Usage(q.user)}
+}
+
+// ownerOf returns the id of the caller, which owns the files it uploads, or
+// empty if it is not authenticated.
+func ownerOf(ctx context.Context) string {
+	if p, ok := PrincipalOf(ctx); ok {
+		return p.ID
+	}
+	return ""
+}
+
+// limitQuota returns r failing with errQuotaExceeded once more than n bytes
+// are read, unless n is negative.
+func limitQuota(r io.Reader, n int64) io.Reader {
+	if n < 0 {
+		return r
+	}
+	return &quotaReader{r: r, n: n}
+}
+
+type quotaReader struct {
+	r io.Reader
+	n int64
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	if q.n < 0 {
+		return 0, errQuotaExceeded
+	}
+	// one more byte tells if the quota is exceeded
+	if int64(len(p)) > q.n+1 {
+		p = p[:q.n+1]
+	}
+	n, err := q.r.Read(p)
+	q.n -= int64(n)
+	if q.n < 0 {
+		return n - 1, errQuotaExceeded
+	}
+	return n, err
+}
+
+// insufficientStorage responds with 507 Insufficient Storage.
+func insufficientStorage(err error) statusHandler {
+	return statusHandler{
+		code: http.StatusInsufficientStorage,
+		s:    err.Error(),
+	}
+}
+
+type usageInfo struct {
+	Bytes        int64 `json:"bytes"`
+	Objects      int64 `json:"objects"`
+	QuotaBytes   int64 `json:"quotaBytes,omitempty"`
+	QuotaObjects int64 `json:"quotaObjects,omitempty"`
+}
+
+func newUsageInfo(u sql.Usage, q Quota) usageInfo {
+	return usageInfo{Bytes: u.Bytes, Objects: u.Objects, QuotaBytes: q.Bytes, QuotaObjects: q.Objects}
+}
+
+// handleUsage returns the storage used by the tenant and by the caller,
+// along with their quotas.
+func handleUsage(q quotas) http.HandlerFunc {
+	type response struct {
+		Tenant usageInfo  `json:"tenant"`
+		User   *usageInfo `json:"user,omitempty"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		u, err := q.md.TotalUsage(ctx)
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		res := response{Tenant: newUsageInfo(u, q.tenant)}
+		if p, ok := PrincipalOf(ctx); ok {
+			u, err := q.md.Usage(ctx, p.ID)
+			if err != nil {
+				Error(w, r, err)
+				return
+			}
+			user := newUsageInfo(u, q.user)
+			res.User = &user
+		}
+		writeJSON(w, r, http.StatusOK, res)
+	}
+}
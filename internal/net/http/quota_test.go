@@ -0,0 +1,154 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Quota(t *testing.T) {
+	t.Run("Objects", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata, o.Quota = md, Quota{Objects: 1} })), context.Background()
+		id := postTestFile(t, c)
+
+		res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt")
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusInsufficientStorage)
+		is.OK(t, res.Body.Close())
+
+		// files in the trash are counted until purged
+		is.OK(t, md.Trash(ctx, id)) // trash blob
+		res, err = c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt")
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusInsufficientStorage)
+		is.OK(t, res.Body.Close())
+
+		res, err = c.Do(ctx, "DELETE /cloud-storage/trash/"+id.String(), nil, acceptAll)
+		is.OK(t, err) // return purge response
+		is.Equal(t, res.StatusCode, http.StatusNoContent)
+		is.OK(t, res.Body.Close())
+		_ = postTestFile(t, c)
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata, o.Quota = md, Quota{Bytes: 20} })), context.Background()
+		// the length of the body is unknown so the upload is streamed
+		id := postTestFile(t, c)
+
+		res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt")
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusInsufficientStorage)
+		is.OK(t, res.Body.Close())
+
+		// rejected before the file is sent to the bucket
+		res, err = putTestContent(c, "/cloud-storage/files/"+id.String(), "big.txt", "1234567")
+		is.OK(t, err) // return overwrite response
+		is.Equal(t, res.StatusCode, http.StatusInsufficientStorage)
+		is.OK(t, res.Body.Close())
+
+		u, err := md.TotalUsage(ctx)
+		is.OK(t, err) // return usage
+		is.Equal(t, u.Bytes, int64(14))
+		is.Equal(t, u.Objects, int64(1))
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata, o.Quota = md, Quota{Bytes: 20} })), context.Background()
+
+		// both pass the check before their upload, only one fits after it
+		codes := make(chan int, 2)
+		for range 2 {
+			go func() {
+				res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt")
+				if err != nil {
+					codes <- 0
+					return
+				}
+				res.Body.Close()
+				codes <- res.StatusCode
+			}()
+		}
+		got := []int{<-codes, <-codes}
+		slices.Sort(got)
+		is.Equal(t, got, []int{http.StatusOK, http.StatusInsufficientStorage})
+
+		u, err := md.TotalUsage(ctx)
+		is.OK(t, err) // return usage
+		is.Equal(t, u.Bytes, int64(14))
+		is.Equal(t, u.Objects, int64(1))
+	})
+
+	t.Run("Complete", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) {
+			o.Metadata, o.Presigner, o.Quota = md, up.Presigner(), Quota{Objects: 1}
+		})), context.Background()
+		id := postTestFile(t, c)
+
+		// completing a recorded blob over quota must not delete it
+		res, err := c.Do(ctx, "POST /cloud-storage/uploads/"+id.String()+"/complete", strings.NewReader(`{"uploadId": "1", "parts": [{"partNumber": 1, "etag": "a"}]}`), acceptAll)
+		is.OK(t, err) // return complete response
+		is.Equal(t, res.StatusCode, http.StatusConflict)
+		is.OK(t, res.Body.Close())
+
+		res, err = c.Do(ctx, "GET /cloud-storage/files/"+id.String(), nil, acceptAll)
+		is.OK(t, err) // return download response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		is.OK(t, res.Body.Close())
+	})
+
+	t.Run("User", func(t *testing.T) {
+		scopes := []Scope{ScopeFilesRead, ScopeFilesWrite}
+		auth := NewAPIKeys(MapKeyStore{
+			HashKey("alice"): {ID: "alice", Scopes: scopes},
+			HashKey("bob"):   {ID: "bob", Scopes: scopes},
+		})
+		bearer := func(token string) func(*http.Request) {
+			return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+		}
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) {
+			o.Metadata, o.Authenticator, o.UserQuota = md, auth, Quota{Objects: 1}
+		})), context.Background()
+
+		for _, tc := range []struct {
+			token string
+			code  int
+		}{
+			{"alice", http.StatusOK},
+			{"alice", http.StatusInsufficientStorage},
+			{"bob", http.StatusOK},
+		} {
+			res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt", bearer(tc.token))
+			is.OK(t, err) // return upload response
+			is.Equal(t, res.StatusCode, tc.code)
+			is.OK(t, res.Body.Close())
+		}
+
+		res, err := c.Do(ctx, "GET /cloud-storage/usage", nil, bearer("alice"), acceptAll)
+		is.OK(t, err) // return usage response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		type usage struct {
+			Bytes        int64 `json:"bytes"`
+			Objects      int64 `json:"objects"`
+			QuotaObjects int64 `json:"quotaObjects"`
+		}
+		var body struct {
+			Tenant usage `json:"tenant"`
+			User   usage `json:"user"`
+		}
+		is.OK(t, json.NewDecoder(res.Body).Decode(&body)) // decode usage
+		is.OK(t, res.Body.Close())
+		is.Equal(t, body.Tenant, usage{Bytes: 28, Objects: 2})
+		is.Equal(t, body.User, usage{Bytes: 14, Objects: 1, QuotaObjects: 1})
+	})
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"go.adoublef/blob/internal/log"
+)
+
+const (
+	HeaderRequestID = "X-Request-Id"
+
+	// maxRequestIDLen bounds the size of an id chosen by the client.
+	maxRequestIDLen = 128
+)
+
+// RequestIDHandler identifies each request by the X-Request-Id header of the
+// client, or a generated id if it is missing or malformed. The id is stored
+// in the context under [RequestIDKey], added to the logger of the context
+// and echoed in the response.
+func RequestIDHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id := r.Header.Get(HeaderRequestID)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(HeaderRequestID, id)
+
+		ctx = context.WithValue(ctx, RequestIDKey, id)
+		ctx = log.NewContext(ctx, log.FromContext(ctx).With(slog.String("requestId", id)))
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestID returns the id of the request carried by ctx.
+func RequestID(ctx context.Context) (string, bool) {
+	return value[string](ctx, RequestIDKey)
+}
+
+// validRequestID reports whether id is short and printable so it is safe to
+// echo and log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if c := id[i]; c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
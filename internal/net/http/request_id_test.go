@@ -0,0 +1,56 @@
+package http_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_RequestIDHandler(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		for _, tc := range []struct {
+			name string
+			id   string
+			echo bool
+		}{
+			{name: "Client", id: "01J9Z6Q3W6Z8", echo: true},
+			{name: "Missing", id: ""},
+			{name: "Invalid", id: "has spaces"},
+			{name: "TooLong", id: strings.Repeat("a", 129)},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				c, ctx := newTestClient(t, Handler(nil)), context.Background()
+
+				res, err := c.Do(ctx, "GET /live", nil, acceptAll, func(r *http.Request) {
+					if tc.id != "" {
+						r.Header.Set(HeaderRequestID, tc.id)
+					}
+				})
+				is.OK(t, err) // return live response
+				is.OK(t, res.Body.Close())
+				id := res.Header.Get(HeaderRequestID)
+				is.True(t, id != "")
+				is.Equal(t, id == tc.id, tc.echo)
+			})
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		c, ctx := newTestClient(t, Handler(nil)), context.Background()
+
+		res, err := c.Do(ctx, "GET /cloud-storage/files/invalid", nil, acceptAll, func(r *http.Request) {
+			r.Header.Set(HeaderRequestID, "abc123")
+		})
+		is.OK(t, err) // return download response
+		is.Equal(t, res.StatusCode, http.StatusBadRequest)
+		p, err := io.ReadAll(res.Body)
+		is.OK(t, err) // read error body
+		is.OK(t, res.Body.Close())
+		is.True(t, strings.Contains(string(p), "abc123"))
+	})
+}
@@ -0,0 +1,306 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultSignedURLTTL    = 15 * time.Minute
+	DefaultSignedURLMaxTTL = 7 * 24 * time.Hour
+)
+
+// query parameters of a signed url
+const (
+	paramKeyID       = "X-Blob-Key-Id"
+	paramExpires     = "X-Blob-Expires"
+	paramMethod      = "X-Blob-Method"
+	paramIP          = "X-Blob-Ip"
+	paramDisposition = "X-Blob-Disposition"
+	paramTenant      = "X-Blob-Tenant"
+	paramSignature   = "X-Blob-Signature"
+)
+
+var (
+	errSignature = errors.New("invalid signature")
+	errExpired   = errors.New("url has expired")
+)
+
+// URLSigner mints and verifies urls granting access to a blob without other
+// credentials. Urls are signed with HMAC-SHA256 by the current key and can be
+// verified by any of the keys, so that keys can be rotated by adding a new
+// current key and removing the old one once its urls have expired.
+type URLSigner struct {
+	kid  string
+	keys map[string][]byte
+	// BaseURL, if set, is the scheme and host of minted urls. Otherwise the
+	// host of the request minting the url is used, which is chosen by the
+	// client so BaseURL should be set when serving untrusted clients.
+	BaseURL *url.URL
+	// MaxTTL bounds how long a minted url is valid for.
+	MaxTTL time.Duration
+	// Tenant, if set, is bound to minted urls so they are only valid for
+	// the files of the tenant.
+	Tenant string
+}
+
+// NewURLSigner returns a [URLSigner] signing with the key of kid.
+func NewURLSigner(kid string, keys map[string][]byte, opts ...func(*URLSigner)) (*URLSigner, error) {
+	if len(keys[kid]) < sha256.Size {
+		return nil, fmt.Errorf("signing key %q must be at least %d bytes", kid, sha256.Size)
+	}
+	s := &URLSigner{kid: kid, keys: keys, MaxTTL: DefaultSignedURLMaxTTL}
+	for _, o := range opts {
+		o(s)
+	}
+	return s, nil
+}
+
+// SignedURL restricts what a signed url grants.
+type SignedURL struct {
+	// Method is the method the url may be used with. A url for GET can also
+	// be used for HEAD.
+	Method string
+	// Expires is when the url stops being valid.
+	Expires time.Time
+	// IP, if set, is the only client address the url may be used from.
+	IP string
+	// Disposition, if set, is sent as the Content-Disposition of the blob.
+	Disposition string
+}
+
+// Sign adds the restrictions and their signature to the query of u.
+func (s *URLSigner) Sign(u *url.URL, su SignedURL) *url.URL {
+	q := u.Query()
+	q.Set(paramKeyID, s.kid)
+	q.Set(paramExpires, strconv.FormatInt(su.Expires.Unix(), 10))
+	q.Set(paramMethod, su.Method)
+	if su.IP != "" {
+		q.Set(paramIP, su.IP)
+	}
+	if su.Disposition != "" {
+		q.Set(paramDisposition, su.Disposition)
+	}
+	if s.Tenant != "" {
+		q.Set(paramTenant, s.Tenant)
+	}
+	q.Set(paramSignature, s.sign(s.keys[s.kid], u.Path, q))
+	signed := *u
+	signed.RawQuery = q.Encode()
+	return &signed
+}
+
+// Verify checks the signature of a request for a signed url, returning what
+// the url grants.
+func (s *URLSigner) Verify(r *http.Request) (SignedURL, error) {
+	q := r.URL.Query()
+	key, ok := s.keys[q.Get(paramKeyID)]
+	if !ok {
+		return SignedURL{}, errSignature
+	}
+	want := s.sign(key, r.URL.Path, q)
+	if !hmac.Equal([]byte(want), []byte(q.Get(paramSignature))) {
+		return SignedURL{}, errSignature
+	}
+
+	exp, err := strconv.ParseInt(q.Get(paramExpires), 10, 64)
+	if err != nil {
+		return SignedURL{}, errSignature
+	}
+	su := SignedURL{
+		Method:      q.Get(paramMethod),
+		Expires:     time.Unix(exp, 0),
+		IP:          q.Get(paramIP),
+		Disposition: q.Get(paramDisposition),
+	}
+	if time.Now().After(su.Expires) {
+		return su, errExpired
+	}
+	if m := r.Method; m != su.Method && !(m == http.MethodHead && su.Method == http.MethodGet) {
+		return su, fmt.Errorf("url is not valid for %s", m)
+	}
+	if su.IP != "" && su.IP != clientIP(r) {
+		return su, errors.New("url is not valid for this client")
+	}
+	if q.Get(paramTenant) != s.Tenant {
+		return su, errors.New("url is not valid for this tenant")
+	}
+	return su, nil
+}
+
+// sign returns the signature of the path, the restrictions and the version
+// in q.
+func (s *URLSigner) sign(key []byte, path string, q url.Values) string {
+	mac := hmac.New(sha256.New, key)
+	for _, v := range []string{
+		path,
+		q.Get(paramKeyID),
+		q.Get(paramExpires),
+		q.Get(paramMethod),
+		q.Get(paramIP),
+		q.Get(paramDisposition),
+	} {
+		// length prefixed so fields cannot be shifted into one another
+		fmt.Fprintf(mac, "%d:%s\n", len(v), v)
+	}
+	// only signed when set so urls minted before tenants remain valid
+	if v := q.Get(paramTenant); v != "" {
+		fmt.Fprintf(mac, "%d:%s\n", len(v), v)
+	}
+	// the version served is signed so a url for the current content cannot
+	// be used to download an overwritten one, and labelled so it cannot be
+	// mistaken for a tenant
+	if v := q.Get("version"); v != "" {
+		fmt.Fprintf(mac, "version%d:%s\n", len(v), v)
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ParseSigningKeys parses keys formatted as "kid=base64,..." returning the id
+// of the first key, which is the one urls are signed with.
+func ParseSigningKeys(s string) (kid string, keys map[string][]byte, err error) {
+	keys = make(map[string][]byte)
+	for i, kv := range strings.Split(s, ",") {
+		id, b64, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok || id == "" {
+			return "", nil, fmt.Errorf("key %d: want kid=base64", i)
+		}
+		key, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return "", nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		if _, ok := keys[id]; ok {
+			return "", nil, fmt.Errorf("key %q: duplicate id", id)
+		}
+		if i == 0 {
+			kid = id
+		}
+		keys[id] = key
+	}
+	return kid, keys, nil
+}
+
+// clientIP returns the address of the client of the request.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// signedHandler serves requests for signed urls with h after verifying the
+// signature, and other requests with unsigned.
+func signedHandler(s *URLSigner, h, unsigned http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.Query().Has(paramSignature) {
+			unsigned.ServeHTTP(w, r)
+			return
+		}
+		su, err := s.Verify(r)
+		if err != nil {
+			statusHandler{code: http.StatusForbidden, s: err.Error()}.ServeHTTP(w, r)
+			return
+		}
+		if su.Disposition != "" {
+			w.Header().Set("Content-Disposition", su.Disposition)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// handleSignURL mints a signed url for a blob.
+func handleSignURL(s *URLSigner) http.HandlerFunc {
+	var badPathValue = statusHandler{
+		code: http.StatusBadRequest,
+		s:    `path parameter has invalid format`,
+	}
+	var unprocessableEntity = func(format string, v ...any) statusHandler {
+		return statusHandler{
+			code: http.StatusUnprocessableEntity,
+			s:    fmt.Sprintf(format, v...),
+		}
+	}
+
+	type request struct {
+		ExpiresIn   string `json:"expiresIn"`
+		Method      string `json:"method"`
+		IP          string `json:"ip"`
+		Disposition string `json:"disposition"`
+		Version     int    `json:"version"`
+	}
+	type response struct {
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(r.PathValue("file"))
+		if err != nil {
+			badPathValue.ServeHTTP(w, r)
+			return
+		}
+		var req request
+		if err := decodeJSON(w, r, &req); err != nil {
+			unprocessableEntity("failed to decode request: %v", err).ServeHTTP(w, r)
+			return
+		}
+
+		ttl := DefaultSignedURLTTL
+		if req.ExpiresIn != "" {
+			if ttl, err = time.ParseDuration(req.ExpiresIn); err != nil || ttl <= 0 || ttl > s.MaxTTL {
+				unprocessableEntity("expiresIn must be a duration up to %s", s.MaxTTL).ServeHTTP(w, r)
+				return
+			}
+		}
+		switch req.Method {
+		case "":
+			req.Method = http.MethodGet
+		case http.MethodGet, http.MethodHead:
+		default:
+			unprocessableEntity("method must be GET or HEAD").ServeHTTP(w, r)
+			return
+		}
+		if req.IP != "" && net.ParseIP(req.IP) == nil {
+			unprocessableEntity("ip is not an address").ServeHTTP(w, r)
+			return
+		}
+		if req.Version < 0 {
+			unprocessableEntity("version must not be negative").ServeHTTP(w, r)
+			return
+		}
+
+		base := s.BaseURL
+		if base == nil {
+			base = &url.URL{Scheme: "http", Host: r.Host}
+			if r.TLS != nil {
+				base.Scheme = "https"
+			}
+		}
+		su := SignedURL{
+			Method:      req.Method,
+			Expires:     time.Now().Add(ttl).Truncate(time.Second),
+			IP:          req.IP,
+			Disposition: req.Disposition,
+		}
+		u := base.JoinPath("cloud-storage", "files", id.String())
+		if !strings.HasPrefix(u.Path, "/") {
+			u.Path = "/" + u.Path // base without a path
+		}
+		if req.Version > 0 {
+			u.RawQuery = url.Values{"version": {strconv.Itoa(req.Version)}}.Encode()
+		}
+		u = s.Sign(u, su)
+		writeJSON(w, r, http.StatusCreated, response{URL: u.String(), ExpiresAt: su.Expires.UTC()})
+	}
+}
@@ -0,0 +1,190 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_URLSigner(t *testing.T) {
+	keys := map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	}
+	s, err := NewURLSigner("k1", keys)
+	is.OK(t, err) // return signer
+	rotated, err := NewURLSigner("k2", map[string][]byte{"k1": keys["k1"], "k2": keys["k2"]})
+	is.OK(t, err) // return rotated signer
+	retired, err := NewURLSigner("k2", map[string][]byte{"k2": keys["k2"]})
+	is.OK(t, err) // return retired signer
+	tenant, err := NewURLSigner("k1", keys, func(s *URLSigner) { s.Tenant = "acme" })
+	is.OK(t, err) // return tenant signer
+
+	u := &url.URL{Scheme: "https", Host: "blob.example", Path: "/cloud-storage/files/" + uuid.NewString()}
+	versioned := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path, RawQuery: "version=2"}
+	valid := SignedURL{Method: http.MethodGet, Expires: time.Now().Add(time.Minute)}
+	request := func(method, u string, remoteAddr string) *http.Request {
+		r := httptest.NewRequest(method, u, nil)
+		r.RemoteAddr = remoteAddr
+		return r
+	}
+	tamper := func(u *url.URL, k, v string) string {
+		q := u.Query()
+		q.Set(k, v)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	for _, tc := range []struct {
+		name string
+		s    *URLSigner
+		r    *http.Request
+		ok   bool
+	}{
+		{"OK", s, request("GET", s.Sign(u, valid).String(), "192.0.2.1:1234"), true},
+		{"Head", s, request("HEAD", s.Sign(u, valid).String(), "192.0.2.1:1234"), true},
+		{"Rotated", rotated, request("GET", s.Sign(u, valid).String(), "192.0.2.1:1234"), true},
+		{"Retired", retired, request("GET", s.Sign(u, valid).String(), "192.0.2.1:1234"), false},
+		{"Expired", s, request("GET", s.Sign(u, SignedURL{Method: "GET", Expires: time.Now().Add(-time.Second)}).String(), "192.0.2.1:1234"), false},
+		{"Method", s, request("DELETE", s.Sign(u, valid).String(), "192.0.2.1:1234"), false},
+		{"Query", s, request("GET", s.Sign(u, valid).String()+"&x=1", "192.0.2.1:1234"), true},
+		{"Path", s, request("GET", "/cloud-storage/files/"+uuid.NewString()+"?"+s.Sign(u, valid).RawQuery, "192.0.2.1:1234"), false},
+		{"Tampered", s, request("GET", tamper(s.Sign(u, valid), "X-Blob-Expires", "9999999999"), "192.0.2.1:1234"), false},
+		{"Disposition", s, request("GET", tamper(s.Sign(u, valid), "X-Blob-Disposition", "inline"), "192.0.2.1:1234"), false},
+		{"IP", s, request("GET", s.Sign(u, SignedURL{Method: "GET", Expires: valid.Expires, IP: "192.0.2.1"}).String(), "192.0.2.1:1234"), true},
+		{"ErrIP", s, request("GET", s.Sign(u, SignedURL{Method: "GET", Expires: valid.Expires, IP: "192.0.2.1"}).String(), "192.0.2.2:1234"), false},
+		{"Tenant", tenant, request("GET", tenant.Sign(u, valid).String(), "192.0.2.1:1234"), true},
+		{"ErrTenant", s, request("GET", tenant.Sign(u, valid).String(), "192.0.2.1:1234"), false},
+		{"ErrUntenanted", tenant, request("GET", s.Sign(u, valid).String(), "192.0.2.1:1234"), false},
+		{"Version", s, request("GET", s.Sign(versioned, valid).String(), "192.0.2.1:1234"), true},
+		{"ErrVersion", s, request("GET", tamper(s.Sign(u, valid), "version", "1"), "192.0.2.1:1234"), false},
+		{"ErrVersionTampered", s, request("GET", tamper(s.Sign(versioned, valid), "version", "1"), "192.0.2.1:1234"), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.s.Verify(tc.r)
+			is.Equal(t, err == nil, tc.ok)
+		})
+	}
+
+	t.Run("ErrShortKey", func(t *testing.T) {
+		_, err := NewURLSigner("k1", map[string][]byte{"k1": []byte("short")})
+		is.True(t, err != nil) // reject short key
+	})
+}
+
+func Test_ParseSigningKeys(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		kid, keys, err := ParseSigningKeys("k2=AgI=, k1=AQE=")
+		is.OK(t, err) // parse keys
+		is.Equal(t, kid, "k2")
+		is.Equal(t, len(keys), 2)
+		is.Equal(t, keys["k1"], []byte{1, 1})
+	})
+
+	t.Run("Err", func(t *testing.T) {
+		for _, s := range []string{"", "k1", "=AQE=", "k1=!", "k1=AQE=,k1=AgI="} {
+			_, _, err := ParseSigningKeys(s)
+			is.True(t, err != nil) // invalid keys
+		}
+	})
+}
+
+func Test_handleSignURL(t *testing.T) {
+	keys := MapKeyStore{
+		HashKey("reader"): {ID: "reader", Scopes: []Scope{ScopeFilesRead}},
+		HashKey("writer"): {ID: "writer", Scopes: []Scope{ScopeFilesWrite}},
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	signer, err := NewURLSigner("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	is.OK(t, err) // return signer
+
+	h := Handler(newTestUploader(t), func(o *Options) {
+		o.Authenticator = NewAPIKeys(keys)
+		o.URLSigner = signer
+	})
+	c, ctx := newTestClient(t, h), context.Background()
+
+	res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt", bearer("writer"))
+	is.OK(t, err) // return upload response
+	is.Equal(t, res.StatusCode, http.StatusOK)
+	var completed struct {
+		ID uuid.UUID `json:"resourceId"`
+	}
+	is.OK(t, json.NewDecoder(res.Body).Decode(&completed)) // decode upload
+	is.OK(t, res.Body.Close())
+
+	mint := func(t *testing.T, body string, token string) (*http.Response, error) {
+		return c.Do(ctx, "POST /cloud-storage/files/"+completed.ID.String()+"/signed-urls", bytes.NewBufferString(body), bearer(token), acceptAll)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		res, err := mint(t, `{"expiresIn": "1m", "disposition": "attachment; filename=\"hello.txt\""}`, "reader")
+		is.OK(t, err) // return signed url
+		is.Equal(t, res.StatusCode, http.StatusCreated)
+		var signed struct {
+			URL       string    `json:"url"`
+			ExpiresAt time.Time `json:"expiresAt"`
+		}
+		is.OK(t, json.NewDecoder(res.Body).Decode(&signed)) // decode signed url
+		is.OK(t, res.Body.Close())
+		is.True(t, time.Until(signed.ExpiresAt) <= time.Minute)
+
+		get := func(u string) (*http.Response, error) {
+			r, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+			if err != nil {
+				return nil, err
+			}
+			acceptAll(r)
+			return c.Client.Do(r)
+		}
+		res, err = get(signed.URL)
+		is.OK(t, err) // return download response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		is.Equal(t, res.Header.Get("Content-Disposition"), `attachment; filename="hello.txt"`)
+		is.Equal(t, res.Header.Get("X-Content-Type-Options"), "nosniff")
+		is.OK(t, res.Body.Close())
+
+		res, err = get(signed.URL + "0")
+		is.OK(t, err) // return tampered download response
+		is.Equal(t, res.StatusCode, http.StatusForbidden)
+		is.OK(t, res.Body.Close())
+	})
+
+	t.Run("ErrUnsigned", func(t *testing.T) {
+		res, err := c.Do(ctx, "GET /cloud-storage/files/"+completed.ID.String(), nil, acceptAll)
+		is.OK(t, err) // return download response
+		is.Equal(t, res.StatusCode, http.StatusUnauthorized)
+		is.OK(t, res.Body.Close())
+	})
+
+	for _, tc := range []struct {
+		name  string
+		body  string
+		token string
+		code  int
+	}{
+		{"ErrScope", `{}`, "writer", http.StatusForbidden},
+		{"ErrExpiresIn", `{"expiresIn": "720h"}`, "reader", http.StatusUnprocessableEntity},
+		{"ErrMethod", `{"method": "PUT"}`, "reader", http.StatusUnprocessableEntity},
+		{"ErrIP", `{"ip": "localhost"}`, "reader", http.StatusUnprocessableEntity},
+		{"ErrVersion", `{"version": -1}`, "reader", http.StatusUnprocessableEntity},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := mint(t, tc.body, tc.token)
+			is.OK(t, err) // return signed url response
+			is.Equal(t, res.StatusCode, tc.code)
+			is.OK(t, res.Body.Close())
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var tenantID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// TenantOf returns the id of the tenant a request was routed to, which is
+// empty for the default tenant.
+func TenantOf(ctx context.Context) (string, bool) {
+	return value[string](ctx, TenantKey)
+}
+
+// Tenants routes each request to the handler of its tenant, so that tenants
+// are served by their own storage and metadata and cannot reach the files of
+// one another.
+//
+// The tenant is resolved from the host of the request, otherwise from the
+// caller presenting a bearer token, otherwise from the tenant bound to a
+// signed url for a download, which is verified by the handler of the tenant. Requests that
+// resolve to no tenant are served by the default tenant. A caller that does
+// not belong to the tenant of the host is rejected with 403 Forbidden.
+type Tenants struct {
+	a        Authenticator
+	handlers map[string]http.Handler
+	hosts    map[string]string
+}
+
+// NewTenants returns [Tenants] serving the default tenant with h. Callers are
+// authenticated by a, which may be nil if requests are not authenticated, in
+// which case any client can reach a tenant by sending one of its hosts.
+func NewTenants(a Authenticator, h http.Handler) *Tenants {
+	return &Tenants{
+		a:        a,
+		handlers: map[string]http.Handler{"": h},
+		hosts:    make(map[string]string),
+	}
+}
+
+// Add registers the handler of a tenant and the hostnames it is served at.
+func (t *Tenants) Add(id string, hosts []string, h http.Handler) error {
+	if !tenantID.MatchString(id) {
+		return fmt.Errorf("tenant %q must match %s", id, tenantID)
+	}
+	if _, ok := t.handlers[id]; ok {
+		return fmt.Errorf("tenant %q: duplicate id", id)
+	}
+	for _, host := range hosts {
+		host = strings.ToLower(host)
+		if other, ok := t.hosts[host]; ok {
+			return fmt.Errorf("tenant %q: host %q belongs to tenant %q", id, host, other)
+		}
+		t.hosts[host] = id
+	}
+	t.handlers[id] = h
+	return nil
+}
+
+// ServeHTTP implements [http.Handler].
+func (t *Tenants) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var forbidden = statusHandler{
+		code: http.StatusForbidden,
+		s:    `caller does not belong to the tenant`,
+	}
+	var notFound = statusHandler{
+		code: http.StatusNotFound,
+		s:    `tenant does not exist`,
+	}
+
+	ctx := r.Context()
+	id, byHost := t.hosts[hostname(r.Host)]
+	p, err := t.authenticate(r)
+	if err != nil {
+		Error(w, r, err)
+		return
+	}
+	switch {
+	case p != nil && byHost && p.Tenant != id:
+		forbidden.ServeHTTP(w, r)
+		return
+	case p != nil:
+		id = p.Tenant
+		// not authenticated again by the handler of the tenant
+		ctx = context.WithValue(ctx, PrincipalKey, p)
+	case !byHost && isSigned(r):
+		id = r.URL.Query().Get(paramTenant)
+	}
+	h, ok := t.handlers[id]
+	if !ok && p != nil {
+		forbidden.ServeHTTP(w, r)
+		return
+	}
+	if !ok {
+		notFound.ServeHTTP(w, r)
+		return
+	}
+	h.ServeHTTP(w, r.WithContext(context.WithValue(ctx, TenantKey, id)))
+}
+
+// authenticate returns the caller presenting a bearer token, or nil if there
+// is none or it is invalid, which is left for the handler of the tenant to
+// reject.
+func (t *Tenants) authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if t.a == nil || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, nil
+	}
+	p, err := t.a.Authenticate(r.Context(), strings.TrimSpace(token))
+	if errors.Is(err, ErrUnauthenticated) {
+		return nil, nil
+	}
+	return p, err
+}
+
+// isSigned reports whether the request is for a signed url, which names the
+// tenant in its query. Other requests cannot pick their tenant that way.
+func isSigned(r *http.Request) bool {
+	return (r.Method == http.MethodGet || r.Method == http.MethodHead) && r.URL.Query().Has(paramSignature)
+}
+
+// hostname returns the host without its port, in lower case.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
@@ -0,0 +1,111 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/os"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Tenants(t *testing.T) {
+	scopes := []Scope{ScopeFilesRead, ScopeFilesWrite}
+	auth := NewAPIKeys(MapKeyStore{
+		HashKey("default"): {ID: "default", Scopes: scopes},
+		HashKey("acme"):    {ID: "acme", Scopes: scopes, Tenant: "acme"},
+		HashKey("ghost"):   {ID: "ghost", Scopes: scopes, Tenant: "ghost"},
+	})
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	host := func(host string) func(*http.Request) {
+		return func(r *http.Request) { r.Host = host }
+	}
+
+	up := newTestUploader(t)
+	acme := os.New(up.bucket, up.s3, func(c *os.Client) { c.Namespace = "acme" })
+	tenants := NewTenants(auth, Handler(up, func(o *Options) { o.Authenticator = auth }))
+	err := tenants.Add("acme", []string{"acme.blob.example"}, Handler(acme, func(o *Options) { o.Authenticator = auth }))
+	is.OK(t, err) // add tenant
+	c, ctx := newTestClient(t, tenants), context.Background()
+
+	res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt", bearer("acme"))
+	is.OK(t, err) // return upload response
+	is.Equal(t, res.StatusCode, http.StatusOK)
+	var completed struct {
+		ID uuid.UUID `json:"resourceId"`
+	}
+	is.OK(t, json.NewDecoder(res.Body).Decode(&completed)) // decode upload
+	is.OK(t, res.Body.Close())
+	file := "GET /cloud-storage/files/" + completed.ID.String()
+
+	for _, tc := range []struct {
+		name string
+		opts []func(*http.Request)
+		code int
+	}{
+		{"OK", []func(*http.Request){bearer("acme")}, http.StatusOK},
+		{"Host", []func(*http.Request){bearer("acme"), host("acme.blob.example:443")}, http.StatusOK},
+		{"ErrTenant", []func(*http.Request){bearer("default")}, http.StatusNotFound},
+		{"ErrHost", []func(*http.Request){bearer("default"), host("acme.blob.example")}, http.StatusForbidden},
+		{"ErrUnknown", []func(*http.Request){bearer("ghost")}, http.StatusForbidden},
+		{"ErrAnonymous", []func(*http.Request){host("acme.blob.example")}, http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := c.Do(ctx, file, nil, append(tc.opts, acceptAll)...)
+			is.OK(t, err) // return download response
+			is.Equal(t, res.StatusCode, tc.code)
+			is.OK(t, res.Body.Close())
+		})
+	}
+
+	t.Run("ErrQuery", func(t *testing.T) {
+		anonymous := NewTenants(nil, Handler(up))
+		is.OK(t, anonymous.Add("acme", []string{"acme.blob.example"}, Handler(acme))) // add tenant
+		c := newTestClient(t, anonymous)
+
+		res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt", host("acme.blob.example"))
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		var completed struct {
+			ID uuid.UUID `json:"resourceId"`
+		}
+		is.OK(t, json.NewDecoder(res.Body).Decode(&completed)) // decode upload
+		is.OK(t, res.Body.Close())
+
+		// only signed urls name their tenant in the query
+		for _, pattern := range []string{"GET", "DELETE"} {
+			res, err = c.Do(ctx, pattern+" /cloud-storage/files/"+completed.ID.String()+"?X-Blob-Tenant=acme", nil, acceptAll)
+			is.OK(t, err) // return response
+			is.Equal(t, res.StatusCode, http.StatusNotFound)
+			is.OK(t, res.Body.Close())
+		}
+	})
+
+	t.Run("ErrAdd", func(t *testing.T) {
+		for _, tc := range []struct {
+			id    string
+			hosts []string
+		}{
+			{"Acme", nil},
+			{"acme", nil},
+			{"other", []string{"ACME.blob.example"}},
+		} {
+			is.True(t, tenants.Add(tc.id, tc.hosts, http.NotFoundHandler()) != nil) // reject tenant
+		}
+	})
+}
+
+func Test_MaxFileSize(t *testing.T) {
+	up := newTestUploader(t)
+	c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.MaxFileSize = 4 })), context.Background()
+
+	res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt")
+	is.OK(t, err) // return upload response
+	is.Equal(t, res.StatusCode, http.StatusRequestEntityTooLarge)
+	is.OK(t, res.Body.Close())
+}
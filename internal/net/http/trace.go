@@ -0,0 +1,93 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "go.adoublef/blob/internal/net/http"
+
+// attributes of the blob being transferred
+var (
+	blobIDKey    = attribute.Key("blob.id")
+	blobSizeKey  = attribute.Key("blob.size")
+	blobTypeKey  = attribute.Key("blob.content_type")
+	blobOwnerKey = attribute.Key("blob.owner")
+)
+
+// traceHandler starts a span named after the route pattern for each request,
+// continuing the trace of the caller from its traceparent header.
+func traceHandler(tp trace.TracerProvider, pattern string, h http.Handler) http.Handler {
+	t := tp.Tracer(tracerName)
+	prop := propagation.TraceContext{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := prop.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := t.Start(ctx, pattern,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(pattern),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(ctx))
+		span.SetAttributes(semconv.HTTPResponseStatusCode(sw.code))
+		if sw.code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.code))
+		}
+	})
+}
+
+// startSpan starts a child of the span of the request.
+func startSpan(ctx context.Context, name string, kv ...attribute.KeyValue) (context.Context, trace.Span) {
+	t := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	return t.Start(ctx, name, trace.WithAttributes(kv...))
+}
+
+// endSpan records err, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// statusWriter records the status code and size of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	n           int64
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
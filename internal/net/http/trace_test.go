@@ -0,0 +1,50 @@
+package http_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func Test_traceHandler(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		exp := tracetest.NewInMemoryExporter()
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+		c, ctx := newTestClient(t, Handler(newTestUploader(t), func(o *Options) { o.TracerProvider = tp })), context.Background()
+
+		// continue the trace of the caller
+		const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		res, err := c.PostFile(ctx, "POST /cloud-storage/files", "testdata/hello.txt", func(r *http.Request) {
+			r.Header.Set("traceparent", traceparent)
+		})
+		is.OK(t, err) // return upload response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		is.OK(t, res.Body.Close())
+
+		spans := make(map[string]tracetest.SpanStub)
+		for _, s := range exp.GetSpans() {
+			spans[s.Name] = s
+		}
+		root, ok := spans["POST /cloud-storage/files"]
+		is.True(t, ok) // request span
+		is.Equal(t, root.SpanKind, trace.SpanKindServer)
+		is.Equal(t, root.SpanContext.TraceID().String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+		is.Equal(t, root.Parent.SpanID().String(), "00f067aa0ba902b7")
+		for _, name := range []string{"multipart.NextPart", "Upload"} {
+			s, ok := spans[name]
+			is.True(t, ok) // child span
+			is.Equal(t, s.Parent.SpanID(), root.SpanContext.SpanID())
+		}
+		var id bool
+		for _, kv := range spans["Upload"].Attributes {
+			id = id || (kv.Key == "blob.id" && kv.Value.AsString() != "")
+		}
+		is.True(t, id) // upload has blob id
+	})
+}
@@ -0,0 +1,126 @@
+package http
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// handleDeleteCloudStorage moves a blob to the trash, from where it can be
+// restored until it is purged.
+func handleDeleteCloudStorage(md Metadata) http.HandlerFunc {
+	var badPathValue = statusHandler{
+		code: http.StatusBadRequest,
+		s:    `path parameter has invalid format`,
+	}
+	var notFound = statusHandler{
+		code: http.StatusNotFound,
+		s:    `file does not exist`,
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := uuid.Parse(r.PathValue("file"))
+		if err != nil {
+			badPathValue.ServeHTTP(w, r)
+			return
+		}
+		logAttrs(ctx, slog.String("blobId", id.String()))
+		err = md.Trash(ctx, id)
+		if errors.Is(err, fs.ErrNotExist) {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleRestore takes a blob out of the trash under its original id.
+func handleRestore(md Metadata) http.HandlerFunc {
+	var badPathValue = statusHandler{
+		code: http.StatusBadRequest,
+		s:    `path parameter has invalid format`,
+	}
+	var notFound = statusHandler{
+		code: http.StatusNotFound,
+		s:    `file is not in the trash`,
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := uuid.Parse(r.PathValue("file"))
+		if err != nil {
+			badPathValue.ServeHTTP(w, r)
+			return
+		}
+		logAttrs(ctx, slog.String("blobId", id.String()))
+		b, err := md.Restore(ctx, id)
+		if errors.Is(err, fs.ErrNotExist) {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, newBlobInfo(b))
+	}
+}
+
+// handlePurge deletes a blob in the trash without waiting for its retention
+// to end. The content is deleted before the metadata so a failure can be
+// retried.
+func handlePurge(d Deleter, md Metadata) http.HandlerFunc {
+	var badPathValue = statusHandler{
+		code: http.StatusBadRequest,
+		s:    `path parameter has invalid format`,
+	}
+	var notFound = statusHandler{
+		code: http.StatusNotFound,
+		s:    `file is not in the trash`,
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := uuid.Parse(r.PathValue("file"))
+		if err != nil {
+			badPathValue.ServeHTTP(w, r)
+			return
+		}
+		logAttrs(ctx, slog.String("blobId", id.String()))
+		b, err := md.Blob(ctx, id)
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && !b.Trashed()) {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+
+		ctx, span := startSpan(ctx, "Purge", blobIDKey.String(id.String()), blobSizeKey.Int64(b.Size))
+		err = d.Delete(ctx, id)
+		if err == nil {
+			if err = md.Delete(ctx, id); errors.Is(err, fs.ErrNotExist) {
+				// purged by the reaper in the meantime
+				err = nil
+			}
+		}
+		endSpan(span, err)
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,118 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Trash(t *testing.T) {
+	t.Run("Restore", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata = md })), context.Background()
+		id := postTestFile(t, c)
+		file, trash := "/cloud-storage/files/"+id.String(), "/cloud-storage/trash/"+id.String()
+
+		for _, tc := range []struct {
+			pattern string
+			code    int
+		}{
+			{"DELETE " + file, http.StatusNoContent},
+			{"DELETE " + file, http.StatusNotFound},
+			{"GET " + file, http.StatusNotFound},
+			{"GET " + file + "/metadata", http.StatusNotFound},
+			{"POST " + trash + "/restore", http.StatusOK},
+			{"POST " + trash + "/restore", http.StatusNotFound},
+			{"GET " + file, http.StatusOK},
+		} {
+			res, err := c.Do(ctx, tc.pattern, nil, acceptAll)
+			is.OK(t, err) // return response
+			is.Equal(t, res.StatusCode, tc.code)
+			is.OK(t, res.Body.Close())
+		}
+	})
+
+	t.Run("ErrMetadata", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata = md })), context.Background()
+		id := postTestFile(t, c)
+		is.OK(t, md.Trash(ctx, id)) // trash blob
+
+		for _, method := range []string{"PUT", "PATCH"} {
+			res, err := c.Do(ctx, method+" /cloud-storage/files/"+id.String()+"/metadata", strings.NewReader(`{"a": "1"}`), acceptAll)
+			is.OK(t, err) // return metadata response
+			is.Equal(t, res.StatusCode, http.StatusNotFound)
+			is.OK(t, res.Body.Close())
+		}
+
+		b, err := md.Restore(ctx, id)
+		is.OK(t, err)               // restore blob
+		is.Equal(t, len(b.Tags), 0) // tags are unchanged while trashed
+	})
+
+	t.Run("List", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata = md })), context.Background()
+		id := postTestFile(t, c)
+		_ = postTestFile(t, c)
+		is.OK(t, md.Trash(ctx, id)) // trash blob
+
+		for pattern, want := range map[string]int{"GET /cloud-storage/files": 1, "GET /cloud-storage/trash": 1} {
+			res, err := c.Do(ctx, pattern, nil, acceptAll)
+			is.OK(t, err) // return list response
+			var list struct {
+				Files []struct {
+					ID        uuid.UUID `json:"resourceId"`
+					DeletedAt string    `json:"deletedAt"`
+				} `json:"files"`
+			}
+			is.OK(t, json.NewDecoder(res.Body).Decode(&list)) // decode list
+			is.OK(t, res.Body.Close())
+			is.Equal(t, len(list.Files), want)
+			is.Equal(t, list.Files[0].ID == id, list.Files[0].DeletedAt != "")
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata = md })), context.Background()
+		id := postTestFile(t, c)
+
+		res, err := c.Do(ctx, "DELETE /cloud-storage/trash/"+id.String(), nil, acceptAll)
+		is.OK(t, err) // return purge response
+		is.Equal(t, res.StatusCode, http.StatusNotFound)
+		is.OK(t, res.Body.Close())
+
+		is.OK(t, md.Trash(ctx, id)) // trash blob
+		res, err = c.Do(ctx, "DELETE /cloud-storage/trash/"+id.String(), nil, acceptAll)
+		is.OK(t, err) // return purge response
+		is.Equal(t, res.StatusCode, http.StatusNoContent)
+		is.OK(t, res.Body.Close())
+
+		_, _, _, err = up.Download(ctx, id)
+		is.NotOK(t, err, fs.ErrNotExist)
+		_, err = md.Blob(ctx, id)
+		is.NotOK(t, err, fs.ErrNotExist)
+	})
+}
+
+// postTestFile uploads the test file, returning its id.
+func postTestFile(tb testing.TB, c *TestClient) uuid.UUID {
+	tb.Helper()
+	res, err := c.PostFile(context.Background(), "POST /cloud-storage/files", "testdata/hello.txt")
+	is.OK(tb, err) // return upload response
+	is.Equal(tb, res.StatusCode, http.StatusOK)
+	var completed struct {
+		ID uuid.UUID `json:"resourceId"`
+	}
+	is.OK(tb, json.NewDecoder(res.Body).Decode(&completed)) // decode upload
+	is.OK(tb, res.Body.Close())
+	return completed.ID
+}
@@ -0,0 +1,297 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.adoublef/blob/internal/database/sql"
+	"go.adoublef/blob/internal/log"
+)
+
+// HeaderVersion is the version of a downloaded file.
+const HeaderVersion = "X-Blob-Version"
+
+// Versioner stores the content of each version of a blob, where the first
+// version has a nil id.
+type Versioner interface {
+	UploadVersion(ctx context.Context, id uuid.UUID, r io.Reader, size int64) (vid uuid.UUID, sz int64, err error)
+	DownloadVersion(ctx context.Context, id, vid uuid.UUID) (rc io.ReadCloser, sz int64, etag string, err error)
+	DeleteVersion(ctx context.Context, id, vid uuid.UUID) error
+}
+
+// parseVersion returns the version of the query, or zero for the current.
+func parseVersion(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("version")
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 1 {
+		return 0, errors.New("version must be a positive integer")
+	}
+	return v, nil
+}
+
+// handleOverwriteCloudStorage replaces the content of a blob with a new
+// version, keeping the previous ones. Files larger than maxSize are rejected
+// if it is positive, as are those exceeding the quotas of the owner of the
+// blob.
+func handleOverwriteCloudStorage(v Versioner, md Metadata, m *Metrics, maxSize int64, q quotas) http.HandlerFunc {
+	var badPathValue = statusHandler{
+		code: http.StatusBadRequest,
+		s:    `path parameter has invalid format`,
+	}
+	var notFound = statusHandler{
+		code: http.StatusNotFound,
+		s:    `file does not exist`,
+	}
+	var gone = statusHandler{
+		code: http.StatusGone,
+		s:    `file has expired`,
+	}
+	var unsupportedMediaType = statusHandler{
+		code: http.StatusUnsupportedMediaType,
+		s:    `request is not a mulitpart/form`,
+	}
+	var unprocessableEntity = func(format string, v ...any) statusHandler {
+		return statusHandler{
+			code: http.StatusUnprocessableEntity,
+			s:    fmt.Sprintf(format, v...),
+		}
+	}
+	var tooLarge = statusHandler{
+		code: http.StatusRequestEntityTooLarge,
+		s:    fmt.Sprintf("file is larger than %d bytes", maxSize),
+	}
+
+	type completed struct {
+		ID      string `json:"resourceId"`
+		Version int    `json:"version"`
+		Size    int64  `json:"bytesWritten"`
+		Elapsed string `json:"timeElapsed"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		start := time.Now()
+
+		hint, ok := sizeHint(r, maxSize)
+		if !ok {
+			tooLarge.ServeHTTP(w, r)
+			return
+		}
+		id, err := uuid.Parse(r.PathValue("file"))
+		if err != nil {
+			badPathValue.ServeHTTP(w, r)
+			return
+		}
+		logAttrs(ctx, slog.String("blobId", id.String()))
+		// checked before the content is sent to the bucket
+		b, err := md.Blob(ctx, id)
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && b.Trashed()) {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		if b.Expired(start) {
+			gone.ServeHTTP(w, r)
+			return
+		}
+		// the request body is larger than the part so this errs on rejecting
+		left, err := q.admit(ctx, b.Owner, 0, r.ContentLength)
+		if errors.Is(err, errQuotaExceeded) {
+			insufficientStorage(err).ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+
+		mr, err := r.MultipartReader()
+		if err != nil {
+			unsupportedMediaType.ServeHTTP(w, r)
+			return
+		}
+		part, _, err := nextFilePart(mr)
+		if err != nil {
+			unprocessableEntity("failed to decode part: %v", err).ServeHTTP(w, r)
+			return
+		}
+		defer part.Close()
+		contentType := part.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		uctx, span := startSpan(ctx, "UploadVersion", blobIDKey.String(id.String()), blobTypeKey.String(contentType))
+		done := m.transfer(directionUp)
+		sum := sha256.New()
+		vid, sz, err := v.UploadVersion(uctx, id, io.TeeReader(limitQuota(limitFile(w, part, maxSize), left), sum), hint)
+		done(sz)
+		if err == nil {
+			b, err = md.AddVersion(uctx, sql.Blob{
+				ID:          id,
+				VersionID:   vid,
+				Filename:    part.FileName(),
+				ContentType: contentType,
+				Size:        sz,
+				SHA256:      hex.EncodeToString(sum.Sum(nil)),
+				CreatedAt:   time.Now(),
+			}, q.limits())
+			if err != nil {
+				// the client may have gone away
+				if err := v.DeleteVersion(context.WithoutCancel(uctx), id, vid); err != nil {
+					log.FromContext(ctx).WarnContext(ctx, "failed to delete version without metadata", slog.String("versionId", vid.String()), slog.Any("err", err))
+				}
+			}
+		}
+		if err == nil {
+			span.SetAttributes(blobSizeKey.Int64(sz))
+			logAttrs(ctx, slog.Int("blobVersion", b.Version), slog.Int64("blobSize", sz))
+		}
+		if p, ok := PrincipalOf(ctx); ok {
+			span.SetAttributes(blobOwnerKey.String(p.ID))
+		}
+		if mbe := (*http.MaxBytesError)(nil); errors.As(err, &mbe) {
+			endSpan(span, nil)
+			tooLarge.ServeHTTP(w, r)
+			return
+		}
+		if errors.Is(err, errQuotaExceeded) {
+			endSpan(span, nil)
+			insufficientStorage(err).ServeHTTP(w, r)
+			return
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			// trashed while uploading
+			endSpan(span, nil)
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		endSpan(span, err)
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, completed{
+			ID:      id.String(),
+			Version: b.Version,
+			Size:    sz,
+			Elapsed: time.Since(start).String(),
+		})
+	}
+}
+
+type versionInfo struct {
+	Version     int       `json:"version"`
+	Filename    string    `json:"filename,omitempty"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Current     bool      `json:"current"`
+}
+
+// handleListVersions lists the versions of a blob, oldest first.
+func handleListVersions(md Metadata) http.HandlerFunc {
+	var badPathValue = statusHandler{
+		code: http.StatusBadRequest,
+		s:    `path parameter has invalid format`,
+	}
+	var notFound = statusHandler{
+		code: http.StatusNotFound,
+		s:    `file does not exist`,
+	}
+
+	type response struct {
+		Versions []versionInfo `json:"versions"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := uuid.Parse(r.PathValue("file"))
+		if err != nil {
+			badPathValue.ServeHTTP(w, r)
+			return
+		}
+		logAttrs(ctx, slog.String("blobId", id.String()))
+		b, err := md.Blob(ctx, id)
+		var vs []sql.Blob
+		if err == nil && !b.Trashed() {
+			vs, err = md.Versions(ctx, id)
+		}
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && b.Trashed()) {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		res := response{Versions: make([]versionInfo, len(vs))}
+		for i, v := range vs {
+			res.Versions[i] = versionInfo{
+				Version:     v.Version,
+				Filename:    v.Filename,
+				ContentType: v.ContentType,
+				Size:        v.Size,
+				SHA256:      v.SHA256,
+				CreatedAt:   v.CreatedAt.UTC(),
+				Current:     v.Version == b.Version,
+			}
+		}
+		writeJSON(w, r, http.StatusOK, res)
+	}
+}
+
+// handleRestoreVersion makes a previous version of a blob current. Later
+// versions are kept, so a restore can be undone.
+func handleRestoreVersion(md Metadata) http.HandlerFunc {
+	var badPathValue = statusHandler{
+		code: http.StatusBadRequest,
+		s:    `path parameter has invalid format`,
+	}
+	var notFound = statusHandler{
+		code: http.StatusNotFound,
+		s:    `version does not exist`,
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		id, err := uuid.Parse(r.PathValue("file"))
+		if err != nil {
+			badPathValue.ServeHTTP(w, r)
+			return
+		}
+		version, err := strconv.Atoi(r.PathValue("version"))
+		if err != nil || version < 1 {
+			badPathValue.ServeHTTP(w, r)
+			return
+		}
+		logAttrs(ctx, slog.String("blobId", id.String()), slog.Int("blobVersion", version))
+		b, err := md.SetVersion(ctx, id, version)
+		if errors.Is(err, fs.ErrNotExist) {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			Error(w, r, err)
+			return
+		}
+		writeJSON(w, r, http.StatusOK, newBlobInfo(b))
+	}
+}
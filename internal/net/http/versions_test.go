@@ -0,0 +1,132 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+	. "go.adoublef/blob/internal/net/http"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Versions(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata = md })), context.Background()
+		id := postTestFile(t, c)
+		file := "/cloud-storage/files/" + id.String()
+
+		res, err := putTestContent(c, file, "goodbye.txt", "goodbye, world!\n")
+		is.OK(t, err) // return overwrite response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		var completed struct {
+			ID      uuid.UUID `json:"resourceId"`
+			Version int       `json:"version"`
+		}
+		is.OK(t, json.NewDecoder(res.Body).Decode(&completed)) // decode overwrite
+		is.OK(t, res.Body.Close())
+		is.Equal(t, completed.ID, id)
+		is.Equal(t, completed.Version, 2)
+
+		for _, tc := range []struct {
+			query, body, version string
+		}{
+			{"", "goodbye, world!\n", "2"},
+			{"?version=1", "hello, world!\n", "1"},
+			{"?version=2", "goodbye, world!\n", "2"},
+		} {
+			res, err := c.Do(ctx, "GET "+file+tc.query, nil, acceptAll)
+			is.OK(t, err) // return download response
+			is.Equal(t, res.StatusCode, http.StatusOK)
+			is.Equal(t, res.Header.Get(HeaderVersion), tc.version)
+			p, err := io.ReadAll(res.Body)
+			is.OK(t, err) // read download
+			is.OK(t, res.Body.Close())
+			is.Equal(t, string(p), tc.body)
+		}
+
+		res, err = c.Do(ctx, "POST "+file+"/versions/1/restore", nil, acceptAll)
+		is.OK(t, err) // return restore response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		is.OK(t, res.Body.Close())
+
+		res, err = c.Do(ctx, "GET "+file+"/versions", nil, acceptAll)
+		is.OK(t, err) // return versions response
+		is.Equal(t, res.StatusCode, http.StatusOK)
+		var list struct {
+			Versions []struct {
+				Version  int    `json:"version"`
+				Filename string `json:"filename"`
+				Current  bool   `json:"current"`
+			} `json:"versions"`
+		}
+		is.OK(t, json.NewDecoder(res.Body).Decode(&list)) // decode versions
+		is.OK(t, res.Body.Close())
+		is.Equal(t, len(list.Versions), 2)
+		is.Equal(t, list.Versions[0].Filename, "hello.txt")
+		is.True(t, list.Versions[0].Current)
+		is.Equal(t, list.Versions[1].Filename, "goodbye.txt")
+
+		// every version is removed with the blob
+		is.OK(t, up.Delete(ctx, id))
+		for v := range 2 {
+			res, err := c.Do(ctx, "GET "+file+"?version="+strconv.Itoa(v+1), nil, acceptAll)
+			is.OK(t, err) // return download response
+			is.Equal(t, res.StatusCode, http.StatusNotFound)
+			is.OK(t, res.Body.Close())
+		}
+	})
+
+	t.Run("Err", func(t *testing.T) {
+		up, md := newTestUploader(t), newTestMetadata(t)
+		c, ctx := newTestClient(t, Handler(up, func(o *Options) { o.Metadata = md })), context.Background()
+		id := postTestFile(t, c)
+		file := "/cloud-storage/files/" + id.String()
+
+		for _, tc := range []struct {
+			pattern string
+			code    int
+		}{
+			{"GET " + file + "?version=0", http.StatusBadRequest},
+			{"GET " + file + "?version=2", http.StatusNotFound},
+			{"POST " + file + "/versions/2/restore", http.StatusNotFound},
+			{"POST " + file + "/versions/latest/restore", http.StatusBadRequest},
+			{"GET /cloud-storage/files/" + uuid.NewString() + "/versions", http.StatusNotFound},
+		} {
+			res, err := c.Do(ctx, tc.pattern, nil, acceptAll)
+			is.OK(t, err) // return response
+			is.Equal(t, res.StatusCode, tc.code)
+			is.OK(t, res.Body.Close())
+		}
+
+		res, err := putTestContent(c, "/cloud-storage/files/"+uuid.NewString(), "hello.txt", "")
+		is.OK(t, err) // return overwrite response
+		is.Equal(t, res.StatusCode, http.StatusNotFound)
+		is.OK(t, res.Body.Close())
+	})
+}
+
+// putTestContent uploads the content as a new version of the file.
+func putTestContent(c *TestClient, path, filename, content string) (*http.Response, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(fw, content); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return c.Do(context.Background(), "PUT "+path, &body, acceptAll, func(r *http.Request) {
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+	})
+}
@@ -0,0 +1,45 @@
+package os
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// BufferPool provides the buffers that parts are held in.
+type BufferPool interface {
+	// Get returns a buffer of length n.
+	Get(n int64) []byte
+	// Put returns a buffer to the pool. It must not be used afterwards.
+	Put(p []byte)
+}
+
+// NewBufferPool returns a [BufferPool] that reuses buffers of the same
+// power-of-two capacity.
+func NewBufferPool() BufferPool {
+	return &bufferPool{}
+}
+
+type bufferPool struct {
+	m sync.Map // capacity -> *sync.Pool
+}
+
+func (bp *bufferPool) Get(n int64) []byte {
+	c := int64(1) << bits.Len64(uint64(max(n, 1)-1))
+	if p, ok := bp.m.Load(c); ok {
+		if b, ok := p.(*sync.Pool).Get().(*[]byte); ok {
+			return (*b)[:n]
+		}
+	}
+	return make([]byte, n, c)
+}
+
+func (bp *bufferPool) Put(b []byte) {
+	c := int64(cap(b))
+	if c == 0 || c&(c-1) != 0 {
+		// not allocated by the pool
+		return
+	}
+	p, _ := bp.m.LoadOrStore(c, new(sync.Pool))
+	b = b[:0]
+	p.(*sync.Pool).Put(&b)
+}
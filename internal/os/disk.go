@@ -0,0 +1,25 @@
+package os
+
+import (
+	"context"
+	"fmt"
+)
+
+// DiskCheck reports whether the file system holding Path has at least
+// MinFree bytes available.
+type DiskCheck struct {
+	Path    string
+	MinFree uint64
+}
+
+// Check implements the readiness check.
+func (d DiskCheck) Check(ctx context.Context) error {
+	free, err := diskFree(d.Path)
+	if err != nil {
+		return err
+	}
+	if free < d.MinFree {
+		return fmt.Errorf("%s has %d bytes free, want at least %d", d.Path, free, d.MinFree)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin)
+
+package os
+
+import "errors"
+
+func diskFree(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
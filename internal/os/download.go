@@ -2,47 +2,210 @@ package os
 
 import (
 	"context"
+	"fmt"
 	"io"
-	"path"
+	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/uuid"
 )
 
+const (
+	DefaultDownloadPartSize    = 1 << 23 // 8MB
+	DefaultDownloadConcurrency = 4
+)
+
 type Downloader struct {
 	bucket string
-	m      *manager.Downloader // just have this as a global atomic?
+	c      manager.DownloadAPIClient
+	// PartSize is the number of bytes requested by each ranged GetObject.
+	PartSize int64
+	// Concurrency is the number of parts fetched ahead of the reader.
+	// A single download holds at most Concurrency+1 parts in memory.
+	Concurrency int
+	// Buffers provides the memory each part is read into.
+	Buffers BufferPool
+	// Namespace, if set, prefixes the key of each blob.
+	Namespace string
+}
+
+// Download returns the content of the blob along with its size and etag.
+// The object is resolved before returning so a missing blob is reported
+// as [ErrNotExist] rather than as a failed read.
+//
+// The content is fetched in the background until the reader is closed or
+// the context is cancelled.
+func (d *Downloader) Download(ctx context.Context, id uuid.UUID) (rc io.ReadCloser, sz int64, etag string, err error) {
+	return d.DownloadVersion(ctx, id, uuid.Nil)
 }
 
-func (d *Downloader) Download(ctx context.Context, id uuid.UUID) (io.ReadCloser, error) {
-	s := strings.Replace(id.String(), "-", "", 4)
-	uri := path.Join("_blob", s[:2], s[2:4], s[4:])
+// DownloadVersion is like [Downloader.Download] but returns the content of
+// a version of the blob, where the first version has a nil id.
+func (d *Downloader) DownloadVersion(ctx context.Context, id, vid uuid.UUID) (rc io.ReadCloser, sz int64, etag string, err error) {
+	uri := versionKey(d.Namespace, id, vid)
+
+	ctx, cancel := context.WithCancel(ctx)
+	first, err := d.first(ctx, uri)
+	if err != nil {
+		cancel()
+		return nil, 0, "", err
+	}
+	sz, err = objectSize(first)
+	if err != nil {
+		first.Body.Close()
+		cancel()
+		return nil, 0, "", err
+	}
 
 	pr, pw := io.Pipe()
+	// unblock a pending write if the caller goes away without closing
+	stop := context.AfterFunc(ctx, func() { pw.CloseWithError(ctx.Err()) })
 	go func() {
-		defer pw.Close()
-		o := &s3.GetObjectInput{
-			Key:    &uri,
-			Bucket: &d.bucket,
-			// PartNumber *string
-			// Range *string
+		defer cancel()
+		defer stop()
+		// a closed reader fails the next write which stops the download
+		pw.CloseWithError(d.stream(ctx, pw, uri, first, sz))
+	}()
+	return &reader{pr, cancel}, sz, aws.ToString(first.ETag), nil
+}
+
+// first requests the first part of the object.
+func (d *Downloader) first(ctx context.Context, key string) (*s3.GetObjectOutput, error) {
+	in := &s3.GetObjectInput{
+		Bucket: &d.bucket,
+		Key:    &key,
+		Range:  aws.String(byteRange(0, d.PartSize)),
+	}
+	out, err := d.c.GetObject(ctx, in)
+	switch statusCode(err) {
+	case http.StatusNotFound:
+		return nil, ErrNotExist
+	case http.StatusRequestedRangeNotSatisfiable:
+		// only an empty object has no satisfiable range
+		in.Range = nil
+		return d.c.GetObject(ctx, in)
+	}
+	return out, err
+}
+
+// part is a byte range of the object fetched ahead of the reader.
+type part struct {
+	done chan struct{}
+	buf  []byte
+	err  error
+}
+
+// stream writes the object to w in order, fetching up to d.Concurrency parts
+// ahead of the one being written.
+func (d *Downloader) stream(ctx context.Context, w io.Writer, key string, first *s3.GetObjectOutput, total int64) error {
+	defer first.Body.Close()
+	size := d.PartSize
+	// every part must come from the same version of the object
+	etag := first.ETag
+
+	// fetch ahead while the first part is being written
+	queue := make(chan *part, d.Concurrency)
+	go func() {
+		defer close(queue)
+		for off := size; off < total; off += size {
+			p := &part{done: make(chan struct{})}
+			select {
+			case queue <- p:
+			case <-ctx.Done():
+				return
+			}
+			go func(off int64) {
+				defer close(p.done)
+				p.buf, p.err = d.fetch(ctx, key, etag, off, min(size, total-off))
+			}(off)
 		}
-		_, err := d.m.Download(ctx, &writerAt{pw}, o)
+	}()
+	if _, err := io.Copy(w, first.Body); err != nil {
+		return err
+	}
+	for p := range queue {
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if p.err != nil {
+			return p.err
+		}
+		_, err := w.Write(p.buf)
+		d.Buffers.Put(p.buf)
 		if err != nil {
-			pw.CloseWithError(err)
-			return
+			return err
 		}
-	}()
-	return pr, nil
+	}
+	return nil
 }
 
-func NewDownloader(bucket string, c manager.DownloadAPIClient) *Downloader {
-	d := manager.NewDownloader(c, func(u *manager.Downloader) {
-		u.PartSize = 1 << 24 // 16MB
-		// https://dev.to/flowup/using-io-reader-io-writer-in-go-to-stream-data-3i7b
-		u.Concurrency = 1 // force sequential writes
+// fetch reads n bytes of the object starting at off.
+func (d *Downloader) fetch(ctx context.Context, key string, etag *string, off, n int64) ([]byte, error) {
+	out, err := d.c.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  &d.bucket,
+		Key:     &key,
+		Range:   aws.String(byteRange(off, n)),
+		IfMatch: etag,
 	})
-	return &Downloader{bucket, d}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	buf := d.Buffers.Get(n)
+	if _, err := io.ReadFull(out.Body, buf); err != nil {
+		d.Buffers.Put(buf)
+		return nil, fmt.Errorf("failed to read %s: %w", byteRange(off, n), err)
+	}
+	return buf, nil
+}
+
+// reader cancels the download when closed.
+type reader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *reader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
+
+// byteRange returns the Range header value for n bytes starting at off.
+func byteRange(off, n int64) string {
+	return fmt.Sprintf("bytes=%d-%d", off, off+n-1)
+}
+
+// objectSize returns the size of the whole object from a ranged response.
+func objectSize(out *s3.GetObjectOutput) (int64, error) {
+	if out.ContentRange == nil {
+		// the full object was returned
+		return aws.ToInt64(out.ContentLength), nil
+	}
+	// bytes 0-8388607/12582912
+	_, total, ok := strings.Cut(*out.ContentRange, "/")
+	if !ok || total == "*" {
+		return 0, fmt.Errorf("unknown object size in %q", *out.ContentRange)
+	}
+	return strconv.ParseInt(total, 10, 64)
+}
+
+func NewDownloader(bucket string, c manager.DownloadAPIClient, opts ...func(*Downloader)) *Downloader {
+	d := &Downloader{
+		bucket:      bucket,
+		c:           c,
+		PartSize:    DefaultDownloadPartSize,
+		Concurrency: DefaultDownloadConcurrency,
+		Buffers:     NewBufferPool(),
+	}
+	for _, o := range opts {
+		o(d)
+	}
+	return d
 }
@@ -0,0 +1,170 @@
+package os_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	. "go.adoublef/blob/internal/os"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Downloader(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		type testcase struct {
+			size        int
+			partSize    int64
+			concurrency int
+			calls       int64
+		}
+
+		for _, tc := range []testcase{
+			{size: 0, partSize: 1 << 10, concurrency: 3, calls: 2},
+			{size: 1 << 9, partSize: 1 << 10, concurrency: 3, calls: 1},
+			{size: 1 << 10, partSize: 1 << 10, concurrency: 3, calls: 1},
+			{size: 10<<10 + 3, partSize: 1 << 10, concurrency: 3, calls: 11},
+			{size: 10<<10 + 3, partSize: 1 << 10, concurrency: 1, calls: 11},
+		} {
+			c := newObjectClient(t, tc.size)
+			d := NewDownloader("bucket", c, func(d *Downloader) {
+				d.PartSize = tc.partSize
+				d.Concurrency = tc.concurrency
+			})
+
+			rc, sz, etag, err := d.Download(context.Background(), uuid.New())
+			is.OK(t, err) // return download reader
+			is.Equal(t, sz, int64(tc.size))
+			is.Equal(t, etag, c.etag)
+			p, err := io.ReadAll(rc)
+			is.OK(t, err) // read object
+			is.OK(t, rc.Close())
+
+			is.True(t, bytes.Equal(p, c.data))                  // got;want body
+			is.True(t, c.max.Load() <= int64(tc.concurrency)+1) // bounded requests in flight
+			is.Equal(t, c.calls.Load(), tc.calls)               // one request per part
+		}
+	})
+
+	t.Run("ErrNotExist", func(t *testing.T) {
+		c := newObjectClient(t, 0)
+		c.data = nil
+		d := NewDownloader("bucket", c)
+
+		_, _, _, err := d.Download(context.Background(), uuid.New())
+		is.NotOK(t, err, ErrNotExist)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		c := newObjectClient(t, 10<<10)
+		c.block = true
+		d := NewDownloader("bucket", c, func(d *Downloader) { d.PartSize = 1 << 10 })
+
+		rc, _, _, err := d.Download(context.Background(), uuid.New())
+		is.OK(t, err) // return download reader
+		_, err = io.ReadFull(rc, make([]byte, 1<<10))
+		is.OK(t, err) // read first part
+		is.OK(t, rc.Close())
+
+		is.OK(t, c.wait(time.Second)) // pending requests cancelled
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		c := newObjectClient(t, 10<<10)
+		c.block = true
+		d := NewDownloader("bucket", c, func(d *Downloader) { d.PartSize = 1 << 10 })
+
+		ctx, cancel := context.WithCancel(context.Background())
+		rc, _, _, err := d.Download(ctx, uuid.New())
+		is.OK(t, err) // return download reader
+		defer rc.Close()
+		cancel()
+
+		_, err = io.ReadAll(rc)
+		is.NotOK(t, err, context.Canceled)
+		is.OK(t, c.wait(time.Second)) // pending requests cancelled
+	})
+}
+
+type objectClient struct {
+	data []byte
+	etag string
+	// block holds every request after the first until cancelled
+	block    bool
+	inflight atomic.Int64
+	max      atomic.Int64
+	calls    atomic.Int64
+}
+
+func (c *objectClient) GetObject(ctx context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c.calls.Add(1)
+	n := c.inflight.Add(1)
+	defer c.inflight.Add(-1)
+	for m := c.max.Load(); n > m && !c.max.CompareAndSwap(m, n); m = c.max.Load() {
+	}
+
+	if c.data == nil {
+		return nil, statusError(http.StatusNotFound)
+	}
+	if c.block && c.calls.Load() > 1 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if in.IfMatch != nil && *in.IfMatch != c.etag {
+		return nil, statusError(http.StatusPreconditionFailed)
+	}
+	if in.Range == nil {
+		return &s3.GetObjectOutput{
+			Body:          io.NopCloser(bytes.NewReader(c.data)),
+			ContentLength: aws.Int64(int64(len(c.data))),
+			ETag:          &c.etag,
+		}, nil
+	}
+	var start, end int64
+	_, err := fmt.Sscanf(aws.ToString(in.Range), "bytes=%d-%d", &start, &end)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(c.data))
+	if start >= size {
+		return nil, statusError(http.StatusRequestedRangeNotSatisfiable)
+	}
+	end = min(end, size-1)
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(c.data[start : end+1])),
+		ContentLength: aws.Int64(end - start + 1),
+		ContentRange:  aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, size)),
+		ETag:          &c.etag,
+	}, nil
+}
+
+// wait returns an error if requests are still in flight after d.
+func (c *objectClient) wait(d time.Duration) error {
+	for deadline := time.Now().Add(d); c.inflight.Load() > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d requests in flight", c.inflight.Load())
+		}
+	}
+	return nil
+}
+
+func newObjectClient(tb testing.TB, size int) *objectClient {
+	tb.Helper()
+	p := make([]byte, size)
+	_, err := rand.Read(p)
+	is.OK(tb, err) // return random object
+	return &objectClient{data: p, etag: fmt.Sprintf("%q", uuid.NewString())}
+}
+
+type statusError int
+
+func (e statusError) Error() string       { return http.StatusText(int(e)) }
+func (e statusError) HTTPStatusCode() int { return int(e) }
@@ -0,0 +1,112 @@
+package os
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.adoublef/blob/internal/log"
+)
+
+const (
+	DefaultJanitorMaxAge   = 24 * time.Hour
+	DefaultJanitorInterval = time.Hour
+)
+
+type janitorAPIClient interface {
+	ListMultipartUploads(context.Context, *s3.ListMultipartUploadsInput, ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error)
+	AbortMultipartUpload(context.Context, *s3.AbortMultipartUploadInput, ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// Janitor aborts multipart uploads that were never completed, such as those
+// left behind when the server stopped mid-upload.
+type Janitor struct {
+	bucket string
+	c      janitorAPIClient
+	// Namespace, if set, is the namespace of the blobs whose uploads are
+	// swept, as with [Client.Namespace].
+	Namespace string
+	// MaxAge is how long a multipart upload may be in progress before it is
+	// considered stale.
+	MaxAge time.Duration
+	// Interval is the time between sweeps.
+	Interval time.Duration
+}
+
+// Run sweeps the bucket every [Janitor.Interval] until the context is done.
+func (j *Janitor) Run(ctx context.Context) error {
+	t := time.NewTicker(j.Interval)
+	defer t.Stop()
+	for {
+		n, err := j.Sweep(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.FromContext(ctx).ErrorContext(ctx, "failed to sweep multipart uploads", slog.Int("aborted", n), slog.Any("err", err))
+		case n > 0:
+			log.FromContext(ctx).InfoContext(ctx, "swept multipart uploads", slog.Int("aborted", n))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Sweep aborts the multipart uploads of the blobs in the namespace that were
+// initiated more than [Janitor.MaxAge] ago, returning how many were aborted.
+// Uploads that fail to abort do not stop the others from being swept.
+func (j *Janitor) Sweep(ctx context.Context) (n int, err error) {
+	before := time.Now().Add(-j.MaxAge)
+	in := &s3.ListMultipartUploadsInput{
+		Bucket: &j.bucket,
+		Prefix: aws.String(path.Join(j.Namespace, "_blob") + "/"),
+	}
+	var errs []error
+	for {
+		out, err := j.c.ListMultipartUploads(ctx, in)
+		if err != nil {
+			return n, errors.Join(append(errs, err)...)
+		}
+		for _, mu := range out.Uploads {
+			if mu.Initiated == nil || mu.Initiated.After(before) {
+				continue
+			}
+			_, err := j.c.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   &j.bucket,
+				Key:      mu.Key,
+				UploadId: mu.UploadId,
+			})
+			switch {
+			case statusCode(err) == http.StatusNotFound:
+				// the upload completed since it was listed
+			case err != nil:
+				errs = append(errs, err)
+			default:
+				n++
+			}
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			return n, errors.Join(errs...)
+		}
+		in.KeyMarker, in.UploadIdMarker = out.NextKeyMarker, out.NextUploadIdMarker
+	}
+}
+
+func NewJanitor(bucket string, c janitorAPIClient, opts ...func(*Janitor)) *Janitor {
+	j := &Janitor{
+		bucket:   bucket,
+		c:        c,
+		MaxAge:   DefaultJanitorMaxAge,
+		Interval: DefaultJanitorInterval,
+	}
+	for _, o := range opts {
+		o(j)
+	}
+	return j
+}
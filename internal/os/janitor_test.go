@@ -0,0 +1,107 @@
+package os_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	. "go.adoublef/blob/internal/os"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Janitor(t *testing.T) {
+	t.Run("Sweep", func(t *testing.T) {
+		now := time.Now()
+		c := &multipartClient{uploads: []types.MultipartUpload{
+			{Key: aws.String("_blob/01/23/stale"), UploadId: aws.String("1"), Initiated: aws.Time(now.Add(-2 * time.Hour))},
+			{Key: aws.String("_blob/01/23/fresh"), UploadId: aws.String("2"), Initiated: aws.Time(now.Add(-time.Minute))},
+			{Key: aws.String("_blob/45/67/stale"), UploadId: aws.String("3"), Initiated: aws.Time(now.Add(-3 * time.Hour))},
+		}}
+		j := NewJanitor("bucket", c, func(j *Janitor) { j.MaxAge = time.Hour })
+
+		n, err := j.Sweep(context.Background())
+		is.OK(t, err) // sweep stale uploads
+		is.Equal(t, n, 2)
+		is.Equal(t, c.aborted, []string{"1", "3"})
+	})
+
+	t.Run("ErrAbort", func(t *testing.T) {
+		stale := aws.Time(time.Now().Add(-2 * time.Hour))
+		c := &multipartClient{uploads: []types.MultipartUpload{
+			{Key: aws.String("_blob/01/23/failing"), UploadId: aws.String("1"), Initiated: stale},
+			{Key: aws.String("_blob/45/67/stale"), UploadId: aws.String("2"), Initiated: stale},
+		}, failing: "1"}
+		j := NewJanitor("bucket", c, func(j *Janitor) { j.MaxAge = time.Hour })
+
+		n, err := j.Sweep(context.Background())
+		is.True(t, err != nil) // report failed abort
+		is.Equal(t, n, 1)
+		is.Equal(t, c.aborted, []string{"2"}) // later pages are still swept
+	})
+
+	t.Run("Namespace", func(t *testing.T) {
+		stale := aws.Time(time.Now().Add(-2 * time.Hour))
+		c := &multipartClient{uploads: []types.MultipartUpload{
+			{Key: aws.String("_blob/01/23/root"), UploadId: aws.String("1"), Initiated: stale},
+			{Key: aws.String("acme/_blob/01/23/tenant"), UploadId: aws.String("2"), Initiated: stale},
+		}}
+		j := NewJanitor("bucket", c, func(j *Janitor) { j.MaxAge, j.Namespace = time.Hour, "acme" })
+
+		n, err := j.Sweep(context.Background())
+		is.OK(t, err) // sweep uploads of the namespace
+		is.Equal(t, n, 1)
+		is.Equal(t, c.aborted, []string{"2"})
+	})
+}
+
+// multipartClient lists a single upload with the prefix per page.
+type multipartClient struct {
+	mu      sync.Mutex
+	uploads []types.MultipartUpload
+	aborted []string
+	// failing, if set, is the id of an upload that fails to abort
+	failing string
+}
+
+func (c *multipartClient) ListMultipartUploads(ctx context.Context, in *s3.ListMultipartUploadsInput, _ ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var uploads []types.MultipartUpload
+	for _, mu := range c.uploads {
+		if strings.HasPrefix(*mu.Key, aws.ToString(in.Prefix)) {
+			uploads = append(uploads, mu)
+		}
+	}
+	i := 0
+	if in.UploadIdMarker != nil {
+		for i < len(uploads) && *uploads[i].UploadId != *in.UploadIdMarker {
+			i++
+		}
+		i++
+	}
+	out := &s3.ListMultipartUploadsOutput{IsTruncated: aws.Bool(i+1 < len(uploads))}
+	if i < len(uploads) {
+		mu := uploads[i]
+		out.Uploads = []types.MultipartUpload{mu}
+		out.NextKeyMarker, out.NextUploadIdMarker = mu.Key, mu.UploadId
+	}
+	return out, nil
+}
+
+func (c *multipartClient) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if *in.UploadId == c.failing {
+		return nil, statusError(500)
+	}
+	c.aborted = append(c.aborted, *in.UploadId)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
@@ -0,0 +1,95 @@
+package os
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics records the calls made to the bucket, the uploads written to it and
+// the expired blobs deleted from it.
+type Metrics struct {
+	calls          *prometheus.HistogramVec
+	errors         *prometheus.CounterVec
+	parts          prometheus.Histogram
+	bytes          prometheus.Counter
+	reclaimed      prometheus.Counter
+	reclaimedBytes prometheus.Counter
+}
+
+// NewMetrics returns [Metrics] registered with reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		calls: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "blob",
+			Subsystem: "s3",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of S3 API calls including retries.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "blob",
+			Subsystem: "s3",
+			Name:      "request_errors_total",
+			Help:      "Failed S3 API calls by status code, 0 if no response was received.",
+		}, []string{"operation", "code"}),
+		parts: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "blob",
+			Subsystem: "upload",
+			Name:      "parts",
+			Help:      "Number of parts each upload was written in.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "blob",
+			Subsystem: "upload",
+			Name:      "bytes_total",
+			Help:      "Bytes written to the bucket by completed uploads.",
+		}),
+		reclaimed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "blob",
+			Subsystem: "expiry",
+			Name:      "reclaimed_total",
+			Help:      "Expired blobs deleted from the bucket.",
+		}),
+		reclaimedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "blob",
+			Subsystem: "expiry",
+			Name:      "reclaimed_bytes_total",
+			Help:      "Bytes of the expired blobs deleted from the bucket.",
+		}),
+	}
+	reg.MustRegister(m.calls, m.errors, m.parts, m.bytes, m.reclaimed, m.reclaimedBytes)
+	return m
+}
+
+// Report records a completed upload. It can be used as [Uploader.Report].
+func (m *Metrics) Report(stats UploadStats) {
+	m.parts.Observe(float64(stats.Parts))
+	m.bytes.Add(float64(stats.Size))
+}
+
+// Reclaim records expired blobs that were deleted. It can be used as the
+// Report of a reaper.
+func (m *Metrics) Reclaim(n int, size int64) {
+	m.reclaimed.Add(float64(n))
+	m.reclaimedBytes.Add(float64(size))
+}
+
+// Middleware adds the instrumentation of each call to the stack of an S3
+// client. It is added to the APIOptions of the client.
+func (m *Metrics) Middleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("BlobMetrics", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		start := time.Now()
+		out, md, err := next.HandleInitialize(ctx, in)
+		op := middleware.GetOperationName(ctx)
+		m.calls.WithLabelValues(op).Observe(time.Since(start).Seconds())
+		if err != nil {
+			m.errors.WithLabelValues(op, strconv.Itoa(statusCode(err))).Inc()
+		}
+		return out, md, err
+	}), middleware.Before)
+}
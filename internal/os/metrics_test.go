@@ -0,0 +1,45 @@
+package os_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "go.adoublef/blob/internal/os"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Metrics(t *testing.T) {
+	t.Run("Report", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		m := NewMetrics(reg)
+		m.Report(UploadStats{Size: 1 << 20, Parts: 1})
+		m.Report(UploadStats{Size: 3 << 23, Parts: 3})
+
+		err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP blob_upload_bytes_total Bytes written to the bucket by completed uploads.
+# TYPE blob_upload_bytes_total counter
+blob_upload_bytes_total 2.62144e+07
+`), "blob_upload_bytes_total")
+		is.OK(t, err) // compare upload bytes
+		n, err := testutil.GatherAndCount(reg, "blob_upload_parts")
+		is.OK(t, err) // count upload parts
+		is.Equal(t, n, 1)
+	})
+	t.Run("Reclaim", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		m := NewMetrics(reg)
+		m.Reclaim(2, 1<<10)
+
+		err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP blob_expiry_reclaimed_total Expired blobs deleted from the bucket.
+# TYPE blob_expiry_reclaimed_total counter
+blob_expiry_reclaimed_total 2
+# HELP blob_expiry_reclaimed_bytes_total Bytes of the expired blobs deleted from the bucket.
+# TYPE blob_expiry_reclaimed_bytes_total counter
+blob_expiry_reclaimed_bytes_total 1024
+`), "blob_expiry_reclaimed_total", "blob_expiry_reclaimed_bytes_total")
+		is.OK(t, err) // compare reclaimed blobs
+	})
+}
@@ -1,48 +1,166 @@
 package os
 
 import (
+	"context"
+	"errors"
 	"io"
+	"io/fs"
+	"path"
+	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotExist = fs.ErrNotExist // "file does not exist"
 )
 
 type Client struct {
 	*Uploader
 	*Downloader
+	bucket string
+	c      s3Client
+	// Namespace, if set, prefixes the key of each blob so that clients of
+	// different namespaces cannot reach each other's blobs in a shared
+	// bucket.
+	Namespace string
 }
 
 type s3Client interface {
 	manager.UploadAPIClient
 	manager.DownloadAPIClient
+	HeadBucket(context.Context, *s3.HeadBucketInput, ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+	DeleteObject(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	ListObjectsV2(context.Context, *s3.ListObjectsV2Input, ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
 }
 
 // New returns a new [Client]
-func New(bucket string, c s3Client) *Client {
-	return &Client{
+func New(bucket string, c s3Client, opts ...func(*Client)) *Client {
+	oc := &Client{
 		Uploader:   NewUploader(bucket, c),
 		Downloader: NewDownloader(bucket, c),
+		bucket:     bucket,
+		c:          c,
+	}
+	for _, o := range opts {
+		o(oc)
+	}
+	oc.Uploader.Namespace, oc.Downloader.Namespace = oc.Namespace, oc.Namespace
+	return oc
+}
+
+// Check reports whether the bucket can be reached with the configured
+// credentials.
+func (c *Client) Check(ctx context.Context) error {
+	_, err := c.c.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &c.bucket})
+	return err
+}
+
+// Delete removes the blob along with all of its versions. Removing a blob
+// that does not exist is not an error.
+func (c *Client) Delete(ctx context.Context, id uuid.UUID) error {
+	// versions share the key of the blob as a prefix
+	p := s3.NewListObjectsV2Paginator(c.c, &s3.ListObjectsV2Input{
+		Bucket: &c.bucket,
+		Prefix: aws.String(blobKey(c.Namespace, id)),
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, o := range out.Contents {
+			if err := c.delete(ctx, aws.ToString(o.Key)); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
+
+// Blobs calls fn with the id, size and modification time of the first version
+// of each blob in the namespace.
+func (c *Client) Blobs(ctx context.Context, fn func(id uuid.UUID, size int64, modified time.Time) error) error {
+	prefix := path.Join(c.Namespace, "_blob") + "/"
+	p := s3.NewListObjectsV2Paginator(c.c, &s3.ListObjectsV2Input{
+		Bucket: &c.bucket,
+		Prefix: &prefix,
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, o := range out.Contents {
+			// later versions have a suffix after the id
+			id, err := uuid.Parse(strings.ReplaceAll(strings.TrimPrefix(aws.ToString(o.Key), prefix), "/", ""))
+			if err != nil {
+				continue
+			}
+			if err := fn(id, aws.ToInt64(o.Size), aws.ToTime(o.LastModified)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteVersion removes a single version of the blob.
+func (c *Client) DeleteVersion(ctx context.Context, id, vid uuid.UUID) error {
+	return c.delete(ctx, versionKey(c.Namespace, id, vid))
+}
+
+func (c *Client) delete(ctx context.Context, key string) error {
+	_, err := c.c.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &c.bucket,
+		Key:    &key,
+	})
+	return err
 }
 
 type countReader struct {
 	n atomic.Int64
 	r io.Reader
+	p *progress
 }
 
 func (c *countReader) Read(p []byte) (int, error) {
 	nr, err := c.r.Read(p)
 	if nr > 0 {
-		c.n.Add(int64(nr))
+		c.p.receive(c.n.Add(int64(nr)))
 	}
 	return nr, err
 }
 
-type writerAt struct {
-	io.Writer
+// blobKey returns the key of the object holding the blob in the namespace.
+func blobKey(ns string, id uuid.UUID) string {
+	// https://stackoverflow.com/questions/44852649/evenly-spread-files-in-directories-using-uuid-splits
+	// given a uuid, create a 2-level directory
+	// uuid does not _need_ to be sortable
+	// 01/23/456789...
+	s := strings.Replace(id.String(), "-", "", 4)
+	return path.Join(ns, "_blob", s[:2], s[2:4], s[4:])
 }
 
-func (w *writerAt) WriteAt(p []byte, _ int64) (n int, err error) {
-	// ignore 'offset' because we forced sequential downloads
-	return w.Writer.Write(p)
+// versionKey returns the key of the object holding a version of the blob.
+// The first version has a nil id and is held by the key of the blob.
+func versionKey(ns string, id, vid uuid.UUID) string {
+	if vid == uuid.Nil {
+		return blobKey(ns, id)
+	}
+	return blobKey(ns, id) + "." + strings.Replace(vid.String(), "-", "", 4)
+}
+
+// statusCode returns the HTTP status code of a failed S3 request.
+func statusCode(err error) int {
+	var re interface{ HTTPStatusCode() int }
+	if !errors.As(err, &re) {
+		return 0
+	}
+	return re.HTTPStatusCode()
 }
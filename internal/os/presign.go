@@ -0,0 +1,268 @@
+package os
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultPresignExpires  = 15 * time.Minute
+	DefaultPresignMaxParts = 100
+
+	MaxObjectSize = 5 << 40 // 5TB
+)
+
+// ErrInvalidUpload is returned when a presigned upload cannot be completed,
+// such as when parts are missing or were never uploaded.
+var ErrInvalidUpload = errors.New("upload is incomplete")
+
+type presignAPIClient interface {
+	HeadObject(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	CreateMultipartUpload(context.Context, *s3.CreateMultipartUploadInput, ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	CompleteMultipartUpload(context.Context, *s3.CompleteMultipartUploadInput, ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+}
+
+type presignClient interface {
+	PresignGetObject(context.Context, *s3.GetObjectInput, ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+	PresignUploadPart(context.Context, *s3.UploadPartInput, ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+// PresignedRequest is a request that can be sent to the bucket without
+// credentials until it expires.
+type PresignedRequest struct {
+	Method string
+	URL    string
+	// Header must be sent with the request as it is part of the signature.
+	Header http.Header
+}
+
+// PresignedUpload describes how to upload a blob directly to the bucket.
+type PresignedUpload struct {
+	ID uuid.UUID
+	// UploadID identifies the multipart upload. Uploads are always
+	// multipart so that those never completed are aborted by the [Janitor].
+	UploadID string
+	// Parts are sent in order, each holding PartSize bytes except the last.
+	// Only the first of the PartCount parts are presigned, the rest by
+	// [Presigner.PresignParts].
+	Parts     []PresignedRequest
+	PartCount int32
+	PartSize  int64
+	Expires   time.Time
+}
+
+// CompletedPart is a part of a presigned multipart upload.
+type CompletedPart struct {
+	Number int32
+	ETag   string
+}
+
+// Presigner hands out requests that transfer blobs to and from the bucket
+// without passing through the server.
+type Presigner struct {
+	bucket string
+	c      presignAPIClient
+	p      presignClient
+	// PartSize is the smallest part of a multipart upload. Uploads no
+	// larger than PartSize are sent as a single part.
+	PartSize int64
+	// Expires is how long a presigned request is valid for.
+	Expires time.Duration
+	// MaxParts is the most parts of an upload presigned at once.
+	MaxParts int32
+	// Report, if set, is called after each completed upload.
+	Report func(UploadStats)
+	// Namespace, if set, prefixes the key of each blob.
+	Namespace string
+}
+
+// PresignUpload starts the upload of a new blob of the given size.
+func (p *Presigner) PresignUpload(ctx context.Context, size int64) (PresignedUpload, error) {
+	if size < 0 || size > MaxObjectSize {
+		return PresignedUpload{}, fmt.Errorf("size must be between 0 and %d bytes", int64(MaxObjectSize))
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return PresignedUpload{}, err
+	}
+	key := blobKey(p.Namespace, id)
+	pu := PresignedUpload{ID: id, PartSize: min(p.partSize(size), size), Expires: time.Now().Add(p.Expires)}
+	pu.PartCount = partCount(size, pu.PartSize)
+	out, err := p.c.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: &p.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return PresignedUpload{}, err
+	}
+	pu.UploadID = aws.ToString(out.UploadId)
+	pu.Parts, err = p.presignParts(ctx, key, pu.UploadID, size, pu.PartSize, 1, min(pu.PartCount, p.MaxParts))
+	if err != nil {
+		// parts are never uploaded so the janitor aborts it
+		return PresignedUpload{}, err
+	}
+	return pu, nil
+}
+
+// PresignParts presigns up to [Presigner.MaxParts] parts of an upload of the
+// given size, starting from part number first.
+func (p *Presigner) PresignParts(ctx context.Context, id uuid.UUID, uploadID string, size int64, first int32) ([]PresignedRequest, time.Time, error) {
+	if size < 0 || size > MaxObjectSize {
+		return nil, time.Time{}, fmt.Errorf("size must be between 0 and %d bytes", int64(MaxObjectSize))
+	}
+	ps := min(p.partSize(size), size)
+	n := partCount(size, ps)
+	if uploadID == "" || first < 1 || first > n {
+		return nil, time.Time{}, ErrInvalidUpload
+	}
+	expires := time.Now().Add(p.Expires)
+	parts, err := p.presignParts(ctx, blobKey(p.Namespace, id), uploadID, size, ps, first, min(n, first+p.MaxParts-1))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return parts, expires, nil
+}
+
+// presignParts presigns the parts numbered first to last of an upload of the
+// given size, each holding ps bytes except the last.
+func (p *Presigner) presignParts(ctx context.Context, key, uploadID string, size, ps int64, first, last int32) ([]PresignedRequest, error) {
+	parts := make([]PresignedRequest, 0, last-first+1)
+	for num := first; num <= last; num++ {
+		off := int64(num-1) * ps
+		req, err := p.p.PresignUploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        &p.bucket,
+			Key:           &key,
+			UploadId:      &uploadID,
+			PartNumber:    aws.Int32(num),
+			ContentLength: aws.Int64(min(ps, size-off)),
+		}, s3.WithPresignExpires(p.Expires))
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, presignedRequest(req))
+	}
+	return parts, nil
+}
+
+// partCount returns the number of parts of ps bytes holding size bytes. An
+// empty blob is sent as a single empty part.
+func partCount(size, ps int64) int32 {
+	if ps == 0 {
+		return 1
+	}
+	return int32((size + ps - 1) / ps)
+}
+
+// partSize returns the part size of a multipart upload of the given size,
+// rounded up to a whole MB to stay within [MaxUploadParts].
+func (p *Presigner) partSize(size int64) int64 {
+	if ps := (size + MaxUploadParts - 1) / MaxUploadParts; ps > p.PartSize {
+		return min((ps+1<<20-1)&^(1<<20-1), MaxPartSize)
+	}
+	return p.PartSize
+}
+
+// Complete finishes a presigned upload, returning the size and etag of the
+// blob.
+func (p *Presigner) Complete(ctx context.Context, id uuid.UUID, uploadID string, parts []CompletedPart) (sz int64, etag string, err error) {
+	if uploadID == "" || len(parts) == 0 {
+		return 0, "", ErrInvalidUpload
+	}
+	key := blobKey(p.Namespace, id)
+	cps := make([]types.CompletedPart, len(parts))
+	for i, part := range parts {
+		cps[i] = types.CompletedPart{PartNumber: aws.Int32(part.Number), ETag: aws.String(part.ETag)}
+	}
+	slices.SortFunc(cps, func(a, b types.CompletedPart) int {
+		return int(*a.PartNumber - *b.PartNumber)
+	})
+	_, err = p.c.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &p.bucket,
+		Key:             &key,
+		UploadId:        &uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: cps},
+	})
+	if code := statusCode(err); code >= 400 && code < 500 {
+		return 0, "", fmt.Errorf("%w: %v", ErrInvalidUpload, err)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	out, err := p.head(ctx, key)
+	if errors.Is(err, ErrNotExist) {
+		return 0, "", ErrInvalidUpload
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	sz = aws.ToInt64(out.ContentLength)
+	if p.Report != nil {
+		// the part size follows from the size, which was fixed when presigned
+		p.Report(UploadStats{Size: sz, Parts: len(parts), PartSize: min(p.partSize(sz), sz)})
+	}
+	return sz, aws.ToString(out.ETag), nil
+}
+
+// PresignDownload returns a request for the content of a version of the
+// blob, where the first version has a nil id.
+func (p *Presigner) PresignDownload(ctx context.Context, id, vid uuid.UUID) (PresignedRequest, time.Time, error) {
+	key := versionKey(p.Namespace, id, vid)
+	if _, err := p.head(ctx, key); err != nil {
+		return PresignedRequest{}, time.Time{}, err
+	}
+	expires := time.Now().Add(p.Expires)
+	req, err := p.p.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: &p.bucket,
+		Key:    &key,
+	}, s3.WithPresignExpires(p.Expires))
+	if err != nil {
+		return PresignedRequest{}, time.Time{}, err
+	}
+	return presignedRequest(req), expires, nil
+}
+
+func (p *Presigner) head(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
+	out, err := p.c.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &p.bucket,
+		Key:    &key,
+	})
+	if statusCode(err) == http.StatusNotFound {
+		return nil, ErrNotExist
+	}
+	return out, err
+}
+
+func presignedRequest(req *v4.PresignedHTTPRequest) PresignedRequest {
+	h := req.SignedHeader.Clone()
+	// set by the client when sending the request
+	h.Del("Host")
+	return PresignedRequest{Method: req.Method, URL: req.URL, Header: h}
+}
+
+// NewPresigner returns a [Presigner] of requests to the bucket. The requests
+// are signed by p and the uploads are started and completed by c.
+func NewPresigner(bucket string, c presignAPIClient, p presignClient, opts ...func(*Presigner)) *Presigner {
+	ps := &Presigner{
+		bucket:   bucket,
+		c:        c,
+		p:        p,
+		PartSize: DefaultUploadPartSize,
+		Expires:  DefaultPresignExpires,
+		MaxParts: DefaultPresignMaxParts,
+	}
+	for _, o := range opts {
+		o(ps)
+	}
+	return ps
+}
@@ -0,0 +1,152 @@
+package os_test
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	. "go.adoublef/blob/internal/os"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Presigner(t *testing.T) {
+	t.Run("PresignUpload", func(t *testing.T) {
+		for _, tc := range []struct {
+			size     int64
+			parts    int
+			partSize int64
+		}{
+			{size: 0, parts: 1, partSize: 0},
+			{size: 1 << 10, parts: 1, partSize: 1 << 10},
+			{size: 1<<10 + 1, parts: 2, partSize: 1 << 10},
+			{size: 5 << 10, parts: 5, partSize: 1 << 10},
+		} {
+			c := &presignedClient{}
+			p := NewPresigner("bucket", c, newPresignClient(), func(p *Presigner) { p.PartSize = 1 << 10 })
+
+			pu, err := p.PresignUpload(context.Background(), tc.size)
+			is.OK(t, err) // presign upload
+			is.Equal(t, len(pu.Parts), tc.parts)
+			is.Equal(t, pu.PartSize, tc.partSize)
+			is.Equal(t, pu.UploadID, "upload") // always multipart
+			for i, part := range pu.Parts {
+				is.Equal(t, part.Method, "PUT")
+				u, err := url.Parse(part.URL)
+				is.OK(t, err) // parse presigned url
+				is.True(t, u.Query().Has("X-Amz-Signature"))
+				is.Equal(t, u.Query().Get("partNumber"), strconv.Itoa(i+1))
+			}
+		}
+	})
+
+	t.Run("PresignParts", func(t *testing.T) {
+		c := &presignedClient{}
+		p := NewPresigner("bucket", c, newPresignClient(), func(p *Presigner) { p.PartSize, p.MaxParts = 1<<10, 2 })
+
+		pu, err := p.PresignUpload(context.Background(), 5<<10)
+		is.OK(t, err) // presign upload
+		is.Equal(t, len(pu.Parts), 2)
+		is.Equal(t, pu.PartCount, int32(5))
+
+		for first, want := range map[int32]int{3: 2, 5: 1} {
+			parts, _, err := p.PresignParts(context.Background(), pu.ID, pu.UploadID, 5<<10, first)
+			is.OK(t, err) // presign remaining parts
+			is.Equal(t, len(parts), want)
+			for i, part := range parts {
+				u, err := url.Parse(part.URL)
+				is.OK(t, err) // parse presigned url
+				is.Equal(t, u.Query().Get("partNumber"), strconv.Itoa(int(first)+i))
+			}
+		}
+
+		for _, first := range []int32{0, 6} {
+			_, _, err = p.PresignParts(context.Background(), pu.ID, pu.UploadID, 5<<10, first)
+			is.NotOK(t, err, ErrInvalidUpload)
+		}
+	})
+
+	t.Run("ErrSize", func(t *testing.T) {
+		p := NewPresigner("bucket", &presignedClient{}, newPresignClient())
+		_, err := p.PresignUpload(context.Background(), MaxObjectSize+1)
+		is.True(t, err != nil) // reject oversized upload
+	})
+
+	t.Run("Complete", func(t *testing.T) {
+		var stats UploadStats
+		c := &presignedClient{size: 14, etag: `"etag"`}
+		p := NewPresigner("bucket", c, newPresignClient(), func(p *Presigner) { p.Report = func(s UploadStats) { stats = s } })
+
+		sz, etag, err := p.Complete(context.Background(), uuid.New(), "upload", []CompletedPart{{2, `"b"`}, {1, `"a"`}})
+		is.OK(t, err) // complete upload
+		is.Equal(t, sz, int64(14))
+		is.Equal(t, etag, `"etag"`)
+		is.Equal(t, c.completed, []int32{1, 2})
+		is.Equal(t, stats.Parts, 2)
+		is.Equal(t, stats.PartSize, int64(14))
+	})
+
+	t.Run("ErrInvalidUpload", func(t *testing.T) {
+		p := NewPresigner("bucket", &presignedClient{}, newPresignClient())
+		_, _, err := p.Complete(context.Background(), uuid.New(), "", nil)
+		is.NotOK(t, err, ErrInvalidUpload)
+
+		_, _, err = p.Complete(context.Background(), uuid.New(), "upload", nil)
+		is.NotOK(t, err, ErrInvalidUpload)
+
+		_, _, err = p.Complete(context.Background(), uuid.New(), "upload", []CompletedPart{{1, `"a"`}})
+		is.NotOK(t, err, ErrInvalidUpload)
+	})
+
+	t.Run("PresignDownload", func(t *testing.T) {
+		p := NewPresigner("bucket", &presignedClient{size: 14}, newPresignClient())
+		req, _, err := p.PresignDownload(context.Background(), uuid.New(), uuid.Nil)
+		is.OK(t, err) // presign download
+		is.Equal(t, req.Method, "GET")
+
+		p = NewPresigner("bucket", &presignedClient{}, newPresignClient())
+		_, _, err = p.PresignDownload(context.Background(), uuid.New(), uuid.Nil)
+		is.NotOK(t, err, ErrNotExist)
+	})
+}
+
+// presignedClient holds a single object once size is set.
+type presignedClient struct {
+	size      int64
+	etag      string
+	completed []int32
+}
+
+func (c *presignedClient) HeadObject(ctx context.Context, in *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if c.size == 0 {
+		return nil, statusError(404)
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(c.size), ETag: aws.String(c.etag)}, nil
+}
+
+func (c *presignedClient) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload")}, nil
+}
+
+func (c *presignedClient) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	if c.size == 0 {
+		return nil, statusError(400) // InvalidPart
+	}
+	for _, p := range in.MultipartUpload.Parts {
+		c.completed = append(c.completed, aws.ToInt32(p.PartNumber))
+	}
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func newPresignClient() *s3.PresignClient {
+	return s3.NewPresignClient(s3.New(s3.Options{
+		Region:       "auto",
+		BaseEndpoint: aws.String("http://s3.test"),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("id", "secret", ""),
+	}))
+}
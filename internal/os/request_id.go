@@ -0,0 +1,28 @@
+package os
+
+import (
+	"context"
+
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// headerRequestID is sent with each call so that the logs of the bucket can
+// be matched with those of the server.
+const headerRequestID = "X-Request-Id"
+
+// RequestIDMiddleware returns a middleware for the stack of an S3 client that
+// sends the id returned for the context of each call, if any. It is added to
+// the APIOptions of the client.
+func RequestIDMiddleware(id func(context.Context) (string, bool)) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Build.Add(middleware.BuildMiddlewareFunc("BlobRequestID", func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+			if req, ok := in.Request.(*smithyhttp.Request); ok {
+				if s, ok := id(ctx); ok {
+					req.Header.Set(headerRequestID, s)
+				}
+			}
+			return next.HandleBuild(ctx, in)
+		}), middleware.After)
+	}
+}
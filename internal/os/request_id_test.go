@@ -0,0 +1,39 @@
+package os_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	. "go.adoublef/blob/internal/os"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_RequestIDMiddleware(t *testing.T) {
+	got := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("X-Request-Id")
+	}))
+	t.Cleanup(ts.Close)
+
+	type key struct{}
+	id := func(ctx context.Context) (string, bool) {
+		s, ok := ctx.Value(key{}).(string)
+		return s, ok
+	}
+	c := s3.New(s3.Options{
+		Region:       "auto",
+		BaseEndpoint: aws.String(ts.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+		APIOptions:   []func(*middleware.Stack) error{RequestIDMiddleware(id)},
+	})
+	ctx := context.WithValue(context.Background(), key{}, "abc123")
+	_, err := c.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("bucket")})
+	is.OK(t, err) // head bucket
+	is.Equal(t, <-got, "abc123")
+}
@@ -0,0 +1,47 @@
+package os
+
+import (
+	"context"
+
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer starts a span for each call made to the bucket.
+type Tracer struct {
+	t trace.Tracer
+}
+
+// NewTracer returns a [Tracer] using tp.
+func NewTracer(tp trace.TracerProvider) *Tracer {
+	return &Tracer{t: tp.Tracer("go.adoublef/blob/internal/os")}
+}
+
+// Middleware adds the spans of each call to the stack of an S3 client.
+// It is added to the APIOptions of the client.
+func (t *Tracer) Middleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("BlobTracer", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		op := middleware.GetOperationName(ctx)
+		ctx, span := t.t.Start(ctx, "S3."+op,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.RPCSystemKey.String("aws-api"),
+				semconv.RPCService("S3"),
+				semconv.RPCMethod(op),
+			),
+		)
+		defer span.End()
+
+		out, md, err := next.HandleInitialize(ctx, in)
+		if code := statusCode(err); code != 0 {
+			span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return out, md, err
+	}), middleware.Before)
+}
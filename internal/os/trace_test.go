@@ -0,0 +1,50 @@
+package os_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	. "go.adoublef/blob/internal/os"
+	"go.adoublef/blob/internal/testing/is"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func Test_Tracer(t *testing.T) {
+	for _, tc := range []struct {
+		code int
+		want codes.Code
+	}{
+		{http.StatusOK, codes.Unset},
+		{http.StatusNotFound, codes.Error},
+	} {
+		t.Run(http.StatusText(tc.code), func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.code)
+			}))
+			t.Cleanup(ts.Close)
+
+			exp := tracetest.NewInMemoryExporter()
+			tr := NewTracer(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)))
+			c := s3.New(s3.Options{
+				Region:       "auto",
+				BaseEndpoint: aws.String(ts.URL),
+				UsePathStyle: true,
+				Credentials:  aws.AnonymousCredentials{},
+				APIOptions:   []func(*middleware.Stack) error{tr.Middleware},
+			})
+			_, _ = c.HeadBucket(context.Background(), &s3.HeadBucketInput{Bucket: aws.String("bucket")})
+
+			spans := exp.GetSpans()
+			is.Equal(t, len(spans), 1)
+			is.Equal(t, spans[0].Name, "S3.HeadBucket")
+			is.Equal(t, spans[0].Status.Code, tc.want)
+		})
+	}
+}
@@ -1,50 +1,403 @@
 package os
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
-	"path"
-	"strings"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
-	"go.adoublef/blob/internal/runtime/debug"
+	"go.adoublef/blob/internal/log"
 )
 
+const (
+	DefaultAbortTimeout      = 30 * time.Second
+	DefaultUploadPartSize    = 1 << 23 // 8MB
+	DefaultUploadConcurrency = manager.DefaultUploadConcurrency
+
+	MaxUploadParts = int64(manager.MaxUploadParts)
+	MaxPartSize    = 5 << 30 // 5GB
+)
+
+// partGrowth is the number of parts after which the part size of an upload
+// of unknown size is doubled. Starting at 8MB this allows objects of up to
+// 8MB * 1000 * (2^10 - 1) ~ 8TB, more than the 5TB allowed by S3.
+const partGrowth = 1000
+
+// UploadStats describes a completed upload.
+type UploadStats struct {
+	Size     int64
+	Parts    int
+	PartSize int64 // size of the largest part
+	Elapsed  time.Duration
+}
+
+// Throughput returns the bytes uploaded per second.
+func (s UploadStats) Throughput() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Size) / s.Elapsed.Seconds()
+}
+
+// Progress describes an upload in progress.
+type Progress struct {
+	Received  int64 // bytes read from the content
+	Committed int64 // bytes stored in the bucket
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context in which an upload calls report each time
+// more of its content is read or stored. Parts are stored concurrently so
+// report must be safe to call from several goroutines, and should not block.
+func WithProgress(ctx context.Context, report func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, &progress{report: report})
+}
+
+type progress struct {
+	report              func(Progress)
+	received, committed atomic.Int64
+}
+
+// progressOf returns the progress of the upload in the context, or nil.
+func progressOf(ctx context.Context) *progress {
+	p, _ := ctx.Value(progressKey{}).(*progress)
+	return p
+}
+
+func (p *progress) receive(n int64) {
+	if p == nil {
+		return
+	}
+	p.received.Store(n)
+	p.report(Progress{Received: n, Committed: p.committed.Load()})
+}
+
+func (p *progress) commit(n int64) {
+	if p == nil {
+		return
+	}
+	c := p.committed.Add(n)
+	p.report(Progress{Received: p.received.Load(), Committed: c})
+}
+
 type Uploader struct {
 	bucket string
-	// using the manager util for now
-	m *manager.Uploader
+	c      manager.UploadAPIClient
+	// PartSize is the size of each part when the size of the upload is not
+	// known. It is doubled every 1000 parts to stay within [MaxUploadParts].
+	PartSize int64
+	// Concurrency is the number of parts uploaded at the same time.
+	// A single upload holds at most Concurrency+1 parts in memory.
+	Concurrency int
+	// Buffers provides the memory each part is read into.
+	Buffers BufferPool
+	// Report, if set, is called after each successful upload.
+	Report func(UploadStats)
+	// Namespace, if set, prefixes the key of each blob.
+	Namespace string
 }
 
-func (u Uploader) Upload(ctx context.Context, r io.Reader) (id uuid.UUID, sz int64, err error) {
+// Upload stores the content of r as a new blob. The size is a hint of how
+// large r is, or -1 if unknown. Hints of more than 1000 parts are treated as
+// unknown.
+func (u *Uploader) Upload(ctx context.Context, r io.Reader, size int64) (id uuid.UUID, sz int64, err error) {
 	id, err = uuid.NewV7()
 	if err != nil {
 		return uuid.Nil, 0, err
 	}
-	// https://stackoverflow.com/questions/44852649/evenly-spread-files-in-directories-using-uuid-splits
-	// given a uuid, create a 2-level directory
-	// uuid does not _need_ to be sortable
-	// 01/23/456789...
-	s := strings.Replace(id.String(), "-", "", 4)
-	uri := path.Join("_blob", s[:2], s[2:4], s[4:])
-	cr := &countReader{r: r}
-	in := &s3.PutObjectInput{
-		Key:    &uri,
-		Bucket: &u.bucket,
-		Body:   cr,
+	sz, err = u.uploadKey(ctx, blobKey(u.Namespace, id), r, size)
+	if err != nil {
+		return uuid.Nil, 0, err
 	}
-	out, err := u.m.Upload(ctx, in)
+	return id, sz, nil
+}
+
+// UploadVersion stores the content of r as a new version of the blob,
+// returning the id of the version. The version is not current until it is
+// recorded as such.
+func (u *Uploader) UploadVersion(ctx context.Context, id uuid.UUID, r io.Reader, size int64) (vid uuid.UUID, sz int64, err error) {
+	vid, err = uuid.NewV7()
 	if err != nil {
 		return uuid.Nil, 0, err
 	}
-	debug.Printf("%v := out.ETag", out.ETag)
-	return id, cr.n.Load(), nil
+	sz, err = u.uploadKey(ctx, versionKey(u.Namespace, id, vid), r, size)
+	if err != nil {
+		return uuid.Nil, 0, err
+	}
+	return vid, sz, nil
+}
+
+func (u *Uploader) uploadKey(ctx context.Context, uri string, r io.Reader, size int64) (int64, error) {
+	cr := &countReader{r: r, p: progressOf(ctx)}
+	start := time.Now()
+	stats, err := u.upload(ctx, uri, cr, size)
+	if err != nil {
+		return 0, err
+	}
+	stats.Size, stats.Elapsed = cr.n.Load(), time.Since(start)
+	log.FromContext(ctx).DebugContext(ctx, "uploaded blob",
+		slog.String("key", uri),
+		slog.Int("parts", stats.Parts),
+		slog.Int64("partSize", stats.PartSize),
+		slog.Float64("throughput", stats.Throughput()),
+	)
+	if u.Report != nil {
+		u.Report(stats)
+	}
+	return stats.Size, nil
 }
 
-func NewUploader(bucket string, c manager.UploadAPIClient) *Uploader {
-	o := func(u *manager.Uploader) { u.PartSize = 1 << 24 }
-	u := manager.NewUploader(c, o)
-	return &Uploader{bucket, u}
+// partSize returns the size of the first part for an upload of the given size.
+func (u *Uploader) partSize(size int64) int64 {
+	if size < 0 {
+		return u.PartSize
+	}
+	if size < u.PartSize {
+		// one more byte tells if the hint was too small
+		return size + 1
+	}
+	// hints are at most partGrowth parts so stay within the part limit
+	return u.PartSize
+}
+
+func (u *Uploader) upload(ctx context.Context, key string, r io.Reader, size int64) (UploadStats, error) {
+	if size > u.PartSize*partGrowth {
+		// the hint may be forged, so parts large enough for it are only
+		// allocated as the content arrives, as if the size were unknown
+		size = -1
+	}
+	ps := u.partSize(size)
+	buf, err := u.read(r, ps)
+	if err == nil && int64(len(buf)) == ps && ps < u.PartSize {
+		// the hint was too small, continue as if the size were unknown
+		buf, err = u.grow(r, buf, u.PartSize)
+		ps, size = u.PartSize, -1
+	}
+	if err != nil {
+		u.Buffers.Put(buf)
+		return UploadStats{}, err
+	}
+	if int64(len(buf)) < ps {
+		// fits in a single request
+		defer u.Buffers.Put(buf)
+		_, err = u.c.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:        &u.bucket,
+			Key:           &key,
+			Body:          bytes.NewReader(buf),
+			ContentLength: aws.Int64(int64(len(buf))),
+		})
+		if err == nil {
+			progressOf(ctx).commit(int64(len(buf)))
+		}
+		return UploadStats{Parts: 1, PartSize: int64(len(buf))}, err
+	}
+
+	out, err := u.c.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: &u.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		u.Buffers.Put(buf)
+		return UploadStats{}, err
+	}
+	mu := &multipartUpload{Uploader: u, key: key, id: out.UploadId}
+	stats, err := mu.upload(ctx, r, buf, ps, size)
+	if err != nil {
+		mu.abort(ctx)
+		return UploadStats{}, err
+	}
+	return stats, nil
+}
+
+// read reads up to n bytes from r into a buffer from the pool.
+// A buffer shorter than n is returned with a nil error when r is done.
+func (u *Uploader) read(r io.Reader, n int64) ([]byte, error) {
+	buf := u.Buffers.Get(n)
+	m, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf[:m], err
+}
+
+// grow returns a buffer of n bytes starting with the content of buf.
+func (u *Uploader) grow(r io.Reader, buf []byte, n int64) ([]byte, error) {
+	p := u.Buffers.Get(n)
+	m := copy(p, buf)
+	u.Buffers.Put(buf)
+	k, err := io.ReadFull(r, p[m:])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return p[:m+k], err
+}
+
+type multipartUpload struct {
+	*Uploader
+	key string
+	id  *string
+
+	mu    sync.Mutex
+	parts []types.CompletedPart
+	err   error
+}
+
+// upload sends the first part and the rest of r as parts of the upload.
+func (mu *multipartUpload) upload(ctx context.Context, r io.Reader, buf []byte, ps, size int64) (UploadStats, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		sem   = make(chan struct{}, max(1, mu.Concurrency))
+		stats = UploadStats{PartSize: ps}
+		read  int64
+	)
+	for num := int32(1); ; num++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := mu.error(ctx); err != nil {
+			mu.Buffers.Put(buf)
+			break
+		}
+		wg.Add(1)
+		go func(num int32, buf []byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer mu.Buffers.Put(buf)
+			if err := mu.send(ctx, num, buf); err != nil {
+				mu.fail(err)
+				cancel()
+			}
+		}(num, buf)
+		stats.Parts++
+		read += int64(len(buf))
+
+		if int64(len(buf)) < ps {
+			// the last part is the only one allowed to be short
+			break
+		}
+		if (size < 0 || read >= size) && int64(num)%partGrowth == 0 {
+			ps = min(ps*2, MaxPartSize)
+			stats.PartSize = ps
+		}
+		var err error
+		buf, err = mu.read(r, ps)
+		if err != nil {
+			mu.Buffers.Put(buf)
+			mu.fail(err)
+			break
+		}
+		if len(buf) == 0 {
+			mu.Buffers.Put(buf)
+			break
+		}
+		if int64(num)+1 > MaxUploadParts {
+			mu.Buffers.Put(buf)
+			mu.fail(fmt.Errorf("upload exceeds %d parts", MaxUploadParts))
+			break
+		}
+	}
+	wg.Wait()
+	if err := mu.error(ctx); err != nil {
+		return UploadStats{}, err
+	}
+
+	slices.SortFunc(mu.parts, func(a, b types.CompletedPart) int {
+		return int(*a.PartNumber - *b.PartNumber)
+	})
+	_, err := mu.c.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &mu.bucket,
+		Key:             &mu.key,
+		UploadId:        mu.id,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: mu.parts},
+	})
+	return stats, err
+}
+
+func (mu *multipartUpload) send(ctx context.Context, num int32, buf []byte) error {
+	out, err := mu.c.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        &mu.bucket,
+		Key:           &mu.key,
+		UploadId:      mu.id,
+		PartNumber:    aws.Int32(num),
+		Body:          bytes.NewReader(buf),
+		ContentLength: aws.Int64(int64(len(buf))),
+	})
+	if err != nil {
+		return err
+	}
+	progressOf(ctx).commit(int64(len(buf)))
+	mu.mu.Lock()
+	defer mu.mu.Unlock()
+	mu.parts = append(mu.parts, types.CompletedPart{
+		ETag:       out.ETag,
+		PartNumber: aws.Int32(num),
+	})
+	return nil
+}
+
+// fail records the first error of the upload.
+func (mu *multipartUpload) fail(err error) {
+	mu.mu.Lock()
+	defer mu.mu.Unlock()
+	if mu.err == nil {
+		mu.err = err
+	}
+}
+
+func (mu *multipartUpload) error(ctx context.Context) error {
+	mu.mu.Lock()
+	defer mu.mu.Unlock()
+	if mu.err != nil {
+		return mu.err
+	}
+	return ctx.Err()
+}
+
+// abort removes the parts of a failed multipart upload.
+//
+// The upload may have failed because the client went away, so the request
+// is not bound to its context. Parts left behind by a crash are removed by
+// the [Janitor].
+func (mu *multipartUpload) abort(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultAbortTimeout)
+	defer cancel()
+	in := &s3.AbortMultipartUploadInput{
+		Bucket:   &mu.bucket,
+		Key:      &mu.key,
+		UploadId: mu.id,
+	}
+	_, err := mu.c.AbortMultipartUpload(ctx, in)
+	if err != nil {
+		// left for the janitor
+		log.FromContext(ctx).WarnContext(ctx, "failed to abort multipart upload", slog.String("uploadId", aws.ToString(mu.id)), slog.Any("err", err))
+	}
+}
+
+func NewUploader(bucket string, c manager.UploadAPIClient, opts ...func(*Uploader)) *Uploader {
+	u := &Uploader{
+		bucket:      bucket,
+		c:           c,
+		PartSize:    DefaultUploadPartSize,
+		Concurrency: DefaultUploadConcurrency,
+		Buffers:     NewBufferPool(),
+	}
+	for _, o := range opts {
+		o(u)
+	}
+	return u
 }
@@ -0,0 +1,192 @@
+package os_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	. "go.adoublef/blob/internal/os"
+	"go.adoublef/blob/internal/testing/is"
+)
+
+func Test_Uploader(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		type testcase struct {
+			size     int
+			hint     int64
+			partSize int64
+			parts    int
+		}
+
+		for _, tc := range []testcase{
+			{size: 0, hint: -1, partSize: 1 << 10, parts: 1},
+			{size: 100, hint: 100, partSize: 1 << 10, parts: 1},
+			{size: 100, hint: 10, partSize: 1 << 10, parts: 1},
+			{size: 100, hint: -1, partSize: 1 << 10, parts: 1},
+			{size: 1 << 10, hint: -1, partSize: 1 << 10, parts: 1},
+			{size: 5<<10 + 1, hint: 10, partSize: 1 << 10, parts: 6},
+			{size: 5<<10 + 1, hint: 5<<10 + 1, partSize: 1 << 10, parts: 6},
+			// doubles after 1000 parts
+			{size: 1000<<10 + 1000<<11 + 1, hint: -1, partSize: 1 << 10, parts: 2001},
+			// the most parts allowed, each full
+			{size: 1000 * (1<<10 - 1), hint: -1, partSize: 1, parts: 10000},
+		} {
+			p := make([]byte, tc.size)
+			_, err := rand.Read(p)
+			is.OK(t, err) // return random object
+
+			var stats UploadStats
+			c := &uploadClient{}
+			u := NewUploader("bucket", c, func(u *Uploader) {
+				u.PartSize = tc.partSize
+				u.Report = func(s UploadStats) { stats = s }
+			})
+			_, sz, err := u.Upload(context.Background(), bytes.NewReader(p), tc.hint)
+			is.OK(t, err) // upload object
+			is.Equal(t, sz, int64(tc.size))
+			is.Equal(t, stats.Size, int64(tc.size))
+			is.Equal(t, stats.Parts, tc.parts)
+			is.True(t, bytes.Equal(c.object, p)) // got;want object
+		}
+	})
+
+	t.Run("ErrParts", func(t *testing.T) {
+		u := NewUploader("bucket", &uploadClient{}, func(u *Uploader) { u.PartSize = 1 })
+		_, _, err := u.Upload(context.Background(), io.LimitReader(zeroReader{}, 1000*(1<<10-1)+1), -1)
+		is.True(t, err != nil) // reject more than 10000 parts
+	})
+
+	t.Run("Abort", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		c := &uploadClient{cancel: cancel}
+		u := NewUploader("bucket", c)
+
+		// larger than a single part to force a multipart upload
+		r := io.LimitReader(zeroReader{}, 1<<25)
+		_, _, err := u.Upload(ctx, r, -1)
+		is.NotOK(t, err, context.Canceled)
+		is.Equal(t, c.aborted, []string{"upload-id"}) // aborted after the client went away
+	})
+
+	t.Run("Progress", func(t *testing.T) {
+		var (
+			mu   sync.Mutex
+			last Progress
+		)
+		ctx := WithProgress(context.Background(), func(p Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			is.True(t, p.Committed <= p.Received) // stored after read
+			last.Received, last.Committed = max(last.Received, p.Received), max(last.Committed, p.Committed)
+		})
+		u := NewUploader("bucket", &uploadClient{}, func(u *Uploader) { u.PartSize = 1 << 10 })
+		_, sz, err := u.Upload(ctx, io.LimitReader(zeroReader{}, 5<<10+1), -1)
+		is.OK(t, err) // upload object
+		is.Equal(t, last, Progress{Received: sz, Committed: sz})
+	})
+
+	t.Run("Hint", func(t *testing.T) {
+		bp := &maxBufferPool{BufferPool: NewBufferPool()}
+		u := NewUploader("bucket", &uploadClient{}, func(u *Uploader) { u.PartSize, u.Buffers = 1<<10, bp })
+		_, sz, err := u.Upload(context.Background(), strings.NewReader("hello"), 1<<40)
+		is.OK(t, err) // upload object
+		is.Equal(t, sz, int64(5))
+		is.True(t, bp.max <= 1<<10) // parts are not sized by a forged hint
+	})
+
+	t.Run("Namespace", func(t *testing.T) {
+		c := &uploadClient{}
+		u := NewUploader("bucket", c, func(u *Uploader) { u.Namespace = "acme" })
+		_, _, err := u.Upload(context.Background(), strings.NewReader("hello"), 5)
+		is.OK(t, err) // upload object
+		is.True(t, strings.HasPrefix(c.key, "acme/_blob/"))
+	})
+}
+
+// maxBufferPool records the largest buffer taken from the pool.
+type maxBufferPool struct {
+	BufferPool
+	max int64
+}
+
+func (bp *maxBufferPool) Get(n int64) []byte {
+	bp.max = max(bp.max, n)
+	return bp.BufferPool.Get(n)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// uploadClient keeps the last uploaded object in memory.
+type uploadClient struct {
+	mu      sync.Mutex
+	key     string
+	object  []byte
+	parts   map[int32][]byte
+	aborted []string
+	// cancel, if set, is called instead of uploading a part
+	cancel context.CancelFunc
+}
+
+func (c *uploadClient) PutObject(ctx context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	p, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.key, c.object = aws.ToString(in.Key), p
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (c *uploadClient) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.parts = make(map[int32][]byte)
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-id")}, nil
+}
+
+func (c *uploadClient) UploadPart(ctx context.Context, in *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if c.cancel != nil {
+		c.cancel()
+		return nil, context.Canceled
+	}
+	p, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.parts[*in.PartNumber] = p
+	return &s3.UploadPartOutput{ETag: aws.String("etag")}, nil
+}
+
+func (c *uploadClient) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.object = nil
+	for _, part := range in.MultipartUpload.Parts {
+		c.object = append(c.object, c.parts[*part.PartNumber]...)
+	}
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (c *uploadClient) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.aborted = append(c.aborted, *in.UploadId)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}